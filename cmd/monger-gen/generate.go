@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+)
+
+const bsonPath = "gopkg.in/mgo.v2/bson"
+
+// generator writes the code of the schemas of a package
+type generator struct {
+	pkg *pkg
+	buf bytes.Buffer
+	// nested are the fields which get the paths of their struct, the
+	// fields closing a cycle of structs only get their column
+	nested map[*field]bool
+	// visited are the structs which get a type of paths, by state
+	visited map[string]int
+	imports map[string]string
+}
+
+// generate returns the source of the schemas, all of the package without
+// names.
+func generate(p *pkg, names []string) ([]byte, error) {
+	if len(names) == 0 {
+		for _, name := range p.order {
+			if p.isSchema(name) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	g := &generator{
+		pkg:     p,
+		nested:  make(map[*field]bool),
+		visited: make(map[string]int),
+		imports: map[string]string{"monger": mongerPath},
+	}
+
+	for _, name := range names {
+		if !p.isSchema(name) {
+			return nil, fmt.Errorf("%s is not a schema of the package %s", name, p.name)
+		}
+		if err := g.visit(name); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range p.order {
+		if g.visited[name] != 0 {
+			g.writeFields(name)
+		}
+	}
+	for _, name := range names {
+		if err := g.writeSchema(name); err != nil {
+			return nil, err
+		}
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by monger-gen. DO NOT EDIT.\n\npackage %s\n\n", p.name)
+	fmt.Fprintf(&out, "import (\n")
+	for _, std := range []bool{true, false} {
+		for _, name := range sortedKeys(g.imports) {
+			path := g.imports[name]
+			if isStandard(path) != std {
+				continue
+			}
+			if name == importName(path) {
+				fmt.Fprintf(&out, "\t%q\n", path)
+			} else {
+				fmt.Fprintf(&out, "\t%s %q\n", name, path)
+			}
+		}
+		fmt.Fprintf(&out, "\n")
+	}
+	fmt.Fprintf(&out, ")\n")
+	out.Write(g.buf.Bytes())
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("invalid generated code: %v", err)
+	}
+
+	return src, nil
+}
+
+// visit walks the structs held by the fields of a struct, depth first, a
+// field holding a struct being visited closes a cycle.
+func (g *generator) visit(name string) error {
+	if g.visited[name] != 0 {
+		return nil
+	}
+	g.visited[name] = 1
+
+	fields, err := g.pkg.fieldsOf(name)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fields {
+		if f.Nested == "" || g.visited[f.Nested] == 1 {
+			continue
+		}
+		g.nested[f] = true
+		if err := g.visit(f.Nested); err != nil {
+			return err
+		}
+	}
+	g.visited[name] = 2
+
+	return nil
+}
+
+// addImport adds a package used by the generated code
+func (g *generator) addImport(name string, path string) error {
+	if other, ok := g.imports[name]; ok && other != path {
+		return fmt.Errorf("the generated code imports %s as %s and %s, rename one of the imports", name, other, path)
+	}
+	g.imports[name] = path
+
+	return nil
+}
+
+func (g *generator) writeFields(name string) {
+	fields, _ := g.pkg.fieldsOf(name)
+	typeName := "fieldsOf" + name
+
+	g.printf("// %s are the column paths of %s\n", typeName, name)
+	g.printf("type %s struct {\n", typeName)
+	for _, f := range fields {
+		if g.nested[f] {
+			g.printf("\t%s fieldsOf%s\n", f.Name, f.Nested)
+		} else {
+			g.printf("\t%s string\n", f.Name)
+		}
+	}
+	g.printf("}\n\n")
+
+	g.printf("func new%s(prefix string) %s {\n", typeName, typeName)
+	g.printf("\treturn %s{\n", typeName)
+	for _, f := range fields {
+		if g.nested[f] {
+			g.printf("\t\t%s: newfieldsOf%s(prefix + %q),\n", f.Name, f.Nested, f.Column+".")
+		} else {
+			g.printf("\t\t%s: prefix + %q,\n", f.Name, f.Column)
+		}
+	}
+	g.printf("\t}\n}\n\n")
+}
+
+func (g *generator) writeSchema(name string) error {
+	fields, _ := g.pkg.fieldsOf(name)
+
+	g.printf("// %sFields are the column paths of %s\n", name, name)
+	g.printf("var %sFields = newfieldsOf%s(\"\")\n\n", name, name)
+
+	relations := make([]*field, 0)
+	for _, f := range fields {
+		if f.Relation && g.pkg.isSchema(f.Nested) {
+			relations = append(relations, f)
+		}
+	}
+	if len(relations) > 0 {
+		g.printf("// %sRelations are the relations of %s to Populate\n", name, name)
+		g.printf("var %sRelations = struct {\n", name)
+		for _, f := range relations {
+			g.printf("\t%s string\n", f.Name)
+		}
+		g.printf("}{\n")
+		for _, f := range relations {
+			g.printf("\t%s: %q,\n", f.Name, f.Name)
+		}
+		g.printf("}\n\n")
+	}
+
+	g.printf("// %sRepository finds the %s documents by their columns\n", name, name)
+	g.printf("type %sRepository struct {\n\tmonger.Model\n}\n\n", name)
+	g.printf("// New%sRepository returns the repository of the model of %s on conn\n", name, name)
+	g.printf("func New%sRepository(conn monger.Connection) *%sRepository {\n", name, name)
+	g.printf("\treturn &%sRepository{conn.M(new(%s))}\n}\n\n", name, name)
+
+	for _, f := range fields {
+		if !f.Finder {
+			continue
+		}
+
+		for importName, path := range f.Imports {
+			if err := g.addImport(importName, path); err != nil {
+				return err
+			}
+		}
+		if err := g.addImport("bson", bsonPath); err != nil {
+			return err
+		}
+
+		g.printf("// FindBy%s returns the first %s with the %s\n", f.Name, name, f.Column)
+		g.printf("func (r *%sRepository) FindBy%s(value %s) (*%s, error) {\n", name, f.Name, f.Type, name)
+		g.printf("\tdoc := new(%s)\n", name)
+		g.printf("\tif err := r.FindOne(doc, bson.M{%sFields.%s: value}); err != nil {\n", name, f.Name)
+		g.printf("\t\treturn nil, err\n\t}\n\n\treturn doc, nil\n}\n\n")
+
+		g.printf("// FindAllBy%s returns the %s documents with the %s\n", f.Name, name, f.Column)
+		g.printf("func (r *%sRepository) FindAllBy%s(value %s) ([]*%s, error) {\n", name, f.Name, f.Type, name)
+		g.printf("\tdocs := make([]*%s, 0)\n", name)
+		g.printf("\tif err := r.FindAll(&docs, bson.M{%sFields.%s: value}); err != nil {\n", name, f.Name)
+		g.printf("\t\treturn nil, err\n\t}\n\n\treturn docs, nil\n}\n\n")
+	}
+
+	return nil
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// isStandard tells if an import path is of the standard library
+func isStandard(path string) bool {
+	return !strings.Contains(strings.Split(path, "/")[0], ".")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
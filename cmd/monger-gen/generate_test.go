@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const schemas = `package models
+
+import (
+	"time"
+
+	"github.com/iron-kit/monger"
+	mgobson "gopkg.in/mgo.v2/bson"
+)
+
+type Address struct {
+	City string ` + "`bson:\"city\"`" + `
+}
+
+type Member struct {
+	monger.Schema ` + "`bson:\",inline\"`" + `
+	Username      string    ` + "`bson:\"username\"`" + `
+	Age, Rank     int
+	Home          Address   ` + "`bson:\"home\"`" + `
+	Profile       *Profile  ` + "`bson:\"profile\" monger:\"hasOne,foreignKey=user_id\"`" + `
+	Phone         string    ` + "`bson:\"phone\" monger:\"encrypt\"`" + `
+	Secret        string    ` + "`bson:\"-\"`" + `
+	SeenAt        time.Time ` + "`bson:\"seen_at\"`" + `
+}
+
+type Profile struct {
+	monger.Schema ` + "`bson:\",inline\"`" + `
+	Nickname      string           ` + "`bson:\"nickname\"`" + `
+	UserID        mgobson.ObjectId ` + "`bson:\"user_id\"`" + `
+	User          *Member          ` + "`bson:\"user\" monger:\"belongTo,foreignKey=user_id\"`" + `
+}
+
+type VIPMember struct {
+	Member ` + "`bson:\",inline\"`" + `
+	Level  int ` + "`bson:\"level\"`" + `
+}
+
+type Settings struct {
+	Theme string
+}
+`
+
+func writePackage(t *testing.T, src string) string {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "models.go"), []byte(src), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "models_test.go"), []byte("package models_test\n"), 0644))
+
+	return dir
+}
+
+func TestGenerate(t *testing.T) {
+	dir := writePackage(t, schemas)
+	assert.NoError(t, run(dir, "monger_gen.go", ""))
+
+	data, err := os.ReadFile(filepath.Join(dir, "monger_gen.go"))
+	assert.NoError(t, err)
+	src := string(data)
+
+	assert.True(t, strings.HasPrefix(src, "// Code generated by monger-gen. DO NOT EDIT."))
+	assert.Contains(t, src, "\t\"time\"\n\n\t\"github.com/iron-kit/monger\"\n")
+	assert.Contains(t, src, "\tmgobson \"gopkg.in/mgo.v2/bson\"\n")
+
+	// the column paths follow the tags, the inline structs are flattened
+	assert.Contains(t, src, `ID:        prefix + "_id",`)
+	assert.Contains(t, src, `Username:  prefix + "username",`)
+	assert.Contains(t, src, `Age:       prefix + "age",`)
+	assert.Contains(t, src, `Home:      newfieldsOfAddress(prefix + "home."),`)
+	assert.Contains(t, src, `Profile:   newfieldsOfProfile(prefix + "profile."),`)
+	assert.Contains(t, src, `Level:     prefix + "level",`)
+	assert.NotContains(t, src, "Secret")
+	// the cycle Member, Profile, Member ends with a column
+	assert.Contains(t, src, `User:      prefix + "user",`)
+
+	assert.Contains(t, src, "var MemberFields = newfieldsOfMember(\"\")")
+	assert.Contains(t, src, "var MemberRelations = struct {\n\tProfile string\n}")
+	assert.Contains(t, src, "var ProfileRelations = struct {\n\tUser string\n}")
+	assert.Contains(t, src, "var VIPMemberFields = newfieldsOfVIPMember(\"\")")
+	assert.NotContains(t, src, "SettingsFields")
+	assert.NotContains(t, src, "AddressFields")
+
+	assert.Contains(t, src, "func (r *MemberRepository) FindByUsername(value string) (*Member, error) {")
+	assert.Contains(t, src, "func (r *MemberRepository) FindAllBySeenAt(value time.Time) ([]*Member, error) {")
+	assert.Contains(t, src, "func (r *ProfileRepository) FindByUserID(value mgobson.ObjectId) (*Profile, error) {")
+	assert.Contains(t, src, "func (r *VIPMemberRepository) FindByLevel(value int) (*VIPMember, error) {")
+	assert.Contains(t, src, "if err := r.FindOne(doc, bson.M{MemberFields.Username: value}); err != nil {")
+	// the relations, the nested structs, the ids and the random encrypted
+	// fields have no finder
+	for _, finder := range []string{"FindByProfile", "FindByHome", "FindByID", "FindByPhone"} {
+		assert.NotContains(t, src, finder)
+	}
+}
+
+func TestGenerateTypes(t *testing.T) {
+	dir := writePackage(t, schemas)
+
+	p, err := parsePackage(dir, "monger_gen.go")
+	assert.NoError(t, err)
+
+	src, err := generate(p, []string{"Profile"})
+	assert.NoError(t, err)
+	assert.Contains(t, string(src), "var ProfileFields")
+	assert.NotContains(t, string(src), "var MemberFields")
+	// the paths of the structs the schema holds are still generated
+	assert.Contains(t, string(src), "type fieldsOfMember struct")
+
+	_, err = generate(p, []string{"Settings"})
+	assert.EqualError(t, err, "Settings is not a schema of the package models")
+}
+
+func TestImportName(t *testing.T) {
+	assert.Equal(t, "bson", importName("gopkg.in/mgo.v2/bson"))
+	assert.Equal(t, "yaml", importName("gopkg.in/yaml.v3"))
+	assert.Equal(t, "monger", importName("github.com/iron-kit/monger/v2"))
+	assert.Equal(t, "time", importName("time"))
+}
@@ -0,0 +1,63 @@
+/*
+Command monger-gen generates typed field paths, relation names and
+repositories for the monger schemas of a package:
+
+	//go:generate monger-gen -output monger_gen.go
+
+For a schema
+
+	type Member struct {
+		monger.Schema `json:",inline" bson:",inline"`
+		Username      string   `json:"username" bson:"username"`
+		Profile       *Profile `json:"profile" bson:"profile" monger:"hasOne,foreignKey=user_id"`
+	}
+
+it writes MemberFields with the column paths, MemberFields.Username is
+"username" and MemberFields.Profile.Nickname is "profile.nickname",
+MemberRelations with the names Populate takes and a MemberRepository with
+a FindByX and a FindAllByX method per column of a comparable type.
+
+The tags are read with monger.ParseTag, the columns are the ones monger
+stores. A struct is a schema when it embeds monger.Schema,
+monger.BaseSchema or another schema of the package.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	dir := flag.String("dir", ".", "directory of the package of the schemas")
+	output := flag.String("output", "monger_gen.go", "file written in the package directory")
+	types := flag.String("type", "", "comma separated schemas to generate, all of them by default")
+	flag.Parse()
+
+	if err := run(*dir, *output, *types); err != nil {
+		fmt.Fprintln(os.Stderr, "monger-gen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir string, output string, types string) error {
+	pkg, err := parsePackage(dir, output)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	if types != "" {
+		names = strings.Split(types, ",")
+	}
+
+	src, err := generate(pkg, names)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, output), src, 0644)
+}
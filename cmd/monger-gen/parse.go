@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/iron-kit/monger"
+)
+
+const mongerPath = "github.com/iron-kit/monger"
+
+// pkg is the parsed package of the schemas
+type pkg struct {
+	name    string
+	fset    *token.FileSet
+	structs map[string]*structType
+	// order is the declaration order of the structs
+	order []string
+}
+
+// structType is a struct declared in the package
+type structType struct {
+	name string
+	node *ast.StructType
+	// imports are the packages of its file by name
+	imports map[string]string
+	fields  []*field
+	// state is the progress of the resolution of the fields
+	state    int
+	isSchema *bool
+}
+
+// field is a column of a struct, the inline structs are flattened
+type field struct {
+	Name   string
+	Column string
+	// Type is the source of the type, Imports the packages it uses
+	Type    string
+	Imports map[string]string
+	// Nested is the struct of the package the column holds
+	Nested   string
+	Relation bool
+	// Finder tells if the column gets the FindBy methods
+	Finder bool
+	depth  int
+}
+
+// parsePackage parses the package of dir, without its tests and the output
+func parsePackage(dir string, output string) (*pkg, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	p := &pkg{fset: token.NewFileSet(), structs: make(map[string]*structType)}
+	for _, path := range paths {
+		base := filepath.Base(path)
+		if strings.HasSuffix(base, "_test.go") || base == output {
+			continue
+		}
+
+		file, err := parser.ParseFile(p.fset, path, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+
+		if p.name == "" {
+			p.name = file.Name.Name
+		} else if p.name != file.Name.Name {
+			return nil, fmt.Errorf("%s has the packages %s and %s", dir, p.name, file.Name.Name)
+		}
+
+		p.addFile(file)
+	}
+
+	if p.name == "" {
+		return nil, fmt.Errorf("no Go files in %s", dir)
+	}
+
+	return p, nil
+}
+
+func (p *pkg) addFile(file *ast.File) {
+	imports := make(map[string]string)
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name := importName(path)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		imports[name] = path
+	}
+
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+
+		for _, spec := range gen.Specs {
+			ts := spec.(*ast.TypeSpec)
+			st, ok := ts.Type.(*ast.StructType)
+			if !ok || ts.TypeParams != nil {
+				continue
+			}
+
+			p.structs[ts.Name.Name] = &structType{name: ts.Name.Name, node: st, imports: imports}
+			p.order = append(p.order, ts.Name.Name)
+		}
+	}
+}
+
+var (
+	majorVersion  = regexp.MustCompile(`^v[0-9]+$`)
+	versionSuffix = regexp.MustCompile(`\.v[0-9]+$`)
+)
+
+// importName is the default name of an import path, gopkg.in/mgo.v2/bson
+// is bson and gopkg.in/yaml.v3 is yaml.
+func importName(path string) string {
+	parts := strings.Split(path, "/")
+	name := parts[len(parts)-1]
+	if len(parts) > 1 && majorVersion.MatchString(name) {
+		name = parts[len(parts)-2]
+	}
+
+	return strings.Replace(versionSuffix.ReplaceAllString(name, ""), "-", "_", -1)
+}
+
+// isSchema tells if a struct embeds monger.Schema, monger.BaseSchema or a
+// schema of the package.
+func (p *pkg) isSchema(name string) bool {
+	s, ok := p.structs[name]
+	if !ok {
+		return false
+	}
+	if s.isSchema != nil {
+		return *s.isSchema
+	}
+
+	result := false
+	s.isSchema = &result
+	for _, f := range s.node.Fields.List {
+		if len(f.Names) > 0 {
+			continue
+		}
+
+		switch t := unstar(f.Type).(type) {
+		case *ast.Ident:
+			result = result || p.isSchema(t.Name)
+		case *ast.SelectorExpr:
+			if x, ok := t.X.(*ast.Ident); ok && s.imports[x.Name] == mongerPath {
+				result = result || t.Sel.Name == "Schema" || t.Sel.Name == "BaseSchema"
+			}
+		}
+	}
+
+	return result
+}
+
+// fieldsOf returns the columns of a struct, a field declared by the struct
+// hides the fields of its inline structs with the same name.
+func (p *pkg) fieldsOf(name string) ([]*field, error) {
+	s := p.structs[name]
+	switch s.state {
+	case 1:
+		return nil, fmt.Errorf("%s inlines itself", name)
+	case 2:
+		return s.fields, nil
+	}
+	s.state = 1
+
+	fields := make([]*field, 0)
+	add := func(f *field) {
+		for i, other := range fields {
+			if other.Name != f.Name {
+				continue
+			}
+			if f.depth < other.depth {
+				fields[i] = f
+			}
+			return
+		}
+		fields = append(fields, f)
+	}
+
+	for _, f := range s.node.Fields.List {
+		tags := map[string]string{}
+		if f.Tag != nil {
+			tag, _ := strconv.Unquote(f.Tag.Value)
+			tags = monger.ParseTag(reflect.StructTag(tag))
+		}
+
+		if _, ok := tags["-"]; ok || tags["COLUMN"] == "-" {
+			continue
+		}
+
+		if len(f.Names) == 0 && tags["INLINE"] == "true" {
+			inline, err := p.inlineFields(s, f.Type)
+			if err != nil {
+				return nil, err
+			}
+			for _, inlineField := range inline {
+				copied := *inlineField
+				copied.depth++
+				add(&copied)
+			}
+			continue
+		}
+
+		names := make([]string, 0)
+		for _, ident := range f.Names {
+			names = append(names, ident.Name)
+		}
+		if len(f.Names) == 0 {
+			names = append(names, embeddedName(f.Type))
+		}
+
+		for _, fieldName := range names {
+			if !ast.IsExported(fieldName) {
+				continue
+			}
+			add(p.newField(s, fieldName, f.Type, tags))
+		}
+	}
+
+	s.fields = fields
+	s.state = 2
+
+	return fields, nil
+}
+
+// inlineFields returns the columns of an inline struct
+func (p *pkg) inlineFields(s *structType, expr ast.Expr) ([]*field, error) {
+	switch t := unstar(expr).(type) {
+	case *ast.Ident:
+		if _, ok := p.structs[t.Name]; ok {
+			return p.fieldsOf(t.Name)
+		}
+	case *ast.SelectorExpr:
+		if x, ok := t.X.(*ast.Ident); ok && s.imports[x.Name] == mongerPath {
+			return mongerFields(t.Sel.Name), nil
+		}
+	}
+
+	// the columns of the inline structs of other packages are unknown
+	return nil, nil
+}
+
+// mongerFields are the columns of monger.Schema and monger.BaseSchema
+func mongerFields(name string) []*field {
+	var schema interface{}
+	switch name {
+	case "Schema":
+		schema = monger.Schema{}
+	case "BaseSchema":
+		schema = monger.BaseSchema{}
+	default:
+		return nil
+	}
+
+	fields := make([]*field, 0)
+	for _, f := range monger.GetSchemaStruct(reflect.TypeOf(schema)).Fields {
+		if f.IsIgnored {
+			continue
+		}
+
+		column := f.ColumnName
+		if column == "" {
+			column = strings.ToLower(f.Name)
+		}
+		fields = append(fields, &field{Name: f.Name, Column: column, Type: f.Struct.Type.String(), depth: len(f.InlineIndex) - 1})
+	}
+
+	return fields
+}
+
+func (p *pkg) newField(s *structType, name string, expr ast.Expr, tags map[string]string) *field {
+	f := &field{
+		Name:    name,
+		Column:  tags["COLUMN"],
+		Type:    p.source(expr),
+		Imports: make(map[string]string),
+	}
+	if f.Column == "" {
+		f.Column = strings.ToLower(name)
+	}
+
+	ast.Inspect(expr, func(node ast.Node) bool {
+		if sel, ok := node.(*ast.SelectorExpr); ok {
+			if x, ok := sel.X.(*ast.Ident); ok {
+				f.Imports[x.Name] = s.imports[x.Name]
+			}
+		}
+		return true
+	})
+
+	elem := expr
+	for {
+		switch t := elem.(type) {
+		case *ast.StarExpr:
+			elem = t.X
+			continue
+		case *ast.ArrayType:
+			elem = t.Elt
+			continue
+		}
+		break
+	}
+	if ident, ok := elem.(*ast.Ident); ok {
+		if _, ok := p.structs[ident.Name]; ok {
+			f.Nested = ident.Name
+		}
+	}
+
+	for _, key := range []string{"HASONE", "HASMANY", "BELONGTO"} {
+		if _, ok := tags[key]; ok {
+			f.Relation = true
+		}
+	}
+
+	_, encrypted := tags["ENCRYPT"]
+	deterministic := strings.EqualFold(tags["ENCRYPT"], "deterministic")
+	switch expr.(type) {
+	case *ast.Ident, *ast.SelectorExpr:
+		f.Finder = !f.Relation && f.Nested == "" && f.Column != "_id" && (!encrypted || deterministic)
+	}
+
+	return f
+}
+
+func (p *pkg) source(expr ast.Expr) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, p.fset, expr)
+	return buf.String()
+}
+
+func unstar(expr ast.Expr) ast.Expr {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		return star.X
+	}
+
+	return expr
+}
+
+// embeddedName is the field name of an embedded type
+func embeddedName(expr ast.Expr) string {
+	switch t := unstar(expr).(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.SelectorExpr:
+		return t.Sel.Name
+	}
+
+	return ""
+}
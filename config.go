@@ -1,16 +1,152 @@
 package monger
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"time"
+
 	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 )
 
 type Config struct {
+	// Name of the connection, see ConnectionName
+	Name      string
 	Hosts     []string
 	DBName    string
 	User      string
 	Password  string
 	PoolLimit int
 	DialInfo  *mgo.DialInfo
+	Driver    Driver
+
+	// the options below are usually set from a connection string, see URI
+	SRV                     bool
+	SRVServiceName          string
+	SRVMaxHosts             int
+	ReplicaSet              string
+	Direct                  bool
+	LoadBalanced            bool
+	AuthSource              string
+	AuthMechanism           string
+	AuthMechanismProperties map[string]string
+	TLS                     *TLSConfig
+	ConnectTimeout          time.Duration
+	SocketTimeout           time.Duration
+	ServerSelectionTimeout  time.Duration
+	HeartbeatInterval       time.Duration
+	LocalThreshold          time.Duration
+	MinPoolSize             int
+	MaxIdleTime             time.Duration
+	MaxConnecting           int
+	AppName                 string
+	Compressors             []string
+	ZlibLevel               *int
+	RetryWrites             *bool
+	RetryReads              *bool
+	ReadConcern             string
+	ReadPreference          *ReadPreference
+	WriteConcern            *WriteConcern
+
+	// Reconnect enables the health checks of the connection, nil disables them
+	Reconnect *ReconnectPolicy
+
+	// Tenancy routes the queries of a tenant, nil disables it
+	Tenancy *TenantPolicy
+
+	// Indexes is what registering a schema does with its indexes, default
+	// is IndexEnsure
+	Indexes IndexMode
+
+	// Sequences is the collection of the sequence counters, default is
+	// DefaultSequenceCollection
+	Sequences string
+
+	// LegacySoftDeletes also writes and reads the deleted flag of the soft
+	// deletes made before deleted_at
+	LegacySoftDeletes bool
+
+	// StrictSchemas fails the registration of the schemas with problems
+	// instead of logging them, see LintSchema
+	StrictSchemas bool
+
+	// Validation sets the $jsonSchema of the schemas as the validators of
+	// their collections when they are registered, nil disables it
+	Validation *ValidationPolicy
+
+	// err is the first error met by an option, Connect returns it
+	err error
+}
+
+// TLSConfig are the tls options of a connection
+type TLSConfig struct {
+	CAFile                     string
+	CertificateKeyFile         string
+	CertificateKeyFilePassword string
+	Insecure                   bool
+	AllowInvalidCertificates   bool
+	AllowInvalidHostnames      bool
+}
+
+// Read preference modes
+const (
+	Primary            string = "primary"
+	PrimaryPreferred   string = "primaryPreferred"
+	Secondary          string = "secondary"
+	SecondaryPreferred string = "secondaryPreferred"
+	Nearest            string = "nearest"
+)
+
+// ReadPreference decides which members of a replica set serve reads
+type ReadPreference struct {
+	Mode string
+	// Tags are tried in order, an empty tag set matches any member
+	Tags []bson.D
+	// MaxStaleness of a secondary, zero is no limit
+	MaxStaleness time.Duration
+}
+
+// WriteConcern is the acknowledgement requested from the server for writes
+type WriteConcern struct {
+	// W is the number of members that must acknowledge the write
+	W int
+	// WMode is "majority" or a custom write concern name, it overrides W
+	WMode string
+	// J waits for the write to be in the journal
+	J        bool
+	WTimeout time.Duration
+	// Unacknowledged writes (w=0) do not wait for the server at all
+	Unacknowledged bool
+}
+
+// ReconnectPolicy is how often a connection checks its health and how it
+// backs off while it reconnects, zero fields use the defaults.
+type ReconnectPolicy struct {
+	// CheckInterval between two pings, default is 10s
+	CheckInterval time.Duration
+	// InitialInterval before the first reconnect attempt, default is 500ms
+	InitialInterval time.Duration
+	// MaxInterval caps the backoff, default is 30s
+	MaxInterval time.Duration
+	// Multiplier grows the interval after a failed attempt, default is 2
+	Multiplier float64
+	// MaxAttempts of a reconnect before it waits for the next check, zero is no limit
+	MaxAttempts int
+}
+
+// TenantPolicy routes every tenant to its own database, or to its own
+// prefixed collections with CollectionPrefix.
+type TenantPolicy struct {
+	// Database returns the database of a tenant, default is the dial
+	// database name followed by an underscore and the tenant
+	Database func(tenant string) string
+	// CollectionPrefix keeps the database of the schema and prefixes the
+	// collection with the tenant and an underscore
+	CollectionPrefix bool
+	// Strict makes the queries without a tenant fail with ErrNoTenant
+	Strict bool
 }
 
 type ConfigOption func(*Config)
@@ -50,3 +186,165 @@ func DialInfo(info *mgo.DialInfo) ConfigOption {
 		c.DialInfo = info
 	}
 }
+
+// ConnectionName names the connection, Connect registers it by its name so
+// schemas can bind to it with SchemaConnectionGetter, default is "default".
+func ConnectionName(name string) ConfigOption {
+	return func(c *Config) {
+		c.Name = name
+	}
+}
+
+// UseDriver sets the driver of the connection, default is the mgo.v2 driver
+func UseDriver(driver Driver) ConfigOption {
+	return func(c *Config) {
+		c.Driver = driver
+	}
+}
+
+// WithReadPreference sets the read preference of the connection, models and
+// queries can override it.
+func WithReadPreference(readPreference *ReadPreference) ConfigOption {
+	return func(c *Config) {
+		c.ReadPreference = readPreference
+	}
+}
+
+// WithWriteConcern sets the write concern of the connection, models and
+// queries can override it.
+func WithWriteConcern(writeConcern *WriteConcern) ConfigOption {
+	return func(c *Config) {
+		c.WriteConcern = writeConcern
+	}
+}
+
+// WithReconnect pings the deployment periodically and reconnects with
+// backoff when the ping fails, see Connection.Health.
+func WithReconnect(policy ReconnectPolicy) ConfigOption {
+	return func(c *Config) {
+		c.Reconnect = &policy
+	}
+}
+
+// AutoIndex sets what registering a schema does with its indexes: build
+// the missing ones, also rebuild the changed ones with IndexRebuild, log
+// the differences with IndexDryRun or leave them to EnsureIndexes with
+// IndexManual.
+func AutoIndex(mode IndexMode) ConfigOption {
+	return func(c *Config) {
+		c.Indexes = mode
+	}
+}
+
+// SequenceCollection sets the collection of the sequence counters
+func SequenceCollection(name string) ConfigOption {
+	return func(c *Config) {
+		c.Sequences = name
+	}
+}
+
+// LegacySoftDeletes keeps the documents soft deleted with the deleted flag
+// trashed, and sets the flag along with deleted_at.
+func LegacySoftDeletes() ConfigOption {
+	return func(c *Config) {
+		c.LegacySoftDeletes = true
+	}
+}
+
+// StrictSchemas fails the registration of a schema with the problems of
+// its declaration, they are logged by default.
+func StrictSchemas() ConfigOption {
+	return func(c *Config) {
+		c.StrictSchemas = true
+	}
+}
+
+// WithValidation applies the $jsonSchema validators of the schemas with
+// the policy, see Model.EnsureValidator.
+func WithValidation(policy ValidationPolicy) ConfigOption {
+	return func(c *Config) {
+		c.Validation = &policy
+	}
+}
+
+// WithTenancy enables multi-tenancy, see Model.ForTenant and WithTenant
+func WithTenancy(policy TenantPolicy) ConfigOption {
+	return func(c *Config) {
+		c.Tenancy = &policy
+	}
+}
+
+func (c *Config) setError(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// ClientConfig builds the crypto/tls configuration of the options
+func (t *TLSConfig) ClientConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+
+	if t.CAFile != "" {
+		pem, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("[monger] no certificate found in tlsCAFile " + t.CAFile)
+		}
+	}
+
+	if t.CertificateKeyFile != "" {
+		if t.CertificateKeyFilePassword != "" {
+			return nil, errors.New("[monger] encrypted tlsCertificateKeyFile is not supported")
+		}
+
+		pem, err := ioutil.ReadFile(t.CertificateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cert, err := tls.X509KeyPair(pem, pem)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	switch {
+	case t.Insecure || t.AllowInvalidCertificates:
+		config.InsecureSkipVerify = true
+	case t.AllowInvalidHostnames:
+		// verify the chain but not the host name
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			certs := make([]*x509.Certificate, 0, len(rawCerts))
+			for _, raw := range rawCerts {
+				cert, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return err
+				}
+				certs = append(certs, cert)
+			}
+
+			if len(certs) == 0 {
+				return errors.New("[monger] the server sent no certificate")
+			}
+
+			intermediates := x509.NewCertPool()
+			for _, cert := range certs[1:] {
+				intermediates.AddCert(cert)
+			}
+
+			_, err := certs[0].Verify(x509.VerifyOptions{
+				Roots:         config.RootCAs,
+				Intermediates: intermediates,
+			})
+			return err
+		}
+	}
+
+	return config, nil
+}
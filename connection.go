@@ -1,40 +1,47 @@
 package monger
 
 import (
-	"fmt"
-	"log"
-	"strings"
-	"time"
-
-	"gopkg.in/mgo.v2"
+	"context"
+	"reflect"
+	"sync"
 )
 
 /*
-Connection is the connect manager of mgo.v2 deiver
+Connection is the connect manager of a monger Driver
 */
 type Connection interface {
 	M(interface{}) Model
-	BatchRegister(...Schemer)
+	Register(Schemer) (Model, error)
+	Lookup(interface{}) (Model, error)
+	BatchRegister(...Schemer) error
 	Open() error
 	Close()
-	CloneSession() *mgo.Session
-	getModel(name string) Model
-	registerAndGetModel(document Schemer) Model
+	Ping(ctx context.Context) error
+	Health() Health
+	EnsureIndexes(ctx context.Context) error
+	DiffIndexes(ctx context.Context) ([]*IndexDiff, error)
+	CloneSession() Session
 	GetConfig() *Config
 }
 
 type connection struct {
-	Config     *Config
-	Session    *mgo.Session
-	modelStore map[string]Model
+	Config   *Config
+	Session  Session
+	registry *registry
+	// mu guards Session and stop, models copy the session for every query
+	mu sync.RWMutex
+	// stop ends the health checks of the connection
+	stop    chan struct{}
+	health  healthState
+	tenants tenantSetups
 }
 
-func newConnection(config *Config, session *mgo.Session) Connection {
+func newConnection(config *Config, session Session) Connection {
 
 	return &connection{
-		Config:     config,
-		Session:    session,
-		modelStore: make(map[string]Model),
+		Config:   config,
+		Session:  session,
+		registry: newRegistry(),
 	}
 }
 
@@ -42,89 +49,250 @@ func (conn *connection) GetConfig() *Config {
 	return conn.Config
 }
 
-func (conn *connection) CloneSession() *mgo.Session {
+func (conn *connection) CloneSession() Session {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+
 	return conn.Session.Clone()
 }
 
-// Open a database connection
+// isOpen tells if the connection is not closed
+func (conn *connection) isOpen() bool {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+
+	return conn.Session != nil
+}
+
+// copySession returns a copy of the session for a single operation
+func (conn *connection) copySession() (Session, error) {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+
+	if conn.Session == nil {
+		return nil, ErrConnectionClosed
+	}
+
+	return conn.Session.Copy(), nil
+}
+
+// Open a database connection, the health checks start when the config
+// has a reconnect policy
 func (conn *connection) Open() error {
-	var dialInfo *mgo.DialInfo
-	if conn.Config.DialInfo == nil {
-		dialInfo = &mgo.DialInfo{
-			Addrs:    conn.Config.Hosts,
-			Timeout:  3 * time.Second,
-			Database: conn.Config.DBName,
-			Username: conn.Config.User,
-			Password: conn.Config.Password,
-			// PoolLimit: conn.Config.PoolLimit,
-		}
+	if err := conn.dial(nil); err != nil {
+		return err
+	}
 
-		// 如果设置了用户设置了连接池，就使用用户设置的连接池，否则使用驱动的缺省值(4096)
-		if conn.Config.PoolLimit > 0 {
-			dialInfo.PoolLimit = conn.Config.PoolLimit
-		}
-	} else {
-		dialInfo = conn.Config.DialInfo
+	conn.health.update(func(h *Health) {
+		h.Healthy = true
+		h.LastError = nil
+	})
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	if conn.Config.Reconnect != nil && conn.stop == nil {
+		conn.stop = make(chan struct{})
+		go conn.watch(*conn.Config.Reconnect, conn.stop)
 	}
 
-	session, err := mgo.DialWithInfo(dialInfo)
+	return nil
+}
 
-	// mgo.SetDebug(true)
-	// mgo.SetLogger(new(logger))
+// dial opens a new session and replaces the session of the connection,
+// unless stop is closed: a health check must not reopen a closed connection
+func (conn *connection) dial(stop <-chan struct{}) error {
+	driver := conn.Config.Driver
+	if driver == nil {
+		driver = new(mgoDriver)
+	}
 
+	session, err := driver.Dial(context.Background(), conn.Config)
 	if err != nil {
 		return err
 	}
-	// session.
-	// session.SetMode(mgo.Monotonic)
-	session.SetMode(mgo.Monotonic, true)
+
+	conn.mu.Lock()
+	if stop != nil {
+		select {
+		case <-stop:
+			conn.mu.Unlock()
+			session.Close()
+			return ErrConnectionClosed
+		default:
+		}
+	}
+	old := conn.Session
 	conn.Session = session
+	conn.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+
 	return nil
 }
 
-// Close a database session
+// Close a database session, the models of the connection return
+// ErrConnectionClosed until it is opened again
 func (conn *connection) Close() {
-	if conn.Session != nil {
-		conn.Session.Close()
+	conn.mu.Lock()
+	session := conn.Session
+	conn.Session = nil
+	if conn.stop != nil {
+		close(conn.stop)
+		conn.stop = nil
 	}
+	conn.mu.Unlock()
+
+	if session != nil {
+		session.Close()
+	}
+}
+
+// Register registers the schema and returns its model, registering a type
+// twice returns the same model. It fails when another type already uses the
+// schema name or the collection.
+//
+// A schema implementing SchemaConnectionGetter is registered on its named
+// connection whatever the connection Register is called on.
+//
+// The indexes of the schema are ensured the first time it is registered,
+// see AutoIndex.
+//
+// The declaration of a new schema is linted first, see StrictSchemas.
+func (conn *connection) Register(document Schemer) (Model, error) {
+	return conn.register(document, true)
 }
 
-func (conn *connection) getModel(name string) Model {
-	nameLower := strings.ToLower(name)
-	if _, ok := conn.modelStore[nameLower]; ok {
-		return conn.modelStore[nameLower]
+func (conn *connection) register(document Schemer, lint bool) (Model, error) {
+	target := conn
+	if getter, ok := document.(SchemaConnectionGetter); ok && getter.GetConnectionName() != conn.Config.Name {
+		named, err := GetConnection(getter.GetConnectionName())
+		if err != nil {
+			return nil, err
+		}
+		target = named.(*connection)
+	}
+
+	if _, err := target.registry.lookup(document); err != nil && lint {
+		if err := target.lint(document); err != nil {
+			return nil, err
+		}
+	}
+
+	mdl, err := target.registry.register(document, func() Model {
+		return newModel(target, document)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	panic(fmt.Sprintf("[monger] Schema '%v' is not registered ", nameLower))
+	if err := mdl.(*model).autoIndex(); err != nil {
+		return nil, err
+	}
+	if err := mdl.(*model).autoValidator(); err != nil {
+		return nil, err
+	}
+
+	return mdl, nil
 }
 
-func (conn *connection) registerAndGetModel(document Schemer) Model {
-	typeName := getSchemaTypeName(document)
-	if _, ok := conn.modelStore[typeName]; !ok {
-		mdl := newModel(conn, document)
-		conn.modelStore[typeName] = mdl
-		log.Printf("[monger] Type '%v' has registered \r\n", typeName)
-		return mdl
+// Lookup returns the model registered for a schema name, a reflect.Type or
+// a schema value, it returns a NotRegisteredError when there is none.
+//
+// The model of a schema implementing SchemaConnectionGetter is looked up on
+// its named connection, a schema name also finds the models bound to the
+// other named connections.
+func (conn *connection) Lookup(key interface{}) (Model, error) {
+	mdl, err := conn.registry.lookup(key)
+	if _, ok := err.(*NotRegisteredError); !ok {
+		return mdl, err
 	}
 
-	log.Printf("Tried to register type '%v' twice \r\n", typeName)
-	return conn.modelStore[typeName]
+	var doc interface{}
+	switch k := key.(type) {
+	case string:
+		for _, named := range namedConnections() {
+			if named == conn {
+				continue
+			}
+
+			found, e := named.registry.lookup(k)
+			if e != nil {
+				continue
+			}
+			if getter, ok := found.(*model).schema.(SchemaConnectionGetter); ok && getter.GetConnectionName() == named.Config.Name {
+				return found, nil
+			}
+		}
+
+		return nil, err
+	case reflect.Type:
+		doc = reflect.New(schemaType(k)).Interface()
+	case Schemer:
+		doc = k
+	}
+
+	getter, ok := doc.(SchemaConnectionGetter)
+	if !ok || getter.GetConnectionName() == conn.Config.Name {
+		return nil, err
+	}
+
+	named, ok := namedConnection(getter.GetConnectionName())
+	if !ok {
+		return nil, err
+	}
+
+	return named.registry.lookup(key)
 }
 
+/*
+M registers a schema or looks up a model, args is a schema value or a
+schema name:
+
+	conn.M(new(Member))
+	MemberModel := conn.M("Member")
+
+M panics with the error of Register or Lookup, use them to handle it.
+*/
 func (conn *connection) M(args interface{}) Model {
-	if name, ok := args.(string); ok {
-		return conn.getModel(snakeString(name))
-	}
+	var (
+		mdl Model
+		err error
+	)
 
 	if doc, ok := args.(Schemer); ok {
-		return conn.registerAndGetModel(doc)
+		mdl, err = conn.Register(doc)
+	} else {
+		mdl, err = conn.Lookup(args)
 	}
 
-	return nil
+	if err != nil {
+		panic(err)
+	}
+
+	return mdl
 }
 
-func (conn *connection) BatchRegister(docs ...Schemer) {
+// BatchRegister registers every schema and returns the first error, the
+// schemas are linted together so their relations may come in any order.
+func (conn *connection) BatchRegister(docs ...Schemer) error {
+	pending := make([]Schemer, 0, len(docs))
 	for _, v := range docs {
-		conn.registerAndGetModel(v)
+		if _, err := conn.Lookup(v); err != nil {
+			pending = append(pending, v)
+		}
 	}
+	if err := conn.lint(pending...); err != nil {
+		return err
+	}
+
+	for _, v := range docs {
+		if _, err := conn.register(v, false); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
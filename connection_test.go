@@ -1,25 +1,19 @@
-package monger
+package monger_test
 
 import (
+	"context"
 	"testing"
 
+	"github.com/iron-kit/monger"
+	"github.com/iron-kit/monger/memdb"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestConnectOK(t *testing.T) {
-	_, err := Connect(
-		DBName("monger_test"),
-		Hosts([]string{
-			"localhost",
-		}),
+	c, err := monger.Connect(
+		monger.UseDriver(memdb.New()),
+		monger.DBName("monger_test"),
 	)
-
-	if err != nil {
-		assert.Error(t, err)
-	}
-
-	// conn.BatchRegister(
-	// 	new(Member),
-	// 	new(Profile),
-	// )
+	assert.NoError(t, err)
+	assert.NoError(t, c.Ping(context.Background()))
 }
@@ -0,0 +1,278 @@
+package monger
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+/*
+Defaulter computes the defaults of a document, SetDefaults runs when the
+document is created, after the default tags are applied:
+
+	func (m *Member) SetDefaults() {
+		if m.Nickname == "" {
+			m.Nickname = m.Username
+		}
+	}
+*/
+type Defaulter interface {
+	SetDefaults()
+}
+
+var typeDuration = reflect.TypeOf(time.Duration(0))
+
+// parseDefault returns the function building the default value of a
+// field of type t from the text of its default tag, "now" is the default
+// of time.Time fields for the current time.
+func parseDefault(t reflect.Type, text string) (func() reflect.Value, error) {
+	if t.Kind() == reflect.Ptr {
+		elem, err := parseDefault(t.Elem(), text)
+		if err != nil {
+			return nil, err
+		}
+
+		return func() reflect.Value {
+			ptr := reflect.New(t.Elem())
+			ptr.Elem().Set(elem())
+			return ptr
+		}, nil
+	}
+
+	if t == typeTime {
+		if strings.ToLower(text) == "now" {
+			return func() reflect.Value {
+				return reflect.ValueOf(time.Now())
+			}, nil
+		}
+
+		at, err := time.Parse(time.RFC3339, text)
+		if err != nil {
+			return nil, err
+		}
+		return constant(reflect.ValueOf(at)), nil
+	}
+
+	v := reflect.New(t).Elem()
+	switch {
+	case t == typeDuration:
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return nil, err
+		}
+		v.SetInt(int64(d))
+	case t.Kind() == reflect.String:
+		v.SetString(text)
+	case t.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return nil, err
+		}
+		v.SetBool(b)
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Int64:
+		n, err := strconv.ParseInt(text, 10, t.Bits())
+		if err != nil {
+			return nil, err
+		}
+		v.SetInt(n)
+	case t.Kind() >= reflect.Uint && t.Kind() <= reflect.Uint64:
+		n, err := strconv.ParseUint(text, 10, t.Bits())
+		if err != nil {
+			return nil, err
+		}
+		v.SetUint(n)
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		n, err := strconv.ParseFloat(text, t.Bits())
+		if err != nil {
+			return nil, err
+		}
+		v.SetFloat(n)
+	default:
+		return nil, fmt.Errorf("unsupported default of type %v", t)
+	}
+
+	return constant(v), nil
+}
+
+func constant(v reflect.Value) func() reflect.Value {
+	return func() reflect.Value {
+		return v
+	}
+}
+
+// applyDefaults sets the default of every zero field of doc, then calls
+// SetDefaults of a Defaulter.
+func applyDefaults(doc interface{}) error {
+	docv := reflect.ValueOf(doc)
+	for docv.Kind() == reflect.Ptr {
+		if docv.IsNil() {
+			return nil
+		}
+		docv = docv.Elem()
+	}
+
+	if docv.Kind() != reflect.Struct {
+		return nil
+	}
+
+	for _, field := range GetSchemaStruct(doc).Fields {
+		if field.defaultErr != nil {
+			return &InvalidParamsError{NewError(fmt.Sprintf("[monger] invalid default of %s: %v", field.Name, field.defaultErr))}
+		}
+
+		if field.defaultValue == nil {
+			continue
+		}
+
+		value := docv.FieldByIndex(field.InlineIndex)
+		if value.CanSet() && isZero(value) {
+			value.Set(field.defaultValue())
+		}
+	}
+
+	if defaulter, ok := doc.(Defaulter); ok {
+		defaulter.SetDefaults()
+	}
+
+	return nil
+}
+
+// insertDefaults returns the defaults of a new document of the schema by
+// column with its creation time, the values a Defaulter leaves to zero are
+// omitted.
+func insertDefaults(schemaStruct *SchemaStruct) (bson.M, error) {
+	defaults := bson.M{}
+	if schemaStruct.Type == nil {
+		return defaults, nil
+	}
+
+	doc := reflect.New(schemaStruct.Type)
+	_, isDefaulter := doc.Interface().(Defaulter)
+	if err := applyDefaults(doc.Interface()); err != nil {
+		return nil, err
+	}
+
+	columns := schemaStruct.timestamps()
+	if columns.CreatedAt != "" {
+		defaults[columns.CreatedAt] = time.Now()
+	}
+
+	for _, field := range schemaStruct.Fields {
+		if field.IsIgnored || (field.defaultValue == nil && !isDefaulter) {
+			continue
+		}
+
+		value := doc.Elem().FieldByIndex(field.InlineIndex)
+		if !isZero(value) {
+			defaults[field.columnName()] = value.Interface()
+		}
+	}
+
+	return defaults, nil
+}
+
+// withInsertDefaults adds the defaults of the schema to the $setOnInsert
+// of an upsert, for the columns the update leaves alone.
+func withInsertDefaults(schemaStruct *SchemaStruct, update interface{}) (interface{}, error) {
+	m, ok := update.(bson.M)
+	if !ok {
+		return update, nil
+	}
+
+	defaults, err := insertDefaults(schemaStruct)
+	if err != nil || len(defaults) == 0 {
+		return update, err
+	}
+
+	touched := make([]string, 0)
+	isReplacement := true
+	for key, values := range m {
+		if !strings.HasPrefix(key, "$") {
+			touched = append(touched, key)
+			continue
+		}
+
+		isReplacement = false
+		for _, k := range updateKeys(values) {
+			touched = append(touched, k)
+		}
+	}
+
+	withDefaults := bson.M{}
+	for k, v := range m {
+		withDefaults[k] = v
+	}
+
+	setOnInsert := bson.M{}
+	if soi, ok := m["$setOnInsert"].(bson.M); ok {
+		for k, v := range soi {
+			setOnInsert[k] = v
+		}
+	}
+
+	added := bson.M{}
+	for column, value := range defaults {
+		if !isTouched(touched, column) {
+			added[column] = value
+		}
+	}
+
+	// the update is encrypted already, the defaults are not stored in plain
+	if err := encryptColumns(schemaStruct, added); err != nil {
+		return nil, err
+	}
+
+	for column, value := range added {
+		if isReplacement {
+			withDefaults[column] = value
+		} else {
+			setOnInsert[column] = value
+		}
+	}
+
+	if !isReplacement && len(setOnInsert) > 0 {
+		withDefaults["$setOnInsert"] = setOnInsert
+	}
+
+	return withDefaults, nil
+}
+
+// updateKeys returns the keys of the document of an update operator
+func updateKeys(values interface{}) []string {
+	if getter, ok := values.(bson.Getter); ok {
+		v, err := getter.GetBSON()
+		if err != nil {
+			return nil
+		}
+		values = v
+	}
+
+	keys := make([]string, 0)
+	switch v := values.(type) {
+	case bson.M:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]interface{}:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys
+}
+
+// isTouched tells if column or one of its sub fields is one of the keys
+func isTouched(keys []string, column string) bool {
+	for _, key := range keys {
+		if key == column || strings.HasPrefix(key, column+".") || strings.HasPrefix(column, key+".") {
+			return true
+		}
+	}
+
+	return false
+}
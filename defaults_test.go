@@ -0,0 +1,69 @@
+package monger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type Subscription struct {
+	Schema `json:",inline" bson:",inline"`
+
+	Status    string        `bson:"status" monger:"default=active"`
+	Seats     int           `bson:"seats" monger:"default=5"`
+	Trial     *bool         `bson:"trial" monger:"default=true"`
+	Period    time.Duration `bson:"period" monger:"default=720h"`
+	StartedAt time.Time     `bson:"started_at" monger:"default=now"`
+	Owner     string        `bson:"owner"`
+	Label     string        `bson:"label"`
+}
+
+func (s *Subscription) SetDefaults() {
+	if s.Label == "" {
+		s.Label = s.Status + "-subscription"
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	s := &Subscription{Seats: 10}
+	assert.NoError(t, applyDefaults(s))
+
+	assert.Equal(t, "active", s.Status)
+	assert.Equal(t, 10, s.Seats)
+	if assert.NotNil(t, s.Trial) {
+		assert.True(t, *s.Trial)
+	}
+	assert.Equal(t, 720*time.Hour, s.Period)
+	assert.WithinDuration(t, time.Now(), s.StartedAt, time.Second)
+	assert.Equal(t, "active-subscription", s.Label)
+
+	type Broken struct {
+		Schema `json:",inline" bson:",inline"`
+		Seats  int `bson:"seats" monger:"default=many"`
+	}
+	assert.IsType(t, &InvalidParamsError{}, applyDefaults(&Broken{}))
+}
+
+func TestUpsertDefaults(t *testing.T) {
+	schemaStruct := GetSchemaStruct(&Subscription{})
+
+	update, err := withInsertDefaults(schemaStruct, bson.M{
+		"$set": bson.M{"owner": "ada", "status": "trial"},
+		"$inc": bson.M{"seats": 1},
+	})
+	assert.NoError(t, err)
+
+	setOnInsert := update.(bson.M)["$setOnInsert"].(bson.M)
+	assert.NotContains(t, setOnInsert, "status")
+	assert.NotContains(t, setOnInsert, "seats")
+	assert.NotContains(t, setOnInsert, "owner")
+	assert.Equal(t, 720*time.Hour, setOnInsert["period"])
+	assert.Equal(t, "active-subscription", setOnInsert["label"])
+
+	replacement, err := withInsertDefaults(schemaStruct, bson.M{"owner": "ada"})
+	assert.NoError(t, err)
+	assert.Equal(t, "active", replacement.(bson.M)["status"])
+	assert.NotContains(t, replacement.(bson.M), "$setOnInsert")
+}
@@ -0,0 +1,307 @@
+package monger
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// modelKinds are the subtypes of a base model by the value of its
+// discriminator, the base model and its subtype models share them.
+type modelKinds struct {
+	mu     sync.RWMutex
+	column string
+	base   reflect.Type
+	byKind map[string]reflect.Type
+}
+
+// discriminatorField returns the field tagged with discriminator, nil
+// without one.
+func (s *SchemaStruct) discriminatorField() *SchemaField {
+	for _, field := range s.Fields {
+		if _, ok := field.TagMap["DISCRIMINATOR"]; ok && !field.IsIgnored {
+			return field
+		}
+	}
+
+	return nil
+}
+
+/*
+RegisterKind registers a subtype of the schema stored in the same collection,
+the field of the base schema tagged with discriminator holds the kind of a
+document:
+
+	type Notification struct {
+		monger.Schema `json:",inline" bson:",inline"`
+		Kind          string `json:"kind" bson:"kind" monger:"discriminator"`
+		To            string `json:"to" bson:"to"`
+	}
+
+	type EmailNotification struct {
+		Notification `json:",inline" bson:",inline"`
+		Subject      string `json:"subject" bson:"subject"`
+	}
+
+	EmailModel, err := NotificationModel.RegisterKind("email", new(EmailNotification))
+
+The model of a subtype only finds, updates and deletes the documents of its
+kind, and sets the kind of the documents it creates. A find of the base
+model into an interface, or a slice of an interface, decodes every document
+into the schema of its kind.
+*/
+func (m *model) RegisterKind(kind string, schema Schemer) (Model, error) {
+	if m.kind != "" {
+		return nil, &InvalidParamsError{NewError(fmt.Sprintf("[monger] %T is a subtype, register %T on its base model", m.schema, schema))}
+	}
+
+	field := m.getSchemaStruct().discriminatorField()
+	if field == nil || field.Struct.Type.Kind() != reflect.String {
+		return nil, &InvalidParamsError{NewError(fmt.Sprintf("[monger] %T has no string field tagged with discriminator", m.schema))}
+	}
+
+	column := field.columnName()
+	if sub := GetSchemaStruct(schema).discriminatorField(); sub == nil || sub.columnName() != column {
+		return nil, &InvalidParamsError{NewError(fmt.Sprintf("[monger] the subtype %T must embed the discriminator %s of %T", schema, column, m.schema))}
+	}
+
+	t := schemaType(reflect.TypeOf(schema))
+	m.kinds.mu.Lock()
+	other, registered := m.kinds.byKind[kind]
+	if registered && other != t {
+		m.kinds.mu.Unlock()
+		return nil, &DuplicateModelError{NewError(fmt.Sprintf("[monger] kind '%v' of %v is already used by %v", kind, t, other))}
+	}
+	m.kinds.column = column
+	m.kinds.base = m.getSchemaStruct().Type
+	m.kinds.byKind[kind] = t
+	m.kinds.mu.Unlock()
+
+	conn := m.connection.(*connection)
+	mdl, err := conn.registry.registerKind(schema, func() Model {
+		sub := &model{
+			schema:         schema,
+			connection:     m.connection,
+			collection:     m.collection,
+			collectionName: m.collectionName,
+			readPreference: m.readPreference,
+			writeConcern:   m.writeConcern,
+			kinds:          m.kinds,
+			kind:           kind,
+		}
+
+		return sub
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mdl.(*model).autoIndex(); err != nil {
+		return nil, err
+	}
+	if !registered {
+		// the validator of the base model covers the new kind
+		m.validation.mu.Lock()
+		m.validation.done = false
+		m.validation.mu.Unlock()
+		if err := m.autoValidator(); err != nil {
+			return nil, err
+		}
+	}
+
+	return mdl, nil
+}
+
+// jsonSchema is the $jsonSchema of the collection of the model, a document
+// of a registered kind matches the schema of its kind and any other
+// document the base schema.
+func (m *model) jsonSchema() bson.M {
+	base := m.getSchemaStruct().JSONSchema()
+	if !m.kinds.isPolymorphic() {
+		return base
+	}
+
+	m.kinds.mu.RLock()
+	defer m.kinds.mu.RUnlock()
+
+	kinds := make([]string, 0, len(m.kinds.byKind))
+	for kind := range m.kinds.byKind {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	schemas := []bson.M{withKind(base, m.kinds.column, bson.M{"not": bson.M{"enum": kinds}})}
+	for _, kind := range kinds {
+		schema := GetSchemaStruct(reflect.New(m.kinds.byKind[kind]).Interface()).JSONSchema()
+		required, _ := schema["required"].([]string)
+		schema["required"] = append(required, m.kinds.column)
+		schemas = append(schemas, withKind(schema, m.kinds.column, bson.M{"enum": []string{kind}}))
+	}
+
+	return bson.M{"anyOf": schemas}
+}
+
+// withKind adds the rules on the discriminator column to an object schema
+func withKind(schema bson.M, column string, rules bson.M) bson.M {
+	properties := schema["properties"].(bson.M)
+	property, ok := properties[column].(bson.M)
+	if !ok {
+		property = bson.M{}
+		properties[column] = property
+	}
+	for k, v := range rules {
+		property[k] = v
+	}
+
+	return schema
+}
+
+// kindOf returns the kind of a schema type
+func (k *modelKinds) kindOf(t reflect.Type) (string, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for kind, kt := range k.byKind {
+		if kt == t {
+			return kind, true
+		}
+	}
+
+	return "", false
+}
+
+// typeOf returns the schema type of a kind, the base type for the unknown
+// kinds.
+func (k *modelKinds) typeOf(kind string) reflect.Type {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if t, ok := k.byKind[kind]; ok {
+		return t
+	}
+
+	return k.base
+}
+
+// isPolymorphic tells if the base model has subtypes
+func (k *modelKinds) isPolymorphic() bool {
+	if k == nil {
+		return false
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return len(k.byKind) > 0
+}
+
+// kindFilter is the condition matching the documents of the kind of the
+// query, nil for a base model.
+func (q *query) kindFilter() bson.M {
+	if q.kind == "" || q.kinds == nil {
+		return nil
+	}
+
+	q.kinds.mu.RLock()
+	defer q.kinds.mu.RUnlock()
+
+	return bson.M{q.kinds.column: q.kind}
+}
+
+// assignKind sets the discriminator of a document of a subtype
+func (q *query) assignKind(doc interface{}) {
+	if !q.kinds.isPolymorphic() {
+		return
+	}
+
+	docv := reflect.ValueOf(doc)
+	for docv.Kind() == reflect.Ptr {
+		docv = docv.Elem()
+	}
+
+	kind, ok := q.kinds.kindOf(docv.Type())
+	if !ok {
+		return
+	}
+
+	field := GetSchemaStruct(doc).discriminatorField()
+	docv.FieldByIndex(field.InlineIndex).SetString(kind)
+}
+
+// isInterfaceResult tells if result is a pointer to an interface or to a
+// slice of an interface.
+func isInterfaceResult(result interface{}) bool {
+	t := reflect.TypeOf(result)
+	if t == nil || t.Kind() != reflect.Ptr {
+		return false
+	}
+
+	t = t.Elem()
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+
+	return t.Kind() == reflect.Interface
+}
+
+// findKinds decodes the found documents into the schemas of their kinds
+func (q *query) findKinds(result interface{}) error {
+	resultv := reflect.ValueOf(result).Elem()
+
+	if !q.multiple {
+		doc := bson.M{}
+		if err := q.find(&doc); err != nil {
+			return err
+		}
+
+		value, err := q.decodeKind(doc, resultv.Type())
+		if err != nil {
+			return err
+		}
+		resultv.Set(value)
+		return nil
+	}
+
+	docs := make([]bson.M, 0)
+	if err := q.find(&docs); err != nil {
+		return err
+	}
+
+	items := reflect.MakeSlice(resultv.Type(), 0, len(docs))
+	for _, doc := range docs {
+		value, err := q.decodeKind(doc, resultv.Type().Elem())
+		if err != nil {
+			return err
+		}
+		items = reflect.Append(items, value)
+	}
+	resultv.Set(items)
+
+	return nil
+}
+
+// decodeKind decodes a document into a new document of the schema of its
+// kind, which must implement the interface t.
+func (q *query) decodeKind(doc bson.M, t reflect.Type) (reflect.Value, error) {
+	q.kinds.mu.RLock()
+	kind, _ := doc[q.kinds.column].(string)
+	q.kinds.mu.RUnlock()
+
+	value := reflect.New(q.kinds.typeOf(kind))
+	if !value.Type().Implements(t) {
+		return value, &InvalidParamsError{NewError(fmt.Sprintf("[monger] %v of kind '%v' does not implement %v", value.Type(), kind, t))}
+	}
+
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return value, err
+	}
+	if err := bson.Unmarshal(data, value.Interface()); err != nil {
+		return value, err
+	}
+
+	return value.Convert(t), nil
+}
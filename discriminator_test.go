@@ -0,0 +1,94 @@
+package monger_test
+
+import (
+	"testing"
+
+	"github.com/iron-kit/monger"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type Notifier interface {
+	Channel() string
+}
+
+type Notification struct {
+	monger.Schema `json:",inline" bson:",inline"`
+	Kind          string `json:"kind" bson:"kind" monger:"discriminator"`
+	To            string `json:"to" bson:"to"`
+}
+
+func (n *Notification) Channel() string {
+	return "unknown"
+}
+
+type EmailNotification struct {
+	Notification `json:",inline" bson:",inline"`
+	Subject      string `json:"subject" bson:"subject"`
+}
+
+func (n *EmailNotification) Channel() string {
+	return "email:" + n.Subject
+}
+
+type SMSNotification struct {
+	Notification `json:",inline" bson:",inline"`
+	Body         string `json:"body" bson:"body"`
+}
+
+func (n *SMSNotification) Channel() string {
+	return "sms:" + n.Body
+}
+
+type Recipient struct {
+	monger.Schema `json:",inline" bson:",inline"`
+	Email         string `json:"email" bson:"email"`
+}
+
+func TestDiscriminator(t *testing.T) {
+	c := memConn(t)
+	NotificationModel := c.M(new(Notification))
+	EmailModel, err := NotificationModel.RegisterKind("email", new(EmailNotification))
+	assert.NoError(t, err)
+	SMSModel, err := NotificationModel.RegisterKind("sms", new(SMSNotification))
+	assert.NoError(t, err)
+
+	_, err = NotificationModel.RegisterKind("email", new(SMSNotification))
+	assert.IsType(t, &monger.DuplicateModelError{}, err)
+	_, err = c.M(new(Recipient)).RegisterKind("admin", new(Recipient))
+	assert.IsType(t, &monger.InvalidParamsError{}, err)
+
+	email := &EmailNotification{Subject: "welcome"}
+	email.To = "ada@example.com"
+	assert.NoError(t, EmailModel.Create(email))
+	assert.Equal(t, "email", email.Kind)
+	assert.NoError(t, NotificationModel.Create(&SMSNotification{Body: "code 42"}))
+	assert.NoError(t, NotificationModel.Create(&Notification{To: "ops"}))
+
+	assert.Equal(t, 3, NotificationModel.Count())
+	assert.Equal(t, 1, EmailModel.Count())
+	assert.Equal(t, 1, SMSModel.Where(nil).Count())
+
+	notifiers := make([]Notifier, 0)
+	assert.NoError(t, NotificationModel.FindAll(&notifiers))
+	channels := make([]string, 0)
+	for _, n := range notifiers {
+		channels = append(channels, n.Channel())
+	}
+	assert.ElementsMatch(t, []string{"email:welcome", "sms:code 42", "unknown"}, channels)
+	assert.False(t, notifiers[0].(monger.Schemer).IsEmpty())
+
+	var notifier Notifier
+	assert.NoError(t, NotificationModel.FindOne(&notifier, bson.M{"kind": "sms"}))
+	assert.Equal(t, "sms:code 42", notifier.Channel())
+
+	found := new(EmailNotification)
+	assert.Equal(t, monger.ErrNotFound, EmailModel.FindOne(found, bson.M{"kind": bson.M{"$ne": ""}, "to": "ops"}))
+	assert.NoError(t, EmailModel.FindByID(email.ID, found))
+	assert.Equal(t, "welcome", found.Subject)
+
+	// the model of a subtype only changes the documents of its kind
+	assert.NoError(t, SMSModel.DeleteAll(bson.M{}))
+	assert.Equal(t, 2, NotificationModel.Where(nil).Count())
+	assert.Equal(t, monger.ErrNotFound, SMSModel.Update(bson.M{"_id": email.ID}, bson.M{"$set": bson.M{"body": "x"}}))
+}
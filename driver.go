@@ -0,0 +1,141 @@
+package monger
+
+import (
+	"context"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+/*
+Driver is the backend used by a Connection to talk to MongoDB.
+
+monger ships with an mgo.v2 driver which is used by default, the official
+MongoDB Go driver lives in the mongodriver package:
+
+	conn, err := monger.Connect(
+		monger.UseDriver(mongodriver.New()),
+		monger.DBName("your_database_name"),
+	)
+
+Documents, filters and pipelines are always expressed with the
+gopkg.in/mgo.v2/bson types, drivers are responsible for translating them.
+*/
+type Driver interface {
+	Dial(ctx context.Context, config *Config) (Session, error)
+}
+
+// Session is an open connection to a deployment.
+type Session interface {
+	// DB returns the named database, an empty name is the dial database.
+	DB(name string) Database
+	// Clone returns a session which shares the state of the session.
+	Clone() Session
+	// Copy returns an independent session from the pool, monger copies the
+	// session of the connection for every query execution and closes it after.
+	Copy() Session
+	// Ping checks the session can reach the deployment.
+	Ping(ctx context.Context) error
+	Close()
+}
+
+// StatusReporter is implemented by the sessions which report the state of
+// their deployment, Connection.Health includes it.
+type StatusReporter interface {
+	Status() SessionStatus
+}
+
+// SessionStatus is the topology and pool state known by a driver, fields
+// a driver does not know about are left empty.
+type SessionStatus struct {
+	// Topology is the kind of deployment, like ReplicaSetWithPrimary
+	Topology string
+	Servers  []ServerStatus
+	Pool     PoolStatus
+}
+
+// ServerStatus is a member of the deployment.
+type ServerStatus struct {
+	Address string
+	// Kind is the role of the server, like RSPrimary or RSSecondary
+	Kind string
+}
+
+// PoolStatus counts the connections of the pool.
+type PoolStatus struct {
+	Open  int
+	InUse int
+	Limit int
+}
+
+// Database is a handle of a database in a Session.
+type Database interface {
+	Name() string
+	C(name string) Collection
+}
+
+// Collection is a handle of a collection, every operation honors ctx.
+type Collection interface {
+	Name() string
+	Find(ctx context.Context, filter interface{}, opts *FindOptions) Cursor
+	Aggregate(ctx context.Context, pipeline []bson.M) Cursor
+	Count(ctx context.Context, filter interface{}) (int, error)
+	Insert(ctx context.Context, docs ...interface{}) error
+	// Update modifies the first matched document and returns ErrNotFound
+	// when nothing matched.
+	Update(ctx context.Context, selector interface{}, update interface{}) error
+	UpdateAll(ctx context.Context, selector interface{}, update interface{}) (*ChangeInfo, error)
+	Upsert(ctx context.Context, selector interface{}, update interface{}) (*ChangeInfo, error)
+	// Remove deletes the first matched document and returns ErrNotFound
+	// when nothing matched.
+	Remove(ctx context.Context, selector interface{}) error
+	RemoveAll(ctx context.Context, selector interface{}) (*ChangeInfo, error)
+	// With returns a copy of the collection which reads with readPreference
+	// and writes with writeConcern, a nil argument keeps the current one.
+	With(readPreference *ReadPreference, writeConcern *WriteConcern) Collection
+}
+
+// FindAndModifier is implemented by the collections of the drivers which
+// can update a document and read it in a single atomic operation, the
+// sequences need it.
+type FindAndModifier interface {
+	// FindAndModify applies change to the first document matched by
+	// selector and decodes the document into result, it returns ErrNotFound
+	// when nothing matched and nothing was upserted.
+	FindAndModify(ctx context.Context, selector interface{}, change Change, result interface{}) error
+}
+
+// Change is the update of a FindAndModify
+type Change struct {
+	Update interface{}
+	// Upsert inserts the document when nothing matched
+	Upsert bool
+	// ReturnNew decodes the document after the update instead of before
+	ReturnNew bool
+}
+
+// Cursor is the result set of a find or an aggregation, it is only sent
+// to the server once One or All is called.
+type Cursor interface {
+	// One decodes the first document into result, it returns ErrNotFound
+	// when the result set is empty.
+	One(result interface{}) error
+	// All decodes every document into result, which must be a pointer to a slice.
+	All(result interface{}) error
+}
+
+// FindOptions are the optional parts of a find.
+type FindOptions struct {
+	Selector interface{}
+	// Sort fields, a "-" prefix sorts descending
+	Sort  []string
+	Skip  int
+	Limit int
+}
+
+// ChangeInfo reports the documents affected by a write.
+type ChangeInfo struct {
+	Updated    int
+	Removed    int
+	Matched    int
+	UpsertedId interface{}
+}
@@ -0,0 +1,706 @@
+package monger
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// mgoDriver is the default driver, it is backed by gopkg.in/mgo.v2
+type mgoDriver struct{}
+
+func (d *mgoDriver) Dial(ctx context.Context, config *Config) (Session, error) {
+	var dialInfo *mgo.DialInfo
+	if config.DialInfo == nil {
+		info, err := mgoDialInfo(config)
+		if err != nil {
+			return nil, err
+		}
+		dialInfo = info
+	} else {
+		dialInfo = config.DialInfo
+	}
+
+	mgo.SetStats(true)
+
+	session, err := mgoCall(ctx, func() (*mgo.Session, error) {
+		return mgo.DialWithInfo(dialInfo)
+	}, nil)
+
+	// mgo.SetDebug(true)
+	// mgo.SetLogger(new(logger))
+
+	if err != nil {
+		return nil, err
+	}
+	// session.
+	// session.SetMode(mgo.Monotonic)
+	session.SetMode(mgo.Monotonic, true)
+
+	if config.SocketTimeout > 0 {
+		session.SetSocketTimeout(config.SocketTimeout)
+	}
+
+	if config.ServerSelectionTimeout > 0 {
+		session.SetSyncTimeout(config.ServerSelectionTimeout)
+	}
+
+	if rp := config.ReadPreference; rp != nil {
+		session.SetMode(mgoMode(rp.Mode), true)
+		if len(rp.Tags) > 0 {
+			session.SelectServers(rp.Tags...)
+		}
+	}
+
+	if wc := config.WriteConcern; wc != nil {
+		session.SetSafe(mgoSafe(wc))
+	}
+
+	poolLimit := dialInfo.PoolLimit
+	if poolLimit == 0 {
+		poolLimit = 4096
+	}
+
+	return &mgoSession{session, poolLimit}, nil
+}
+
+func mgoDialInfo(config *Config) (*mgo.DialInfo, error) {
+	if config.LoadBalanced {
+		return nil, errors.New("[monger] the mgo driver does not support loadBalanced")
+	}
+
+	hosts := config.Hosts
+	if config.SRV {
+		var err error
+		if hosts, err = lookupSRV(config); err != nil {
+			return nil, err
+		}
+	}
+
+	timeout := 3 * time.Second
+	if config.ConnectTimeout > 0 {
+		timeout = config.ConnectTimeout
+	}
+
+	dialInfo := &mgo.DialInfo{
+		Addrs:          hosts,
+		Direct:         config.Direct,
+		Timeout:        timeout,
+		Database:       config.DBName,
+		ReplicaSetName: config.ReplicaSet,
+		Source:         config.AuthSource,
+		Mechanism:      config.AuthMechanism,
+		Service:        config.AuthMechanismProperties["SERVICE_NAME"],
+		Username:       config.User,
+		Password:       config.Password,
+		// PoolLimit: config.PoolLimit,
+	}
+
+	// 如果设置了用户设置了连接池，就使用用户设置的连接池，否则使用驱动的缺省值(4096)
+	if config.PoolLimit > 0 {
+		dialInfo.PoolLimit = config.PoolLimit
+	}
+
+	if config.TLS != nil {
+		tlsConfig, err := config.TLS.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+
+		dialInfo.DialServer = func(addr *mgo.ServerAddr) (net.Conn, error) {
+			return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr.String(), tlsConfig)
+		}
+	}
+
+	return dialInfo, nil
+}
+
+// lookupSRV resolves the seed list of a mongodb+srv:// connection string,
+// the TXT record may set authSource and replicaSet when they are not set.
+func lookupSRV(config *Config) ([]string, error) {
+	host := config.Hosts[0]
+	service := config.SRVServiceName
+	if service == "" {
+		service = "mongodb"
+	}
+
+	_, records, err := net.LookupSRV(service, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+
+	domain := host
+	if i := strings.Index(host, "."); i >= 0 {
+		domain = host[i:]
+	}
+
+	hosts := make([]string, 0, len(records))
+	for _, record := range records {
+		target := strings.TrimSuffix(record.Target, ".")
+		if !strings.HasSuffix(target, domain) {
+			return nil, fmt.Errorf("[monger] SRV record %s is not in the domain of %s", target, host)
+		}
+		hosts = append(hosts, fmt.Sprintf("%s:%d", target, record.Port))
+	}
+
+	if config.SRVMaxHosts > 0 && len(hosts) > config.SRVMaxHosts {
+		rand.Shuffle(len(hosts), func(i, j int) {
+			hosts[i], hosts[j] = hosts[j], hosts[i]
+		})
+		hosts = hosts[:config.SRVMaxHosts]
+	}
+
+	txts, err := net.LookupTXT(host)
+	if err != nil {
+		// a missing TXT record is not an error
+		return hosts, nil
+	}
+
+	for _, txt := range txts {
+		values, err := url.ParseQuery(txt)
+		if err != nil {
+			return nil, fmt.Errorf("[monger] invalid TXT record of %s: %v", host, err)
+		}
+
+		for key, value := range values {
+			switch strings.ToLower(key) {
+			case "authsource":
+				if config.AuthSource == "" {
+					config.AuthSource = value[0]
+				}
+			case "replicaset":
+				if config.ReplicaSet == "" {
+					config.ReplicaSet = value[0]
+				}
+			default:
+				return nil, fmt.Errorf("[monger] TXT record of %s has the unsupported option %s", host, key)
+			}
+		}
+	}
+
+	return hosts, nil
+}
+
+func mgoMode(mode string) mgo.Mode {
+	switch mode {
+	case PrimaryPreferred:
+		return mgo.PrimaryPreferred
+	case Secondary:
+		return mgo.Secondary
+	case SecondaryPreferred:
+		return mgo.SecondaryPreferred
+	case Nearest:
+		return mgo.Nearest
+	}
+
+	return mgo.Primary
+}
+
+func mgoSafe(wc *WriteConcern) *mgo.Safe {
+	if wc.Unacknowledged {
+		return nil
+	}
+
+	return &mgo.Safe{
+		W:        wc.W,
+		WMode:    wc.WMode,
+		J:        wc.J,
+		WTimeout: int(wc.WTimeout / time.Millisecond),
+	}
+}
+
+type mgoSession struct {
+	session   *mgo.Session
+	poolLimit int
+}
+
+func (s *mgoSession) DB(name string) Database {
+	return &mgoDatabase{s.session.DB(name)}
+}
+
+func (s *mgoSession) Clone() Session {
+	return &mgoSession{s.session.Clone(), s.poolLimit}
+}
+
+func (s *mgoSession) Copy() Session {
+	return &mgoSession{s.session.Copy(), s.poolLimit}
+}
+
+func (s *mgoSession) Ping(ctx context.Context) error {
+	return mgoRun(ctx, s.session.Ping)
+}
+
+// Status reports the live servers, mgo keeps no role of them, and the
+// socket counts of mgo stats which the driver enables on Dial.
+func (s *mgoSession) Status() SessionStatus {
+	status := SessionStatus{Pool: PoolStatus{Limit: s.poolLimit}}
+	for _, addr := range s.session.LiveServers() {
+		status.Servers = append(status.Servers, ServerStatus{Address: addr})
+	}
+
+	stats := mgo.GetStats()
+	status.Pool.Open = stats.SocketsAlive
+	status.Pool.InUse = stats.SocketsInUse
+
+	return status
+}
+
+func (s *mgoSession) Close() {
+	s.session.Close()
+}
+
+type mgoDatabase struct {
+	database *mgo.Database
+}
+
+func (db *mgoDatabase) Name() string {
+	return db.database.Name
+}
+
+func (db *mgoDatabase) C(name string) Collection {
+	return &mgoCollection{collection: db.database.C(name)}
+}
+
+type mgoCollection struct {
+	collection     *mgo.Collection
+	readPreference *ReadPreference
+	writeConcern   *WriteConcern
+}
+
+func (c *mgoCollection) Name() string {
+	return c.collection.Name
+}
+
+func (c *mgoCollection) With(readPreference *ReadPreference, writeConcern *WriteConcern) Collection {
+	with := *c
+	if readPreference != nil {
+		with.readPreference = readPreference
+	}
+
+	if writeConcern != nil {
+		with.writeConcern = writeConcern
+	}
+
+	return &with
+}
+
+// acquire returns the mgo collection to run an operation on, it runs on a
+// clone of the session which release closes: an operation abandoned on its
+// context keeps a session of its own until it returns. mgo sets the read
+// preference and write concern on a session, they are set on the clone.
+func (c *mgoCollection) acquire() (coll *mgo.Collection, release func()) {
+	session := c.collection.Database.Session.Clone()
+	if rp := c.readPreference; rp != nil {
+		// mgo has no support for maxStalenessSeconds
+		session.SetMode(mgoMode(rp.Mode), true)
+		if len(rp.Tags) > 0 {
+			session.SelectServers(rp.Tags...)
+		}
+	}
+
+	if wc := c.writeConcern; wc != nil {
+		session.SetSafe(mgoSafe(wc))
+	}
+
+	return c.collection.With(session), session.Close
+}
+
+// run executes fn on an acquired collection, see mgoCollectionCall
+func (c *mgoCollection) run(ctx context.Context, fn func(coll *mgo.Collection) error) error {
+	_, err := mgoCollectionCall(ctx, c, func(coll *mgo.Collection) (struct{}, error) {
+		return struct{}{}, fn(coll)
+	})
+
+	return err
+}
+
+// mgoCollectionCall returns the value of fn executed on an acquired
+// collection, see mgoCall. The collection is acquired before fn runs in
+// the background and released once fn returns.
+func mgoCollectionCall[T any](ctx context.Context, c *mgoCollection, fn func(coll *mgo.Collection) (T, error)) (T, error) {
+	if ctx != nil && ctx.Err() != nil {
+		var zero T
+		return zero, ctx.Err()
+	}
+
+	coll, release := c.acquire()
+	return mgoCall(ctx, func() (T, error) {
+		return fn(coll)
+	}, release)
+}
+
+// decode executes fn with a new value of the type result points to, result
+// is only set once fn returns so an abandoned operation never writes to it.
+func (c *mgoCollection) decode(ctx context.Context, result interface{}, fn func(coll *mgo.Collection, out interface{}) error) error {
+	resultv := reflect.ValueOf(result)
+	if resultv.Kind() != reflect.Ptr || resultv.IsNil() {
+		return c.run(ctx, func(coll *mgo.Collection) error {
+			return fn(coll, result)
+		})
+	}
+
+	out, err := mgoCollectionCall(ctx, c, func(coll *mgo.Collection) (reflect.Value, error) {
+		out := reflect.New(resultv.Elem().Type())
+		return out, fn(coll, out.Interface())
+	})
+	if err != nil {
+		return err
+	}
+	resultv.Elem().Set(out.Elem())
+
+	return nil
+}
+
+func (c *mgoCollection) Find(ctx context.Context, filter interface{}, opts *FindOptions) Cursor {
+	return &mgoCursor{ctx: ctx, collection: c, query: func(coll *mgo.Collection) *mgo.Query {
+		query := coll.Find(filter)
+		if opts != nil {
+			if opts.Selector != nil {
+				query.Select(opts.Selector)
+			}
+
+			if opts.Skip > 0 {
+				query.Skip(opts.Skip)
+			}
+
+			if opts.Limit > 0 {
+				query.Limit(opts.Limit)
+			}
+
+			if len(opts.Sort) > 0 {
+				query.Sort(opts.Sort...)
+			}
+		}
+
+		mgoSetMaxTime(ctx, query)
+		return query
+	}}
+}
+
+func (c *mgoCollection) Aggregate(ctx context.Context, pipeline []bson.M) Cursor {
+	return &mgoCursor{ctx: ctx, collection: c, pipe: func(coll *mgo.Collection) *mgo.Iter {
+		return mgoAggregate(ctx, coll, pipeline)
+	}}
+}
+
+// mgoAggregate runs the aggregate command like mgo.Pipe does, mgo.Pipe has
+// no max time so the deadline of ctx is sent as maxTimeMS here.
+func mgoAggregate(ctx context.Context, coll *mgo.Collection, pipeline []bson.M) *mgo.Iter {
+	cmd := bson.D{
+		{Name: "aggregate", Value: coll.Name},
+		{Name: "pipeline", Value: pipeline},
+		{Name: "cursor", Value: bson.M{}},
+	}
+	if d := mgoMaxTime(ctx); d > 0 {
+		cmd = append(cmd, bson.DocElem{Name: "maxTimeMS", Value: int64(d / time.Millisecond)})
+	}
+
+	var result struct {
+		Cursor struct {
+			FirstBatch []bson.Raw `bson:"firstBatch"`
+			ID         int64      `bson:"id"`
+		} `bson:"cursor"`
+	}
+	err := coll.Database.Run(cmd, &result)
+
+	return coll.NewIter(nil, result.Cursor.FirstBatch, result.Cursor.ID, err)
+}
+
+func (c *mgoCollection) Count(ctx context.Context, filter interface{}) (int, error) {
+	return mgoCollectionCall(ctx, c, func(coll *mgo.Collection) (int, error) {
+		query := coll.Find(filter)
+		mgoSetMaxTime(ctx, query)
+		return query.Count()
+	})
+}
+
+func (c *mgoCollection) Insert(ctx context.Context, docs ...interface{}) error {
+	return c.run(ctx, func(coll *mgo.Collection) error {
+		return coll.Insert(docs...)
+	})
+}
+
+func (c *mgoCollection) Update(ctx context.Context, selector interface{}, update interface{}) error {
+	return c.run(ctx, func(coll *mgo.Collection) error {
+		return coll.Update(selector, update)
+	})
+}
+
+func (c *mgoCollection) UpdateAll(ctx context.Context, selector interface{}, update interface{}) (*ChangeInfo, error) {
+	return mgoCollectionCall(ctx, c, func(coll *mgo.Collection) (*ChangeInfo, error) {
+		changeInfo, err := coll.UpdateAll(selector, update)
+		return toChangeInfo(changeInfo), err
+	})
+}
+
+func (c *mgoCollection) Upsert(ctx context.Context, selector interface{}, update interface{}) (*ChangeInfo, error) {
+	return mgoCollectionCall(ctx, c, func(coll *mgo.Collection) (*ChangeInfo, error) {
+		changeInfo, err := coll.Upsert(selector, update)
+		return toChangeInfo(changeInfo), err
+	})
+}
+
+func (c *mgoCollection) Remove(ctx context.Context, selector interface{}) error {
+	return c.run(ctx, func(coll *mgo.Collection) error {
+		return coll.Remove(selector)
+	})
+}
+
+func (c *mgoCollection) RemoveAll(ctx context.Context, selector interface{}) (*ChangeInfo, error) {
+	return mgoCollectionCall(ctx, c, func(coll *mgo.Collection) (*ChangeInfo, error) {
+		changeInfo, err := coll.RemoveAll(selector)
+		return toChangeInfo(changeInfo), err
+	})
+}
+
+func (c *mgoCollection) FindAndModify(ctx context.Context, selector interface{}, change Change, result interface{}) error {
+	return c.decode(ctx, result, func(coll *mgo.Collection, out interface{}) error {
+		query := coll.Find(selector)
+		mgoSetMaxTime(ctx, query)
+		_, err := query.Apply(mgo.Change{
+			Update:    change.Update,
+			Upsert:    change.Upsert,
+			ReturnNew: change.ReturnNew,
+		}, out)
+		return err
+	})
+}
+
+func (c *mgoCollection) Indexes(ctx context.Context) ([]Index, error) {
+	return mgoCollectionCall(ctx, c, func(coll *mgo.Collection) ([]Index, error) {
+		list, err := coll.Indexes()
+		if qerr, ok := err.(*mgo.QueryError); ok && qerr.Code == 26 {
+			// NamespaceNotFound, the collection is not created yet
+			return nil, nil
+		}
+
+		var indexes []Index
+		for _, index := range list {
+			indexes = append(indexes, Index{
+				Name:        index.Name,
+				Key:         index.Key,
+				Unique:      index.Unique,
+				Sparse:      index.Sparse,
+				ExpireAfter: index.ExpireAfter,
+			})
+		}
+		return indexes, err
+	})
+}
+
+func (c *mgoCollection) EnsureIndex(ctx context.Context, index Index) error {
+	return c.run(ctx, func(coll *mgo.Collection) error {
+		return coll.EnsureIndex(mgo.Index{
+			Name:        index.Name,
+			Key:         index.Key,
+			Unique:      index.Unique,
+			Sparse:      index.Sparse,
+			ExpireAfter: index.ExpireAfter,
+		})
+	})
+}
+
+func (c *mgoCollection) DropIndex(ctx context.Context, name string) error {
+	return c.run(ctx, func(coll *mgo.Collection) error {
+		return coll.DropIndexName(name)
+	})
+}
+
+func (c *mgoCollection) SetIndexExpiry(ctx context.Context, name string, expireAfter time.Duration) error {
+	return c.run(ctx, func(coll *mgo.Collection) error {
+		return coll.Database.Run(bson.D{
+			{Name: "collMod", Value: coll.Name},
+			{Name: "index", Value: bson.M{"name": name, "expireAfterSeconds": int64(expireAfter / time.Second)}},
+		}, nil)
+	})
+}
+
+func (c *mgoCollection) SetValidator(ctx context.Context, validator Validator) error {
+	return c.run(ctx, func(coll *mgo.Collection) error {
+		options := bson.D{
+			{Name: "validator", Value: validator.Schema},
+			{Name: "validationLevel", Value: validator.Level},
+			{Name: "validationAction", Value: validator.Action},
+		}
+
+		err := coll.Database.Run(append(bson.D{{Name: "collMod", Value: coll.Name}}, options...), nil)
+		if qerr, ok := err.(*mgo.QueryError); ok && qerr.Code == 26 {
+			// NamespaceNotFound, the collection is created with the validator
+			return coll.Database.Run(append(bson.D{{Name: "create", Value: coll.Name}}, options...), nil)
+		}
+		return err
+	})
+}
+
+// mgoCursor builds the mgo query or pipe lazily so nothing touches the
+// session when the context is already done.
+type mgoCursor struct {
+	ctx        context.Context
+	collection *mgoCollection
+	query      func(coll *mgo.Collection) *mgo.Query
+	pipe       func(coll *mgo.Collection) *mgo.Iter
+}
+
+func (c *mgoCursor) One(result interface{}) error {
+	return c.collection.decode(c.ctx, result, func(coll *mgo.Collection, out interface{}) error {
+		if c.pipe != nil {
+			iter := c.pipe(coll)
+			if iter.Next(out) {
+				return iter.Close()
+			}
+			if err := iter.Close(); err != nil {
+				return err
+			}
+			return mgo.ErrNotFound
+		}
+
+		return c.query(coll).One(out)
+	})
+}
+
+func (c *mgoCursor) All(result interface{}) error {
+	return c.collection.decode(c.ctx, result, func(coll *mgo.Collection, out interface{}) error {
+		if c.pipe != nil {
+			return c.pipe(coll).All(out)
+		}
+
+		return c.query(coll).All(out)
+	})
+}
+
+func toChangeInfo(info *mgo.ChangeInfo) *ChangeInfo {
+	if info == nil {
+		return nil
+	}
+
+	return &ChangeInfo{
+		Updated:    info.Updated,
+		Removed:    info.Removed,
+		Matched:    info.Matched,
+		UpsertedId: info.UpsertedId,
+	}
+}
+
+// mgoError translates mgo errors to monger errors
+func mgoError(err error) error {
+	if err == mgo.ErrNotFound {
+		return ErrNotFound
+	}
+
+	if mgo.IsDup(err) {
+		return &DuplicateDocumentError{NewError(err.Error())}
+	}
+
+	if mgoErrorCode(err) == 121 {
+		return &DocumentValidationError{NewError(err.Error())}
+	}
+
+	return err
+}
+
+// mgoErrorCode returns the server code of an error, 0 without one
+func mgoErrorCode(err error) int {
+	switch e := err.(type) {
+	case *mgo.LastError:
+		return e.Code
+	case *mgo.QueryError:
+		return e.Code
+	case *mgo.BulkError:
+		if cases := e.Cases(); len(cases) > 0 {
+			return mgoErrorCode(cases[0].Err)
+		}
+	}
+
+	return 0
+}
+
+// mgoRun executes fn and waits for it unless ctx is done first, see mgoCall
+func mgoRun(ctx context.Context, fn func() error) error {
+	_, err := mgoCall(ctx, func() (struct{}, error) {
+		return struct{}{}, fn()
+	}, nil)
+
+	return err
+}
+
+// mgoCall returns the value of fn unless ctx is done first, then release
+// runs once fn returns. mgo.v2 has no notion of context so an abandoned
+// operation still runs to completion in the background, its value is
+// dropped.
+func mgoCall[T any](ctx context.Context, fn func() (T, error), release func()) (T, error) {
+	if release == nil {
+		release = func() {}
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if err := ctx.Err(); err != nil {
+		release()
+		var zero T
+		return zero, err
+	}
+
+	if ctx.Done() == nil {
+		defer release()
+		value, err := fn()
+		return value, mgoError(err)
+	}
+
+	type outcome struct {
+		value T
+		err   error
+	}
+
+	done := make(chan outcome, 1)
+	go func() {
+		defer release()
+		value, err := fn()
+		done <- outcome{value, err}
+	}()
+
+	select {
+	case o := <-done:
+		return o.value, mgoError(o.err)
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// mgoSetMaxTime lets the server abort the query when the context deadline passes.
+func mgoSetMaxTime(ctx context.Context, query *mgo.Query) {
+	if d := mgoMaxTime(ctx); d > 0 {
+		query.SetMaxTime(d)
+	}
+}
+
+// mgoMaxTime is the time left before the deadline of ctx, 0 without one
+func mgoMaxTime(ctx context.Context) time.Duration {
+	if ctx == nil {
+		return 0
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if d := time.Until(deadline); d > 0 {
+			// a max time of 0 is no limit
+			if d < time.Millisecond {
+				d = time.Millisecond
+			}
+			return d
+		}
+	}
+
+	return 0
+}
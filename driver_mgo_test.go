@@ -0,0 +1,163 @@
+package monger
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// wireServer answers the legacy wire protocol of mgo: the commands succeed,
+// an aggregate command is recorded and a query waits for release then
+// returns a cursor which needs a getMore.
+type wireServer struct {
+	listener  net.Listener
+	release   chan struct{}
+	getMore   chan struct{}
+	aggregate chan bson.M
+}
+
+func newWireServer(t *testing.T) *wireServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	s := &wireServer{
+		listener:  listener,
+		release:   make(chan struct{}),
+		getMore:   make(chan struct{}, 1),
+		aggregate: make(chan bson.M, 1),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+
+	return s
+}
+
+func (s *wireServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	for {
+		header := make([]byte, 16)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return
+		}
+		body := make([]byte, binary.LittleEndian.Uint32(header)-16)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+		requestID := binary.LittleEndian.Uint32(header[4:])
+
+		switch binary.LittleEndian.Uint32(header[12:]) {
+		case 2004: // OP_QUERY
+			name := string(body[4 : 4+strings.IndexByte(string(body[4:]), 0)])
+			if strings.HasSuffix(name, ".$cmd") {
+				cmd := bson.M{}
+				bson.Unmarshal(body[4+len(name)+1+8:], &cmd)
+				if _, ok := cmd["aggregate"]; ok {
+					s.aggregate <- cmd
+					batch := []bson.M{{"_id": bson.NewObjectId(), "name": "first"}}
+					s.reply(conn, requestID, 0, bson.M{"cursor": bson.M{"firstBatch": batch, "id": int64(0)}, "ok": 1})
+					continue
+				}
+
+				s.reply(conn, requestID, 0, bson.M{"ismaster": true, "maxWireVersion": 0, "nonce": "2375531c32080ae8", "ok": 1})
+				continue
+			}
+
+			<-s.release
+			s.reply(conn, requestID, 42, bson.M{"_id": bson.NewObjectId(), "name": "first"})
+		case 2005: // OP_GET_MORE
+			s.reply(conn, requestID, 0, bson.M{"_id": bson.NewObjectId(), "name": "second"})
+			s.getMore <- struct{}{}
+		}
+	}
+}
+
+func (s *wireServer) reply(conn net.Conn, responseTo uint32, cursorID int64, doc bson.M) {
+	data, _ := bson.Marshal(doc)
+
+	msg := make([]byte, 36, 36+len(data))
+	binary.LittleEndian.PutUint32(msg[8:], responseTo)
+	binary.LittleEndian.PutUint32(msg[12:], 1) // OP_REPLY
+	binary.LittleEndian.PutUint64(msg[20:], uint64(cursorID))
+	binary.LittleEndian.PutUint32(msg[32:], 1)
+	msg = append(msg, data...)
+	binary.LittleEndian.PutUint32(msg, uint32(len(msg)))
+
+	conn.Write(msg)
+}
+
+func TestMgoCancelledOperation(t *testing.T) {
+	server := newWireServer(t)
+	conn, err := Connect(
+		ConnectionName("mgo_cancel"),
+		DialInfo(&mgo.DialInfo{Addrs: []string{server.listener.Addr().String()}, Direct: true, Timeout: time.Second}),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	members := make([]*Member, 0)
+	err = conn.M(new(Member)).WithContext(ctx).FindAll(&members)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// the abandoned query goes on with a session of its own and never
+	// writes to the result
+	close(server.release)
+	select {
+	case <-server.getMore:
+	case <-time.After(time.Second):
+		t.Fatal("the abandoned query did not get more")
+	}
+	time.Sleep(20 * time.Millisecond)
+	assert.Empty(t, members)
+}
+
+func TestMgoAggregateMaxTime(t *testing.T) {
+	server := newWireServer(t)
+	conn, err := Connect(
+		ConnectionName("mgo_aggregate"),
+		DialInfo(&mgo.DialInfo{Addrs: []string{server.listener.Addr().String()}, Direct: true, Timeout: time.Second}),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	members := make([]bson.M, 0)
+	err = conn.M(new(Member)).WithContext(ctx).Pipe(bson.M{"$match": bson.M{"username": "alice"}}).All(&members)
+	assert.NoError(t, err)
+	assert.Len(t, members, 1)
+
+	cmd := <-server.aggregate
+	assert.Equal(t, "member", cmd["aggregate"])
+	if maxTime, ok := cmd["maxTimeMS"].(int64); assert.True(t, ok) {
+		assert.True(t, maxTime > 0 && maxTime <= 60000, maxTime)
+	}
+}
@@ -0,0 +1,410 @@
+package monger
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+/*
+KeyProvider returns the keys of the encrypted fields, the values are
+encrypted with the current key and decrypted with the key they were
+encrypted with, rotating a key is making a new key the current one:
+
+	monger.SetKeyProvider(&monger.StaticKeys{
+		Current: "2026-10",
+		Keys: map[string][]byte{
+			"2026-01": oldKey,
+			"2026-10": newKey,
+		},
+	})
+
+The keys are 16, 24 or 32 bytes long for AES-128, AES-192 or AES-256.
+*/
+type KeyProvider interface {
+	// CurrentKeyID is the id of the key encrypting the new values
+	CurrentKeyID() (string, error)
+	// Key returns the key of an id
+	Key(id string) ([]byte, error)
+}
+
+// KeyLister is implemented by the key providers which list their keys, the
+// equality filters of the deterministic fields then match the values of
+// every key.
+type KeyLister interface {
+	KeyIDs() ([]string, error)
+}
+
+// StaticKeys is a KeyProvider of keys held in memory
+type StaticKeys struct {
+	Current string
+	Keys    map[string][]byte
+}
+
+func (k *StaticKeys) CurrentKeyID() (string, error) {
+	return k.Current, nil
+}
+
+func (k *StaticKeys) Key(id string) ([]byte, error) {
+	key, ok := k.Keys[id]
+	if !ok {
+		return nil, &EncryptionError{NewError(fmt.Sprintf("[monger] unknown encryption key %q", id))}
+	}
+
+	return key, nil
+}
+
+func (k *StaticKeys) KeyIDs() ([]string, error) {
+	ids := make([]string, 0, len(k.Keys))
+	for id := range k.Keys {
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+var keyProvider = struct {
+	sync.RWMutex
+	provider KeyProvider
+}{}
+
+// SetKeyProvider sets the provider of the keys of the encrypted fields
+func SetKeyProvider(provider KeyProvider) {
+	keyProvider.Lock()
+	defer keyProvider.Unlock()
+
+	keyProvider.provider = provider
+}
+
+func getKeyProvider() (KeyProvider, error) {
+	keyProvider.RLock()
+	defer keyProvider.RUnlock()
+
+	if keyProvider.provider == nil {
+		return nil, &EncryptionError{NewError("[monger] encrypted fields need a key provider, see SetKeyProvider")}
+	}
+
+	return keyProvider.provider, nil
+}
+
+// encryptedPrefix starts the stored values of the encrypted fields, the
+// key id and the base64 nonce and ciphertext follow it.
+const encryptedPrefix = "menc:1:"
+
+// isEncrypted tells if the field is encrypted, and if it is deterministic
+func (f *SchemaField) isEncrypted() (bool, bool) {
+	mode, ok := f.TagMap["ENCRYPT"]
+	return ok, ok && strings.EqualFold(mode, "deterministic")
+}
+
+// encryptValue encrypts a value with the key id, a deterministic value
+// derives its nonce from the value so equal values are equal once encrypted.
+func encryptValue(provider KeyProvider, id string, plaintext string, deterministic bool) (string, error) {
+	key, err := provider.Key(id)
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if deterministic {
+		mac := hmac.New(sha256.New, nonceKey(key))
+		mac.Write([]byte(plaintext))
+		copy(nonce, mac.Sum(nil))
+	} else if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(id))
+	return encryptedPrefix + id + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// nonceKey derives the key of the nonces of the deterministic values from an
+// encryption key, the encryption key is never used as a MAC key.
+func nonceKey(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("monger deterministic nonce"))
+	return mac.Sum(nil)
+}
+
+// decryptValue decrypts a stored value, a value which is not encrypted is
+// returned as it is.
+func decryptValue(provider KeyProvider, value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+
+	rest := value[len(encryptedPrefix):]
+	sep := strings.LastIndex(rest, ":")
+	if sep < 0 {
+		return "", &EncryptionError{NewError("[monger] malformed encrypted value")}
+	}
+
+	id := rest[:sep]
+	sealed, err := base64.StdEncoding.DecodeString(rest[sep+1:])
+	if err != nil {
+		return "", &EncryptionError{NewError(fmt.Sprintf("[monger] malformed encrypted value: %v", err))}
+	}
+
+	key, err := provider.Key(id)
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return "", &EncryptionError{NewError("[monger] malformed encrypted value")}
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(id))
+	if err != nil {
+		return "", &EncryptionError{NewError(fmt.Sprintf("[monger] can not decrypt a value of key %q: %v", id, err))}
+	}
+
+	return string(plaintext), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, &EncryptionError{NewError(fmt.Sprintf("[monger] invalid encryption key: %v", err))}
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// encryptColumns encrypts the values of the encrypted columns of values
+func encryptColumns(schemaStruct *SchemaStruct, values map[string]interface{}) error {
+	var (
+		provider KeyProvider
+		id       string
+	)
+
+	for _, field := range schemaStruct.Fields {
+		encrypted, deterministic := field.isEncrypted()
+		if !encrypted {
+			continue
+		}
+
+		value, ok := values[field.columnName()]
+		if !ok {
+			continue
+		}
+
+		plaintext, ok := value.(string)
+		if !ok {
+			return &EncryptionError{NewError(fmt.Sprintf("[monger] the encrypted field %s must be a string, got %T", field.Name, value))}
+		}
+
+		if provider == nil {
+			var err error
+			if provider, err = getKeyProvider(); err != nil {
+				return err
+			}
+			if id, err = provider.CurrentKeyID(); err != nil {
+				return err
+			}
+		}
+
+		ciphertext, err := encryptValue(provider, id, plaintext, deterministic)
+		if err != nil {
+			return err
+		}
+		values[field.columnName()] = ciphertext
+	}
+
+	return nil
+}
+
+// encryptUpdate encrypts the encrypted columns of an update document
+func encryptUpdate(schemaStruct *SchemaStruct, update bson.M) error {
+	for key, values := range update {
+		if !strings.HasPrefix(key, "$") {
+			return encryptColumns(schemaStruct, update)
+		}
+
+		if key != "$set" && key != "$setOnInsert" {
+			continue
+		}
+
+		switch v := values.(type) {
+		case bson.M:
+			if err := encryptColumns(schemaStruct, v); err != nil {
+				return err
+			}
+		case map[string]interface{}:
+			if err := encryptColumns(schemaStruct, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// decryptDocument decrypts the encrypted fields of a decoded document
+func decryptDocument(doc interface{}) error {
+	schemaStruct := GetSchemaStruct(doc)
+	docv := reflect.ValueOf(doc)
+	for docv.Kind() == reflect.Ptr {
+		docv = docv.Elem()
+	}
+
+	var provider KeyProvider
+	for _, field := range schemaStruct.Fields {
+		if encrypted, _ := field.isEncrypted(); !encrypted || field.Struct.Type.Kind() != reflect.String {
+			continue
+		}
+
+		value := docv.FieldByIndex(field.InlineIndex)
+		if !strings.HasPrefix(value.String(), encryptedPrefix) {
+			continue
+		}
+
+		if provider == nil {
+			var err error
+			if provider, err = getKeyProvider(); err != nil {
+				return err
+			}
+		}
+
+		plaintext, err := decryptValue(provider, value.String())
+		if err != nil {
+			return err
+		}
+		value.SetString(plaintext)
+	}
+
+	return nil
+}
+
+// encryptedFilter returns the filter of an encrypted field, it matches the
+// value encrypted with every listed key. The plain value is never returned,
+// a filter which can not be encrypted fails: a filter of a randomized field,
+// a value which is not a string or an operator other than $eq, $in, $ne,
+// $nin and $exists.
+func encryptedFilter(field *SchemaField, value interface{}) (interface{}, error) {
+	encrypted, deterministic := field.isEncrypted()
+	if !encrypted {
+		return value, nil
+	}
+
+	if !deterministic {
+		return nil, &EncryptionError{NewError(fmt.Sprintf("[monger] the encrypted field %s is randomized, it can not be filtered", field.Name))}
+	}
+
+	provider, err := getKeyProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	if lister, ok := provider.(KeyLister); ok {
+		ids, err = lister.KeyIDs()
+	} else {
+		var id string
+		id, err = provider.CurrentKeyID()
+		ids = append(ids, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	encrypt := func(v interface{}) ([]interface{}, error) {
+		plaintext, ok := v.(string)
+		if !ok {
+			return nil, &EncryptionError{NewError(fmt.Sprintf("[monger] the filter of the encrypted field %s must be a string, got %T", field.Name, v))}
+		}
+
+		values := make([]interface{}, 0, len(ids))
+		for _, id := range ids {
+			ciphertext, err := encryptValue(provider, id, plaintext, true)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, ciphertext)
+		}
+		return values, nil
+	}
+
+	switch v := value.(type) {
+	case string:
+		values, err := encrypt(v)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{"$in": values}, nil
+	case bson.M:
+		operators := bson.M{}
+		for op, arg := range v {
+			switch op {
+			case "$eq", "$in", "$ne", "$nin":
+				values, err := encryptAll(arg, encrypt)
+				if err != nil {
+					return nil, err
+				}
+
+				to := "$in"
+				if op == "$ne" || op == "$nin" {
+					to = "$nin"
+				}
+				operators[to] = append(toInterfaces(operators[to]), values...)
+			case "$exists":
+				operators[op] = arg
+			default:
+				return nil, &EncryptionError{NewError(fmt.Sprintf("[monger] the operator %s can not filter the encrypted field %s", op, field.Name))}
+			}
+		}
+		return operators, nil
+	}
+
+	return nil, &EncryptionError{NewError(fmt.Sprintf("[monger] the filter of the encrypted field %s must be a string, got %T", field.Name, value))}
+}
+
+// encryptAll encrypts a value or the items of a list of values
+func encryptAll(arg interface{}, encrypt func(v interface{}) ([]interface{}, error)) ([]interface{}, error) {
+	values := make([]interface{}, 0)
+	for _, item := range toInterfaces(arg) {
+		encrypted, err := encrypt(item)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, encrypted...)
+	}
+
+	return values, nil
+}
+
+func toInterfaces(v interface{}) []interface{} {
+	switch items := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return items
+	case []string:
+		values := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			values = append(values, item)
+		}
+		return values
+	}
+
+	return []interface{}{v}
+}
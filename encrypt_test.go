@@ -0,0 +1,119 @@
+package monger
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestEncryptValue(t *testing.T) {
+	keys := &StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": []byte("0123456789abcdef")}}
+
+	first, err := encryptValue(keys, "k1", "AB123", true)
+	assert.NoError(t, err)
+	second, err := encryptValue(keys, "k1", "AB123", true)
+	assert.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	// the nonce is not a MAC of the encryption key
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(first, encryptedPrefix+"k1:"))
+	assert.NoError(t, err)
+	mac := hmac.New(sha256.New, keys.Keys["k1"])
+	mac.Write([]byte("AB123"))
+	assert.NotEqual(t, mac.Sum(nil)[:12], sealed[:12])
+
+	random, err := encryptValue(keys, "k1", "AB123", false)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, random)
+
+	for _, ciphertext := range []string{first, random} {
+		plaintext, err := decryptValue(keys, ciphertext)
+		assert.NoError(t, err)
+		assert.Equal(t, "AB123", plaintext)
+	}
+
+	plaintext, err := decryptValue(keys, "not encrypted")
+	assert.NoError(t, err)
+	assert.Equal(t, "not encrypted", plaintext)
+
+	keys.Keys["k1"] = []byte("fedcba9876543210")
+	_, err = decryptValue(keys, first)
+	assert.IsType(t, &EncryptionError{}, err)
+
+	_, err = encryptValue(keys, "k2", "AB123", false)
+	assert.IsType(t, &EncryptionError{}, err)
+}
+
+func TestEncryptedFilter(t *testing.T) {
+	type Patient struct {
+		Schema `json:",inline" bson:",inline"`
+		Name   string `json:"name" bson:"name"`
+		SSN    string `json:"ssn" bson:"ssn" monger:"encrypt=deterministic"`
+		Notes  string `json:"notes" bson:"notes" monger:"encrypt"`
+	}
+	schemaStruct := GetSchemaStruct(new(Patient))
+
+	// the plain value is never sent without a key provider
+	SetKeyProvider(nil)
+	where, err := toWhere(schemaStruct, bson.M{"$or": []bson.M{{"ssn": "123"}}})
+	assert.IsType(t, &EncryptionError{}, err)
+	assert.Nil(t, where)
+
+	q := &query{schemaStruct: schemaStruct}
+	q.Where(bson.M{"name": "ada"}).Where(bson.M{"ssn": "123"})
+	assert.NotContains(t, q.where, "ssn")
+	assert.IsType(t, &EncryptionError{}, q.FindOne(new(Patient)))
+
+	keys := &StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": []byte("0123456789abcdef")}}
+	SetKeyProvider(keys)
+	defer SetKeyProvider(nil)
+
+	ciphertext, err := encryptValue(keys, "k1", "123", true)
+	assert.NoError(t, err)
+	where, err = toWhere(schemaStruct, bson.M{"ssn": "123", "name": "ada"})
+	assert.NoError(t, err)
+	assert.Equal(t, bson.M{"ssn": bson.M{"$in": []interface{}{ciphertext}}, "name": "ada"}, where)
+
+	where, err = toWhere(schemaStruct, bson.M{"ssn": bson.M{"$ne": "123", "$exists": true}})
+	assert.NoError(t, err)
+	assert.Equal(t, bson.M{"ssn": bson.M{"$nin": []interface{}{ciphertext}, "$exists": true}}, where)
+
+	// randomized fields, other values and other operators can not be encrypted
+	for _, condition := range []bson.M{
+		{"notes": "late"},
+		{"notes": bson.M{"$exists": true}},
+		{"ssn": 123},
+		{"ssn": bson.M{"$in": []interface{}{"123", 456}}},
+		{"ssn": bson.M{"$gt": "123"}},
+	} {
+		_, err = toWhere(schemaStruct, condition)
+		assert.IsType(t, &EncryptionError{}, err, "%v", condition)
+	}
+}
+
+func TestEncryptedUpsertDefaults(t *testing.T) {
+	type Subscription struct {
+		Schema `json:",inline" bson:",inline"`
+		Owner  string `json:"owner" bson:"owner"`
+		Tier   string `json:"tier" bson:"tier" monger:"encrypt,default=basic"`
+	}
+	schemaStruct := GetSchemaStruct(new(Subscription))
+
+	keys := &StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": []byte("0123456789abcdef")}}
+	SetKeyProvider(keys)
+	defer SetKeyProvider(nil)
+
+	update, err := withInsertDefaults(schemaStruct, bson.M{"$set": bson.M{"owner": "ada"}})
+	assert.NoError(t, err)
+
+	tier, _ := update.(bson.M)["$setOnInsert"].(bson.M)["tier"].(string)
+	assert.NotEqual(t, "basic", tier)
+	plaintext, err := decryptValue(keys, tier)
+	assert.NoError(t, err)
+	assert.Equal(t, "basic", plaintext)
+}
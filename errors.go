@@ -12,10 +12,60 @@ type NotFoundError struct {
 	*MongerQueryError
 }
 
+// ErrNotFound is returned by drivers when no document matched
+var ErrNotFound = &NotFoundError{NewError("not found")}
+
+type ConnectionError struct {
+	*MongerQueryError
+}
+
+// ErrConnectionClosed is returned by the queries of a closed connection
+var ErrConnectionClosed = &ConnectionError{NewError("[monger] the connection is closed")}
+
+type TenantError struct {
+	*MongerQueryError
+}
+
+// ErrNoTenant is returned by the queries without a tenant in strict mode
+var ErrNoTenant = &TenantError{NewError("[monger] the query has no tenant")}
+
+// ConcurrentModificationError is returned by the update of a versioned
+// document when another update changed its version
+type ConcurrentModificationError struct {
+	*MongerQueryError
+}
+
+// EncryptionError is returned when an encrypted field can not be encrypted
+// or decrypted
+type EncryptionError struct {
+	*MongerQueryError
+}
+
+// DocumentValidationError is returned when the validator of a collection
+// rejects a document
+type DocumentValidationError struct {
+	*MongerQueryError
+}
+
 type DuplicateDocumentError struct {
 	*MongerQueryError
 }
 
+type DuplicateModelError struct {
+	*MongerQueryError
+}
+
+type NotRegisteredError struct {
+	*MongerQueryError
+}
+
+// SchemaLintError is returned by the registration of schemas with
+// problems, see StrictSchemas
+type SchemaLintError struct {
+	*MongerQueryError
+	Problems []*SchemaProblem
+}
+
 type ValidationError struct {
 	*MongerQueryError
 	Errors []error
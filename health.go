@@ -0,0 +1,175 @@
+package monger
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Health is a report of the state of a connection
+type Health struct {
+	// Healthy is false from a failed ping until the connection is back
+	Healthy      bool
+	Reconnecting bool
+	Reconnects   int
+	// LastPing is when the last ping ran and Latency how long it took
+	LastPing  time.Time
+	Latency   time.Duration
+	LastError error
+	// SessionStatus is empty when the driver does not report one
+	SessionStatus
+}
+
+// healthState is the part of Health kept by the connection
+type healthState struct {
+	mu     sync.Mutex
+	health Health
+}
+
+func (s *healthState) update(f func(h *Health)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f(&s.health)
+}
+
+func (s *healthState) get() Health {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.health
+}
+
+// Ping checks the connection reaches the deployment, the result is kept
+// for Health.
+func (conn *connection) Ping(ctx context.Context) error {
+	session, err := conn.copySession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	start := time.Now()
+	err = session.Ping(ctx)
+	conn.health.update(func(h *Health) {
+		h.LastPing = start
+		h.Latency = time.Since(start)
+		h.LastError = err
+		if err != nil {
+			h.Healthy = false
+		} else if !h.Reconnecting {
+			h.Healthy = true
+		}
+	})
+
+	return err
+}
+
+// Health reports the state of the connection, it does not ping: the
+// result of the last Ping or health check is reported.
+func (conn *connection) Health() Health {
+	health := conn.health.get()
+
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+
+	if conn.Session == nil {
+		health.Healthy = false
+		health.LastError = ErrConnectionClosed
+		return health
+	}
+
+	if reporter, ok := conn.Session.(StatusReporter); ok {
+		health.SessionStatus = reporter.Status()
+	}
+
+	return health
+}
+
+// watch pings the deployment every check interval and reconnects when a
+// ping fails, until stop is closed.
+func (conn *connection) watch(policy ReconnectPolicy, stop <-chan struct{}) {
+	ticker := time.NewTicker(policy.checkInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), policy.checkInterval())
+		err := conn.Ping(ctx)
+		cancel()
+
+		if err != nil && err != ErrConnectionClosed {
+			log.Printf("[monger] ping failed: %v, reconnecting \r\n", err)
+			conn.reconnect(policy, stop)
+		}
+	}
+}
+
+// reconnect dials the deployment again with backoff
+func (conn *connection) reconnect(policy ReconnectPolicy, stop <-chan struct{}) {
+	conn.health.update(func(h *Health) { h.Reconnecting = true })
+	defer conn.health.update(func(h *Health) { h.Reconnecting = false })
+
+	interval := policy.initialInterval()
+	for attempt := 1; policy.MaxAttempts == 0 || attempt <= policy.MaxAttempts; attempt++ {
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+
+		err := conn.dial(stop)
+		if err == nil {
+			conn.health.update(func(h *Health) {
+				h.Healthy = true
+				h.LastError = nil
+				h.Reconnects++
+			})
+			log.Printf("[monger] reconnected after %d attempts \r\n", attempt)
+			return
+		}
+
+		conn.health.update(func(h *Health) { h.LastError = err })
+		interval = policy.next(interval)
+	}
+}
+
+func (p ReconnectPolicy) checkInterval() time.Duration {
+	if p.CheckInterval > 0 {
+		return p.CheckInterval
+	}
+
+	return 10 * time.Second
+}
+
+func (p ReconnectPolicy) initialInterval() time.Duration {
+	if p.InitialInterval > 0 {
+		return p.InitialInterval
+	}
+
+	return 500 * time.Millisecond
+}
+
+// next returns the backoff interval following interval
+func (p ReconnectPolicy) next(interval time.Duration) time.Duration {
+	multiplier, max := p.Multiplier, p.MaxInterval
+	if multiplier <= 1 {
+		multiplier = 2
+	}
+
+	if max <= 0 {
+		max = 30 * time.Second
+	}
+
+	if next := time.Duration(float64(interval) * multiplier); next < max {
+		return next
+	}
+
+	return max
+}
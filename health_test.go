@@ -0,0 +1,96 @@
+package monger
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyDriver dials sessions whose ping fails while down is set
+type flakyDriver struct {
+	down  int32
+	dials int32
+}
+
+func (d *flakyDriver) Dial(ctx context.Context, config *Config) (Session, error) {
+	atomic.AddInt32(&d.dials, 1)
+	return &flakySession{d}, nil
+}
+
+type flakySession struct {
+	driver *flakyDriver
+}
+
+func (s *flakySession) DB(name string) Database { return nil }
+func (s *flakySession) Clone() Session          { return s }
+func (s *flakySession) Copy() Session           { return s }
+func (s *flakySession) Close()                  {}
+
+func (s *flakySession) Ping(ctx context.Context) error {
+	if atomic.LoadInt32(&s.driver.down) == 1 {
+		return errors.New("no reachable servers")
+	}
+
+	return nil
+}
+
+func (s *flakySession) Status() SessionStatus {
+	return SessionStatus{Servers: []ServerStatus{{Address: "localhost:27017", Kind: "Standalone"}}}
+}
+
+func TestPingAndHealth(t *testing.T) {
+	driver := new(flakyDriver)
+	conn, err := Connect(UseDriver(driver))
+	assert.NoError(t, err)
+
+	assert.NoError(t, conn.Ping(context.Background()))
+	health := conn.Health()
+	assert.True(t, health.Healthy)
+	assert.Equal(t, "localhost:27017", health.Servers[0].Address)
+
+	atomic.StoreInt32(&driver.down, 1)
+	assert.Error(t, conn.Ping(context.Background()))
+	assert.False(t, conn.Health().Healthy)
+	assert.Error(t, conn.Health().LastError)
+
+	conn.Close()
+	assert.Equal(t, ErrConnectionClosed, conn.Ping(context.Background()))
+	assert.Equal(t, ErrConnectionClosed, conn.Health().LastError)
+}
+
+func TestReconnect(t *testing.T) {
+	driver := new(flakyDriver)
+	conn, err := Connect(UseDriver(driver), WithReconnect(ReconnectPolicy{
+		CheckInterval:   5 * time.Millisecond,
+		InitialInterval: time.Millisecond,
+	}))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	atomic.StoreInt32(&driver.down, 1)
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&driver.dials) > 1
+	}, time.Second, time.Millisecond)
+
+	atomic.StoreInt32(&driver.down, 0)
+	assert.Eventually(t, func() bool {
+		health := conn.Health()
+		return health.Healthy && health.Reconnects > 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestReconnectPolicyBackoff(t *testing.T) {
+	policy := ReconnectPolicy{InitialInterval: time.Second, MaxInterval: 5 * time.Second}
+	interval := policy.initialInterval()
+	intervals := make([]time.Duration, 0)
+	for i := 0; i < 4; i++ {
+		interval = policy.next(interval)
+		intervals = append(intervals, interval)
+	}
+
+	assert.Equal(t, []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}, intervals)
+}
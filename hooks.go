@@ -0,0 +1,288 @@
+package monger
+
+import (
+	"context"
+	"reflect"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+/*
+The hooks below are optional methods of a schema, monger calls them with
+the context of the query:
+
+	func (m *Member) BeforeSave(ctx context.Context) error {
+		m.Email = strings.ToLower(m.Email)
+		return nil
+	}
+
+An error returned by a before hook aborts the operation, an error returned
+by an after hook is returned once the operation is done.
+
+BeforeSave runs before BeforeCreate and BeforeUpdate. The update hooks only
+run when a schema document is updated, an update document like
+bson.M{"$inc": ...} has no document to call them on. The delete hooks load
+the matched documents before they are deleted.
+*/
+type BeforeSaveHook interface {
+	BeforeSave(ctx context.Context) error
+}
+
+// BeforeCreateHook runs before a document is validated and inserted
+type BeforeCreateHook interface {
+	BeforeCreate(ctx context.Context) error
+}
+
+// AfterCreateHook runs once a document is inserted
+type AfterCreateHook interface {
+	AfterCreate(ctx context.Context) error
+}
+
+// BeforeUpdateHook runs before a schema document is validated and updated
+type BeforeUpdateHook interface {
+	BeforeUpdate(ctx context.Context) error
+}
+
+// AfterUpdateHook runs once a schema document is updated
+type AfterUpdateHook interface {
+	AfterUpdate(ctx context.Context) error
+}
+
+// BeforeDeleteHook runs on every matched document before it is deleted
+type BeforeDeleteHook interface {
+	BeforeDelete(ctx context.Context) error
+}
+
+// AfterDeleteHook runs on every matched document once it is deleted
+type AfterDeleteHook interface {
+	AfterDelete(ctx context.Context) error
+}
+
+// AfterFindHook runs on every document a find decodes
+type AfterFindHook interface {
+	AfterFind(ctx context.Context) error
+}
+
+var (
+	typeBeforeDeleteHook = reflect.TypeOf((*BeforeDeleteHook)(nil)).Elem()
+	typeAfterDeleteHook  = reflect.TypeOf((*AfterDeleteHook)(nil)).Elem()
+)
+
+func beforeCreateHooks(ctx context.Context, doc interface{}) error {
+	if hook, ok := doc.(BeforeSaveHook); ok {
+		if err := hook.BeforeSave(ctx); err != nil {
+			return err
+		}
+	}
+
+	if hook, ok := doc.(BeforeCreateHook); ok {
+		return hook.BeforeCreate(ctx)
+	}
+
+	return nil
+}
+
+func afterCreateHooks(ctx context.Context, doc interface{}) error {
+	if hook, ok := doc.(AfterCreateHook); ok {
+		return hook.AfterCreate(ctx)
+	}
+
+	return nil
+}
+
+func beforeUpdateHooks(ctx context.Context, doc interface{}) error {
+	if hook, ok := doc.(BeforeSaveHook); ok {
+		if err := hook.BeforeSave(ctx); err != nil {
+			return err
+		}
+	}
+
+	if hook, ok := doc.(BeforeUpdateHook); ok {
+		return hook.BeforeUpdate(ctx)
+	}
+
+	return nil
+}
+
+func afterUpdateHooks(ctx context.Context, doc interface{}) error {
+	if hook, ok := doc.(AfterUpdateHook); ok {
+		return hook.AfterUpdate(ctx)
+	}
+
+	return nil
+}
+
+// updateDocument returns the schema document of an update, a schema or
+// the schema of a $set, nil for the other updates.
+func updateDocument(update interface{}) Schemer {
+	if doc, ok := update.(Schemer); ok {
+		return doc
+	}
+
+	if m, ok := update.(bson.M); ok {
+		if doc, ok := m["$set"].(Schemer); ok {
+			return doc
+		}
+	}
+
+	return nil
+}
+
+// afterFind initializes, decrypts and tracks a decoded document or every
+// document of a decoded slice, and calls their AfterFind.
+func afterFind(ctx context.Context, result interface{}) error {
+	if doc, ok := result.(Schemer); ok {
+		doc.Init(result)
+		if err := decryptDocument(result); err != nil {
+			return err
+		}
+		if err := doc.track(); err != nil {
+			return err
+		}
+	}
+
+	if hook, ok := result.(AfterFindHook); ok {
+		return hook.AfterFind(ctx)
+	}
+
+	resultv := reflect.ValueOf(result)
+	for resultv.Kind() == reflect.Ptr {
+		resultv = resultv.Elem()
+	}
+
+	if resultv.Kind() == reflect.Interface && !resultv.IsNil() {
+		// a document decoded into the schema of its kind
+		return afterFind(ctx, resultv.Elem().Interface())
+	}
+
+	if resultv.Kind() != reflect.Slice {
+		return nil
+	}
+
+	for i := 0; i < resultv.Len(); i++ {
+		item := resultv.Index(i)
+		if item.Kind() == reflect.Interface {
+			if item.IsNil() {
+				continue
+			}
+			item = item.Elem()
+		}
+		if item.Kind() != reflect.Ptr && item.CanAddr() {
+			item = item.Addr()
+		}
+
+		if item.Kind() == reflect.Ptr && item.IsNil() {
+			continue
+		}
+
+		if doc, ok := item.Interface().(Schemer); ok {
+			doc.Init(doc)
+			if err := decryptDocument(doc); err != nil {
+				return err
+			}
+			if err := doc.track(); err != nil {
+				return err
+			}
+		}
+
+		if hook, ok := item.Interface().(AfterFindHook); ok {
+			if err := hook.AfterFind(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// hasDeleteHooks tells if the delete of the schema has to load the documents
+func hasDeleteHooks(schemaStruct *SchemaStruct) bool {
+	if schemaStruct == nil || schemaStruct.Type == nil {
+		return false
+	}
+
+	t := reflect.PtrTo(schemaStruct.Type)
+	return t.Implements(typeBeforeDeleteHook) || t.Implements(typeAfterDeleteHook)
+}
+
+// deleteOne deletes the first matched document with remove, the delete
+// hooks of the schema run on the document which is loaded first like a
+// document found, with AfterFind.
+func (q *query) deleteOne(remove func(selector bson.M) error) error {
+	if q.err != nil {
+		return q.err
+	}
+	if !hasDeleteHooks(q.schemaStruct) {
+		return remove(q.where)
+	}
+
+	doc := reflect.New(q.schemaStruct.Type).Interface()
+	if err := q.coll().Find(q.context(), q.where, nil).One(doc); err != nil {
+		return err
+	}
+	if err := afterFind(q.context(), doc); err != nil {
+		return err
+	}
+
+	if hook, ok := doc.(BeforeDeleteHook); ok {
+		if err := hook.BeforeDelete(q.context()); err != nil {
+			return err
+		}
+	}
+
+	id, _ := documentID(q.schemaStruct, doc)
+	if err := remove(bson.M{"_id": id}); err != nil {
+		return err
+	}
+
+	if hook, ok := doc.(AfterDeleteHook); ok {
+		return hook.AfterDelete(q.context())
+	}
+
+	return nil
+}
+
+// deleteAll deletes the matched documents with remove, like deleteOne
+func (q *query) deleteAll(remove func(selector bson.M) (*ChangeInfo, error)) (*ChangeInfo, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+	if !hasDeleteHooks(q.schemaStruct) {
+		return remove(q.where)
+	}
+
+	docs := reflect.New(reflect.SliceOf(reflect.PtrTo(q.schemaStruct.Type)))
+	if err := q.coll().Find(q.context(), q.where, nil).All(docs.Interface()); err != nil {
+		return nil, err
+	}
+	if err := afterFind(q.context(), docs.Interface()); err != nil {
+		return nil, err
+	}
+
+	ids := make([]interface{}, 0, docs.Elem().Len())
+	for i := 0; i < docs.Elem().Len(); i++ {
+		doc := docs.Elem().Index(i).Interface()
+		if hook, ok := doc.(BeforeDeleteHook); ok {
+			if err := hook.BeforeDelete(q.context()); err != nil {
+				return nil, err
+			}
+		}
+		id, _ := documentID(q.schemaStruct, doc)
+		ids = append(ids, id)
+	}
+
+	info, err := remove(bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return info, err
+	}
+
+	for i := 0; i < docs.Elem().Len(); i++ {
+		if hook, ok := docs.Elem().Index(i).Interface().(AfterDeleteHook); ok {
+			if err := hook.AfterDelete(q.context()); err != nil {
+				return info, err
+			}
+		}
+	}
+
+	return info, nil
+}
@@ -0,0 +1,107 @@
+package monger_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/iron-kit/monger"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type Ticket struct {
+	monger.Schema `json:",inline" bson:",inline"`
+	Title         string `json:"title" bson:"title"`
+	Slug          string `json:"slug" bson:"slug"`
+	Locked        bool   `json:"locked" bson:"locked"`
+	calls         []string
+}
+
+func (t *Ticket) BeforeSave(ctx context.Context) error {
+	t.calls = append(t.calls, "BeforeSave")
+	t.Slug = strings.ToLower(strings.Replace(t.Title, " ", "-", -1))
+	return nil
+}
+
+func (t *Ticket) BeforeCreate(ctx context.Context) error {
+	t.calls = append(t.calls, "BeforeCreate")
+	if t.Title == "" {
+		return errors.New("title is empty")
+	}
+	return nil
+}
+
+func (t *Ticket) AfterCreate(ctx context.Context) error {
+	t.calls = append(t.calls, "AfterCreate")
+	return nil
+}
+
+func (t *Ticket) BeforeUpdate(ctx context.Context) error {
+	t.calls = append(t.calls, "BeforeUpdate")
+	return nil
+}
+
+func (t *Ticket) AfterUpdate(ctx context.Context) error {
+	t.calls = append(t.calls, "AfterUpdate")
+	return nil
+}
+
+var deletedTickets []string
+
+func (t *Ticket) BeforeDelete(ctx context.Context) error {
+	// the ticket to delete is loaded like a ticket found
+	if len(t.calls) == 0 || t.calls[0] != "AfterFind" {
+		return errors.New("ticket is not loaded")
+	}
+	if t.Locked {
+		return errors.New("ticket is locked")
+	}
+	return nil
+}
+
+func (t *Ticket) AfterDelete(ctx context.Context) error {
+	deletedTickets = append(deletedTickets, t.Title)
+	return nil
+}
+
+func (t *Ticket) AfterFind(ctx context.Context) error {
+	t.calls = append(t.calls, "AfterFind")
+	return nil
+}
+
+func TestHooks(t *testing.T) {
+	c := memConn(t)
+	TicketModel := c.M(new(Ticket))
+
+	assert.EqualError(t, TicketModel.Create(&Ticket{}), "title is empty")
+	assert.Equal(t, 0, TicketModel.Count())
+
+	ticket := &Ticket{Title: "Broken Login"}
+	assert.NoError(t, TicketModel.Create(ticket))
+	assert.Equal(t, []string{"BeforeSave", "BeforeCreate", "AfterCreate"}, ticket.calls)
+	assert.Equal(t, "broken-login", ticket.Slug)
+
+	ticket.calls = nil
+	ticket.Title = "Broken Signup"
+	assert.NoError(t, TicketModel.Update(bson.M{"_id": ticket.ID}, ticket))
+	assert.Equal(t, []string{"BeforeSave", "BeforeUpdate", "AfterUpdate"}, ticket.calls)
+
+	found := make([]Ticket, 0)
+	assert.NoError(t, TicketModel.FindAll(&found))
+	if assert.Len(t, found, 1) {
+		assert.Equal(t, "broken-signup", found[0].Slug)
+		assert.Equal(t, []string{"AfterFind"}, found[0].calls)
+	}
+
+	assert.NoError(t, TicketModel.Create(&Ticket{Title: "Locked", Locked: true}))
+	assert.EqualError(t, TicketModel.Delete(bson.M{"title": "Locked"}), "ticket is locked")
+	_, err := TicketModel.Where(bson.M{}).DeleteAll()
+	assert.EqualError(t, err, "ticket is locked")
+
+	deletedTickets = nil
+	assert.NoError(t, TicketModel.ForceDelete(bson.M{"_id": ticket.ID}))
+	assert.Equal(t, []string{"Broken Signup"}, deletedTickets)
+	assert.Equal(t, 1, TicketModel.OffSoftDeletes().Count())
+}
@@ -0,0 +1,222 @@
+package monger
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// IDGenerator is implemented by the schemas which compute their primary
+// key, NewID returns a value of the type of the _id field.
+type IDGenerator interface {
+	NewID() interface{}
+}
+
+var (
+	typeObjectId = reflect.TypeOf(bson.ObjectId(""))
+	typeBinary   = reflect.TypeOf(bson.Binary{})
+)
+
+var idGenerators = struct {
+	sync.RWMutex
+	byName map[string]func() interface{}
+}{byName: map[string]func() interface{}{
+	"OBJECTID": func() interface{} { return bson.NewObjectId() },
+	"UUIDV4":   func() interface{} { return newUUID(4) },
+	"UUIDV7":   func() interface{} { return newUUID(7) },
+}}
+
+/*
+RegisterIDGenerator registers a generator of primary keys by name, the
+name is case insensitive:
+
+	monger.RegisterIDGenerator("order", func() interface{} {
+		return fmt.Sprintf("ord_%d", time.Now().UnixNano())
+	})
+
+	type Order struct {
+		monger.BaseSchema `json:",inline" bson:",inline"`
+		ID                string `json:"id" bson:"_id" monger:"id=order"`
+	}
+*/
+func RegisterIDGenerator(name string, fn func() interface{}) {
+	idGenerators.Lock()
+	defer idGenerators.Unlock()
+
+	idGenerators.byName[strings.ToUpper(name)] = fn
+}
+
+func getIDGenerator(name string) (func() interface{}, bool) {
+	idGenerators.RLock()
+	defer idGenerators.RUnlock()
+
+	fn, ok := idGenerators.byName[strings.ToUpper(name)]
+	return fn, ok
+}
+
+// UUID is a random UUID, a version 7 UUID starts with the time it was made
+type UUID [16]byte
+
+func newUUID(version byte) UUID {
+	var uuid UUID
+	if _, err := rand.Read(uuid[:]); err != nil {
+		panic(fmt.Sprintf("[monger] can not read random bytes: %v", err))
+	}
+
+	if version == 7 {
+		ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+		var ts [8]byte
+		binary.BigEndian.PutUint64(ts[:], ms)
+		copy(uuid[:6], ts[2:])
+	}
+
+	uuid[6] = uuid[6]&0x0f | version<<4
+	uuid[8] = uuid[8]&0x3f | 0x80
+
+	return uuid
+}
+
+func (uuid UUID) String() string {
+	s := hex.EncodeToString(uuid[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// idField returns the field of the _id column, nil without one
+func (s *SchemaStruct) idField() *SchemaField {
+	for _, field := range s.Fields {
+		if field.ColumnName == "_id" {
+			return field
+		}
+	}
+
+	return nil
+}
+
+// documentID returns the _id of a schema document
+func documentID(schemaStruct *SchemaStruct, doc interface{}) (interface{}, bool) {
+	field := schemaStruct.idField()
+	docv := reflect.ValueOf(doc)
+	for docv.Kind() == reflect.Ptr {
+		docv = docv.Elem()
+	}
+
+	if field == nil || docv.Kind() != reflect.Struct {
+		return nil, false
+	}
+
+	return docv.FieldByIndex(field.InlineIndex).Interface(), true
+}
+
+// assignID sets a new primary key on a document without one, with the
+// generator of the id tag, of an IDGenerator or of an ObjectId field.
+func assignID(doc interface{}) error {
+	docv := reflect.ValueOf(doc)
+	for docv.Kind() == reflect.Ptr {
+		docv = docv.Elem()
+	}
+
+	field := GetSchemaStruct(doc).idField()
+	if field == nil || docv.Kind() != reflect.Struct {
+		return nil
+	}
+
+	value := docv.FieldByIndex(field.InlineIndex)
+	if !isZero(value) {
+		return nil
+	}
+
+	var generate func() interface{}
+	if name, ok := field.TagMap["ID"]; ok {
+		fn, found := getIDGenerator(name)
+		if !found {
+			return &InvalidParamsError{NewError(fmt.Sprintf("[monger] unknown id generator %q of %T", name, doc))}
+		}
+		generate = fn
+	} else if generator, ok := doc.(IDGenerator); ok {
+		generate = generator.NewID
+	} else if value.Type() == typeObjectId {
+		generate = func() interface{} { return bson.NewObjectId() }
+	} else {
+		return &InvalidIdError{NewError(fmt.Sprintf("[monger] the primary key of %T is not set and has no generator", doc))}
+	}
+
+	id, err := convertID(generate(), value.Type())
+	if err != nil {
+		return err
+	}
+	value.Set(id)
+
+	return nil
+}
+
+// convertID converts a generated primary key to the type of the _id field
+func convertID(id interface{}, t reflect.Type) (reflect.Value, error) {
+	if uuid, ok := id.(UUID); ok {
+		switch {
+		case t == typeBinary:
+			id = bson.Binary{Kind: 0x04, Data: uuid[:]}
+		case t.Kind() == reflect.String:
+			id = uuid.String()
+		}
+	}
+
+	if oid, ok := id.(bson.ObjectId); ok && t.Kind() == reflect.String && t != typeObjectId {
+		id = oid.Hex()
+	}
+
+	v := reflect.ValueOf(id)
+	if !v.IsValid() {
+		return v, &InvalidIdError{NewError(fmt.Sprintf("[monger] the generated primary key is nil, expected %v", t))}
+	}
+
+	if v.Type() != t {
+		// an int converts to a string of one rune, it is not a primary key
+		isString := v.Kind() == reflect.String
+		if !v.Type().ConvertibleTo(t) || isString != (t.Kind() == reflect.String) {
+			return v, &InvalidIdError{NewError(fmt.Sprintf("[monger] the generated primary key %v is not a %v", id, t))}
+		}
+		v = v.Convert(t)
+	}
+
+	return v, nil
+}
+
+// toID converts the primary key of a query to the type of the _id field,
+// an ObjectId primary key is accepted as a hex string.
+func toID(schemaStruct *SchemaStruct, id interface{}) (interface{}, error) {
+	if schemaStruct == nil {
+		return id, nil
+	}
+
+	field := schemaStruct.idField()
+	if field == nil {
+		return id, nil
+	}
+
+	return toFieldValue(field.Struct.Type, id)
+}
+
+// toFieldValue converts a hex string to an ObjectId for a field of type t
+func toFieldValue(t reflect.Type, value interface{}) (interface{}, error) {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+
+	s, ok := value.(string)
+	if !ok || t != typeObjectId {
+		return value, nil
+	}
+
+	if !bson.IsObjectIdHex(s) {
+		return nil, &InvalidIdError{NewError(fmt.Sprintf("[monger] %q is not an ObjectId", s))}
+	}
+
+	return bson.ObjectIdHex(s), nil
+}
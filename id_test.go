@@ -0,0 +1,75 @@
+package monger
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type Token struct {
+	BaseSchema `json:",inline" bson:",inline"`
+	ID         string        `json:"id" bson:"_id" monger:"id=uuidv7"`
+	OwnerID    bson.ObjectId `json:"owner_id" bson:"owner_id"`
+	Code       string        `json:"code" bson:"code"`
+}
+
+type Tag struct {
+	BaseSchema `json:",inline" bson:",inline"`
+	Name       string `json:"name" bson:"_id"`
+}
+
+type Invoice struct {
+	BaseSchema `json:",inline" bson:",inline"`
+	Number     int64 `json:"number" bson:"_id"`
+}
+
+func (i *Invoice) NewID() interface{} {
+	return 1001
+}
+
+func TestAssignID(t *testing.T) {
+	uuidPattern := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	token := &Token{}
+	assert.NoError(t, token.beforeCreate(token))
+	assert.Regexp(t, uuidPattern, token.ID)
+	token.Init(token)
+	assert.False(t, token.IsEmpty())
+
+	member := &Member{}
+	assert.NoError(t, member.beforeCreate(member))
+	assert.True(t, member.ID.Valid())
+
+	invoice := &Invoice{}
+	assert.NoError(t, invoice.beforeCreate(invoice))
+	assert.Equal(t, int64(1001), invoice.Number)
+
+	err := (&Tag{}).beforeCreate(&Tag{})
+	assert.IsType(t, &InvalidIdError{}, err)
+	assert.NoError(t, (&Tag{}).beforeCreate(&Tag{Name: "go"}))
+}
+
+func TestToWhere(t *testing.T) {
+	owner := bson.NewObjectId()
+	where, err := toWhere(GetSchemaStruct(&Token{}), bson.M{
+		"_id":      "5bb8649f16a44b47ae85aa41",
+		"code":     "5bb8649f16a44b47ae85aa41",
+		"owner_id": bson.M{"$in": []string{owner.Hex()}},
+		"$or":      []bson.M{{"owner_id": owner.Hex()}},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "5bb8649f16a44b47ae85aa41", where["_id"])
+	assert.Equal(t, "5bb8649f16a44b47ae85aa41", where["code"])
+	assert.Equal(t, bson.M{"$in": []interface{}{owner}}, where["owner_id"])
+	assert.Equal(t, []bson.M{{"owner_id": owner}}, where["$or"])
+
+	id, err := toID(GetSchemaStruct(&Member{}), owner.Hex())
+	assert.NoError(t, err)
+	assert.Equal(t, owner, id)
+
+	_, err = toID(GetSchemaStruct(&Member{}), "not-an-object-id")
+	assert.IsType(t, &InvalidIdError{}, err)
+}
@@ -0,0 +1,503 @@
+package monger
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Index is an index of a collection. Key lists the fields in order like mgo
+does: a "-" prefix sorts descending, "$text:" and "$2dsphere:" prefixes
+declare text and geospatial fields. An empty Name is the name MongoDB
+gives to the key, like "username_1".
+*/
+type Index struct {
+	Name   string
+	Key    []string
+	Unique bool
+	Sparse bool
+	// ExpireAfter removes the documents once the time of the field is
+	// older, it only applies to single field indexes
+	ExpireAfter time.Duration
+}
+
+/*
+Indexer declares the indexes a tag can not, like compound indexes:
+
+	func (m *Member) Indexes() []monger.Index {
+		return []monger.Index{
+			{Key: []string{"org_id", "-created_at"}},
+			{Key: []string{"org_id", "email"}, Unique: true},
+		}
+	}
+
+A field is indexed with the monger tag: "index", "unique", "sparse",
+"ttl=24h", "text" and "2dsphere".
+*/
+type Indexer interface {
+	Indexes() []Index
+}
+
+// IndexManager is implemented by the collections of the drivers which can
+// list and build indexes, the EnsureIndexes of a model needs it.
+type IndexManager interface {
+	// Indexes lists the indexes of the collection, the _id index included
+	Indexes(ctx context.Context) ([]Index, error)
+	EnsureIndex(ctx context.Context, index Index) error
+	DropIndex(ctx context.Context, name string) error
+}
+
+// IndexExpiryManager is implemented by the collections of the drivers which
+// change the expiry of a ttl index in place, rebuilding an index only for
+// its ttl uses it.
+type IndexExpiryManager interface {
+	// SetIndexExpiry sets the expireAfterSeconds of the index with collMod
+	SetIndexExpiry(ctx context.Context, name string, expireAfter time.Duration) error
+}
+
+// IndexMode is what a connection does with the indexes of a schema when it
+// is registered, and before the first operation of each tenant.
+type IndexMode int
+
+const (
+	// IndexEnsure builds the missing indexes, the changed ones are logged
+	// and kept
+	IndexEnsure IndexMode = iota
+	// IndexDryRun logs the differences without changing the indexes
+	IndexDryRun
+	// IndexManual leaves the indexes to EnsureIndexes
+	IndexManual
+	// IndexRebuild builds the missing indexes and rebuilds the changed
+	// ones, a ttl is changed in place
+	IndexRebuild
+)
+
+// IndexDiff is the difference between the declared indexes of a schema
+// and the indexes of its collection. Indexes are matched by key, the _id
+// index is never reported.
+type IndexDiff struct {
+	// Collection is the namespace, "database.collection"
+	Collection string
+	Missing    []Index
+	// Extra indexes are not declared by the schema, they are never dropped
+	Extra   []Index
+	Changed []IndexChange
+}
+
+// IndexChange is an index whose key is declared with other options
+type IndexChange struct {
+	From Index
+	To   Index
+}
+
+// expiryOnly tells if only the ttl of a ttl index changed
+func (change IndexChange) expiryOnly() bool {
+	return change.From.ExpireAfter > 0 && change.To.ExpireAfter > 0 &&
+		change.From.Unique == change.To.Unique && change.From.Sparse == change.To.Sparse
+}
+
+// IsEmpty tells if the collection has the declared indexes
+func (diff *IndexDiff) IsEmpty() bool {
+	return len(diff.Missing) == 0 && len(diff.Changed) == 0 && len(diff.Extra) == 0
+}
+
+func (diff *IndexDiff) String() string {
+	lines := []string{diff.Collection + ":"}
+	for _, index := range diff.Missing {
+		lines = append(lines, "  + "+index.String())
+	}
+	for _, change := range diff.Changed {
+		lines = append(lines, "  ~ "+change.From.String()+" -> "+change.To.String())
+	}
+	for _, index := range diff.Extra {
+		lines = append(lines, "  - "+index.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (index Index) String() string {
+	options := make([]string, 0)
+	if index.Unique {
+		options = append(options, "unique")
+	}
+	if index.Sparse {
+		options = append(options, "sparse")
+	}
+	if index.ExpireAfter > 0 {
+		options = append(options, "ttl="+index.ExpireAfter.String())
+	}
+
+	s := index.name() + " (" + strings.Join(index.Key, ", ") + ")"
+	if len(options) > 0 {
+		s += " " + strings.Join(options, ", ")
+	}
+
+	return s
+}
+
+// name returns Name, or the name MongoDB gives to the key
+func (index Index) name() string {
+	if index.Name != "" {
+		return index.Name
+	}
+
+	parts := make([]string, 0, len(index.Key)*2)
+	for _, key := range index.Key {
+		field, kind := splitIndexKey(key)
+		parts = append(parts, field, kind)
+	}
+
+	return strings.Join(parts, "_")
+}
+
+// splitIndexKey returns the field of a key and its kind: 1, -1, text or 2dsphere
+func splitIndexKey(key string) (field string, kind string) {
+	switch {
+	case strings.HasPrefix(key, "$text:"):
+		return key[len("$text:"):], "text"
+	case strings.HasPrefix(key, "$2dsphere:"):
+		return key[len("$2dsphere:"):], "2dsphere"
+	case strings.HasPrefix(key, "-"):
+		return key[1:], "-1"
+	}
+
+	return strings.TrimPrefix(key, "+"), "1"
+}
+
+// keySpec identifies an index by its key, the fields of a text index are
+// not ordered.
+func (index Index) keySpec() string {
+	text := make([]string, 0)
+	keys := make([]string, 0, len(index.Key))
+	for _, key := range index.Key {
+		if field, kind := splitIndexKey(key); kind == "text" {
+			text = append(text, field)
+		} else {
+			keys = append(keys, field+":"+kind)
+		}
+	}
+
+	if len(text) > 0 {
+		sort.Strings(text)
+		keys = append(keys, "$text:"+strings.Join(text, "|"))
+	}
+
+	return strings.Join(keys, ",")
+}
+
+func (index Index) sameOptions(other Index) bool {
+	return index.Unique == other.Unique &&
+		index.Sparse == other.Sparse &&
+		index.ExpireAfter == other.ExpireAfter
+}
+
+// schemaIndexes returns the indexes declared by the tags of the fields
+// and by an Indexer, the text fields make a single text index.
+func schemaIndexes(schemaStruct *SchemaStruct, schema interface{}) ([]Index, error) {
+	indexes := make([]Index, 0)
+	text := Index{}
+	for _, field := range schemaStruct.Fields {
+		if field.IsIgnored {
+			continue
+		}
+
+		column := field.columnName()
+		tags := field.TagMap
+		if _, ok := tags["TEXT"]; ok {
+			text.Key = append(text.Key, "$text:"+column)
+		}
+
+		if _, ok := tags["2DSPHERE"]; ok {
+			indexes = append(indexes, Index{Key: []string{"$2dsphere:" + column}})
+		}
+
+		_, index := tags["INDEX"]
+		_, unique := tags["UNIQUE"]
+		_, sparse := tags["SPARSE"]
+		ttl, expires := tags["TTL"]
+		if !index && !unique && !sparse && !expires {
+			continue
+		}
+
+		idx := Index{Key: []string{column}, Unique: unique, Sparse: sparse}
+		if expires {
+			d, err := time.ParseDuration(ttl)
+			if err != nil || d < time.Second || !isIndexTime(field.Struct.Type) {
+				return nil, &InvalidParamsError{NewError(fmt.Sprintf("[monger] invalid ttl %q of %s", ttl, field.Name))}
+			}
+			idx.ExpireAfter = d
+		}
+		indexes = append(indexes, idx)
+	}
+
+	if len(text.Key) > 0 {
+		indexes = append(indexes, text)
+	}
+
+	if indexer, ok := schema.(Indexer); ok {
+		for _, index := range indexer.Indexes() {
+			if len(index.Key) == 0 {
+				return nil, &InvalidParamsError{NewError(fmt.Sprintf("[monger] index %q of %T has no key", index.Name, schema))}
+			}
+			indexes = append(indexes, index)
+		}
+	}
+
+	return indexes, nil
+}
+
+// diffIndexes compares the declared indexes to the existing ones
+func diffIndexes(namespace string, declared []Index, existing []Index) *IndexDiff {
+	diff := &IndexDiff{Collection: namespace}
+	byKey := make(map[string]Index)
+	for _, index := range existing {
+		if index.Name != "_id_" {
+			byKey[index.keySpec()] = index
+		}
+	}
+
+	for _, index := range declared {
+		found, ok := byKey[index.keySpec()]
+		if !ok {
+			diff.Missing = append(diff.Missing, index)
+			continue
+		}
+
+		delete(byKey, index.keySpec())
+		if !index.sameOptions(found) {
+			diff.Changed = append(diff.Changed, IndexChange{From: found, To: index})
+		}
+	}
+
+	for _, index := range existing {
+		if _, ok := byKey[index.keySpec()]; ok && index.Name != "_id_" {
+			diff.Extra = append(diff.Extra, index)
+		}
+	}
+
+	return diff
+}
+
+// collectionIndexes returns the index manager of a collection
+func collectionIndexes(coll Collection) (IndexManager, error) {
+	manager, ok := coll.(IndexManager)
+	if !ok {
+		return nil, &InvalidParamsError{NewError(fmt.Sprintf("[monger] the collection %T does not manage indexes", coll))}
+	}
+
+	return manager, nil
+}
+
+// diffCollectionIndexes compares the declared indexes to the indexes of coll
+func diffCollectionIndexes(ctx context.Context, coll Collection, namespace string, declared []Index) (*IndexDiff, IndexManager, error) {
+	manager, err := collectionIndexes(coll)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	existing, err := manager.Indexes(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return diffIndexes(namespace, declared, existing), manager, nil
+}
+
+// applyIndexDiff builds the missing indexes, rebuild also drops and builds
+// the changed ones. The ttl of an index is changed in place when the driver
+// can.
+func applyIndexDiff(ctx context.Context, manager IndexManager, diff *IndexDiff, rebuild bool) error {
+	for _, index := range diff.Missing {
+		if err := manager.EnsureIndex(ctx, index); err != nil {
+			return err
+		}
+	}
+
+	if !rebuild {
+		for _, change := range diff.Changed {
+			log.Printf("[monger] index %s of %s is kept, it is declared as %s, see IndexRebuild\n", change.From, diff.Collection, change.To)
+		}
+		return nil
+	}
+
+	for _, change := range diff.Changed {
+		if expiry, ok := manager.(IndexExpiryManager); ok && change.expiryOnly() {
+			if err := expiry.SetIndexExpiry(ctx, change.From.Name, change.To.ExpireAfter); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := manager.DropIndex(ctx, change.From.Name); err != nil {
+			return err
+		}
+		if err := manager.EnsureIndex(ctx, change.To); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// modelIndexes are the declared indexes of a model, done is set once they
+// are ensured for the collection of the schema, the collections of the
+// tenants are set up by the session collection.
+type modelIndexes struct {
+	once    sync.Once
+	indexes []Index
+	err     error
+	mu      sync.Mutex
+	done    bool
+}
+
+// declaredIndexes returns the indexes of the schema, they are parsed once
+func (m *model) declaredIndexes() ([]Index, error) {
+	m.indexes.once.Do(func() {
+		m.indexes.indexes, m.indexes.err = schemaIndexes(m.getSchemaStruct(), m.schema)
+	})
+
+	return m.indexes.indexes, m.indexes.err
+}
+
+// DiffIndexes compares the declared indexes to the indexes of the
+// collection, the tenant of ctx selects the tenant collection.
+func (m *model) DiffIndexes(ctx context.Context) (*IndexDiff, error) {
+	declared, err := m.declaredIndexes()
+	if err != nil {
+		return nil, err
+	}
+
+	var diff *IndexDiff
+	err = m.collection.(*sessionCollection).run(ctx, func(coll Collection, namespace string) error {
+		diff, _, err = diffCollectionIndexes(ctx, coll, namespace, declared)
+		return err
+	})
+
+	return diff, err
+}
+
+// EnsureIndexes builds the missing indexes of the collection and rebuilds
+// the changed ones like IndexRebuild, extra indexes are kept. The tenant of
+// ctx selects the tenant collection.
+func (m *model) EnsureIndexes(ctx context.Context) error {
+	declared, err := m.declaredIndexes()
+	if err != nil {
+		return err
+	}
+
+	return m.collection.(*sessionCollection).run(ctx, func(coll Collection, namespace string) error {
+		return m.ensureIndexes(ctx, coll, namespace, declared, IndexRebuild)
+	})
+}
+
+// ensureIndexes applies mode to the indexes of coll
+func (m *model) ensureIndexes(ctx context.Context, coll Collection, namespace string, declared []Index, mode IndexMode) error {
+	diff, manager, err := diffCollectionIndexes(ctx, coll, namespace, declared)
+	if err != nil {
+		return err
+	}
+
+	if mode == IndexDryRun {
+		if len(diff.Missing) > 0 || len(diff.Changed) > 0 || len(diff.Extra) > 0 {
+			log.Printf("[monger] indexes of %s\n", diff)
+		}
+		return nil
+	}
+
+	return applyIndexDiff(ctx, manager, diff, mode == IndexRebuild)
+}
+
+// setupIndexes is the setup of the tenant collections of the model
+func (m *model) setupIndexes(ctx context.Context, coll Collection, namespace string) error {
+	mode := m.connection.GetConfig().Indexes
+	if mode == IndexManual {
+		return nil
+	}
+
+	declared, err := m.declaredIndexes()
+	if err != nil || len(declared) == 0 {
+		return err
+	}
+
+	return m.ensureIndexes(ctx, coll, namespace, declared, mode)
+}
+
+// autoIndex applies the index mode of the connection when the schema is
+// registered. Without a tenant the collection of the schema is only used
+// by shared schemas and when the tenancy is not strict.
+func (m *model) autoIndex() error {
+	mode := m.connection.GetConfig().Indexes
+	if mode == IndexManual {
+		return nil
+	}
+
+	declared, err := m.declaredIndexes()
+	if err != nil || len(declared) == 0 {
+		return err
+	}
+
+	c := m.collection.(*sessionCollection)
+	if policy := m.connection.GetConfig().Tenancy; policy != nil && policy.Strict && !c.shared {
+		return nil
+	}
+
+	m.indexes.mu.Lock()
+	defer m.indexes.mu.Unlock()
+	if m.indexes.done {
+		return nil
+	}
+
+	ctx := context.Background()
+	err = c.run(ctx, func(coll Collection, namespace string) error {
+		return m.ensureIndexes(ctx, coll, namespace, declared, mode)
+	})
+	m.indexes.done = err == nil
+
+	return err
+}
+
+// DiffIndexes compares the declared indexes of every registered model to
+// the indexes of its collection, sorted by namespace.
+func (conn *connection) DiffIndexes(ctx context.Context) ([]*IndexDiff, error) {
+	diffs := make([]*IndexDiff, 0)
+	for _, mdl := range conn.registry.models() {
+		diff, err := mdl.DiffIndexes(ctx)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, diff)
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Collection < diffs[j].Collection
+	})
+
+	return diffs, nil
+}
+
+// EnsureIndexes ensures the indexes of every registered model
+func (conn *connection) EnsureIndexes(ctx context.Context) error {
+	for _, mdl := range conn.registry.models() {
+		if err := mdl.EnsureIndexes(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isIndexTime tells if t is a time, the only type a ttl index expires
+func isIndexTime(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return t == typeTime
+}
@@ -0,0 +1,102 @@
+package monger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type Place struct {
+	Schema `json:",inline" bson:",inline"`
+
+	Slug     string    `bson:"slug" monger:"unique,sparse"`
+	Title    string    `bson:"title" monger:"text"`
+	Body     string    `bson:"body" monger:"text"`
+	Location []float64 `bson:"location" monger:"2dsphere"`
+	Expires  time.Time `bson:"expires" monger:"ttl=1h"`
+}
+
+func TestSchemaIndexes(t *testing.T) {
+	indexes, err := schemaIndexes(GetSchemaStruct(&Place{}), &Place{})
+	assert.NoError(t, err)
+	assert.Equal(t, []Index{
+		{Key: []string{"slug"}, Unique: true, Sparse: true},
+		{Key: []string{"$2dsphere:location"}},
+		{Key: []string{"expires"}, ExpireAfter: time.Hour},
+		{Key: []string{"$text:title", "$text:body"}},
+	}, indexes)
+	assert.Equal(t, "title_text_body_text", indexes[3].name())
+
+	type Session struct {
+		Schema `json:",inline" bson:",inline"`
+		Token  string `bson:"token" monger:"ttl=1h"`
+	}
+	_, err = schemaIndexes(GetSchemaStruct(&Session{}), &Session{})
+	assert.IsType(t, &InvalidParamsError{}, err)
+}
+
+func TestDiffIndexes(t *testing.T) {
+	diff := diffIndexes("test.place", []Index{
+		{Key: []string{"$text:title", "$text:body"}},
+		{Key: []string{"slug"}, Unique: true},
+		{Key: []string{"expires"}, ExpireAfter: time.Hour},
+	}, []Index{
+		{Name: "_id_", Key: []string{"_id"}, Unique: true},
+		{Name: "text", Key: []string{"$text:body", "$text:title"}},
+		{Name: "slug_1", Key: []string{"slug"}},
+		{Name: "owner_1", Key: []string{"owner"}},
+	})
+
+	assert.Equal(t, []Index{{Key: []string{"expires"}, ExpireAfter: time.Hour}}, diff.Missing)
+	assert.Equal(t, []Index{{Name: "owner_1", Key: []string{"owner"}}}, diff.Extra)
+	assert.Equal(t, []IndexChange{{
+		From: Index{Name: "slug_1", Key: []string{"slug"}},
+		To:   Index{Key: []string{"slug"}, Unique: true},
+	}}, diff.Changed)
+}
+
+// recordedIndexes records the calls of applyIndexDiff
+type recordedIndexes struct {
+	calls []string
+}
+
+func (r *recordedIndexes) Indexes(ctx context.Context) ([]Index, error) {
+	return nil, nil
+}
+
+func (r *recordedIndexes) EnsureIndex(ctx context.Context, index Index) error {
+	r.calls = append(r.calls, "ensure "+index.name())
+	return nil
+}
+
+func (r *recordedIndexes) DropIndex(ctx context.Context, name string) error {
+	r.calls = append(r.calls, "drop "+name)
+	return nil
+}
+
+func (r *recordedIndexes) SetIndexExpiry(ctx context.Context, name string, expireAfter time.Duration) error {
+	r.calls = append(r.calls, "expire "+name+" "+expireAfter.String())
+	return nil
+}
+
+func TestApplyIndexDiff(t *testing.T) {
+	diff := &IndexDiff{
+		Collection: "test.place",
+		Missing:    []Index{{Key: []string{"title"}}},
+		Changed: []IndexChange{
+			{From: Index{Name: "slug_1", Key: []string{"slug"}, Unique: true}, To: Index{Key: []string{"slug"}}},
+			{From: Index{Name: "expires_1", Key: []string{"expires"}, ExpireAfter: time.Hour}, To: Index{Key: []string{"expires"}, ExpireAfter: 2 * time.Hour}},
+		},
+	}
+
+	// the changed indexes are kept by default
+	manager := &recordedIndexes{}
+	assert.NoError(t, applyIndexDiff(context.Background(), manager, diff, false))
+	assert.Equal(t, []string{"ensure title_1"}, manager.calls)
+
+	manager = &recordedIndexes{}
+	assert.NoError(t, applyIndexDiff(context.Background(), manager, diff, true))
+	assert.Equal(t, []string{"ensure title_1", "drop slug_1", "ensure slug_1", "expire expires_1 2h0m0s"}, manager.calls)
+}
@@ -0,0 +1,406 @@
+package monger
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Validation levels and actions of a collection validator
+const (
+	ValidationLevelStrict   = "strict"
+	ValidationLevelModerate = "moderate"
+	ValidationActionError   = "error"
+	ValidationActionWarn    = "warn"
+)
+
+// ValidationPolicy is how the server applies the $jsonSchema validators of
+// the schemas, zero fields use the defaults.
+type ValidationPolicy struct {
+	// Level is ValidationLevelStrict, the default, or
+	// ValidationLevelModerate which skips the updates of the documents that
+	// are already invalid
+	Level string
+	// Action is ValidationActionError, the default, or ValidationActionWarn
+	// which only logs the invalid writes on the server
+	Action string
+}
+
+// Validator is the validator of a collection
+type Validator struct {
+	Schema bson.M
+	Level  string
+	Action string
+}
+
+// ValidatorManager is implemented by the collections of the drivers which
+// set the validator of a collection.
+type ValidatorManager interface {
+	// SetValidator replaces the validator of the collection with collMod, it
+	// creates the collection when it does not exist
+	SetValidator(ctx context.Context, validator Validator) error
+}
+
+var (
+	typeBinary128 = reflect.TypeOf(bson.Decimal128{})
+	typeRaw       = reflect.TypeOf(bson.Raw{})
+	typeBytes     = reflect.TypeOf([]byte(nil))
+)
+
+/*
+JSONSchema returns the $jsonSchema document of the columns of a schema,
+with their BSON types, the required rules, the enum, min, max and pattern
+rules and the fields of the embedded structs:
+
+	type Member struct {
+		monger.Schema `json:",inline" bson:",inline"`
+		Email         string `bson:"email" monger:"required,pattern=^.+@.+$"`
+		Role          string `bson:"role" monger:"enum=admin|member"`
+	}
+
+The rules only apply to the values a Go service would validate, the zero
+value of a field which is not required is accepted. Custom validators and
+encrypted fields only get their BSON type.
+*/
+func (s *SchemaStruct) JSONSchema() bson.M {
+	return s.objectSchema(map[reflect.Type]bool{})
+}
+
+func (s *SchemaStruct) objectSchema(visited map[reflect.Type]bool) bson.M {
+	visited[s.Type] = true
+	defer delete(visited, s.Type)
+
+	properties := bson.M{}
+	required := make([]string, 0)
+	for _, field := range s.Fields {
+		if field.Relationship != nil && field.Relationship.Kind != Default {
+			continue
+		}
+
+		column := field.columnName()
+		if column == "-" {
+			continue
+		}
+
+		property := fieldSchema(field, visited)
+		properties[column] = property
+
+		for _, rule := range field.Rules {
+			if rule.Name == "required" {
+				required = append(required, column)
+			}
+		}
+	}
+
+	schema := bson.M{"bsonType": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	return schema
+}
+
+// fieldSchema is the schema of a column, the rules of a field which is not
+// required also accept its zero value.
+func fieldSchema(field *SchemaField, visited map[reflect.Type]bool) bson.M {
+	t := field.Struct.Type
+	if field.ColumnName == "_id" && t == typeObjectId {
+		return bson.M{"bsonType": "objectId"}
+	}
+
+	if encrypted, _ := field.isEncrypted(); encrypted {
+		return bson.M{"bsonType": "string"}
+	}
+
+	schema := typeSchema(t, visited)
+	constraints := bson.M{}
+	isRequired := false
+	for _, rule := range field.Rules {
+		if rule.err != nil {
+			continue
+		}
+
+		switch rule.Name {
+		case "required":
+			isRequired = true
+			if kindOf(t) == reflect.String {
+				constraints["minLength"] = int64(1)
+			}
+		case "min", "max":
+			limit, _ := strconv.ParseFloat(rule.Param, 64)
+			if key := limitKey(t, rule.Name); key != "" {
+				constraints[key] = limitValue(key, limit)
+			}
+		case "pattern":
+			if kindOf(t) == reflect.String {
+				constraints["pattern"] = rule.Param
+			}
+		case "enum":
+			constraints["enum"] = enumValues(t, rule.Param)
+		}
+	}
+
+	if len(constraints) == 0 {
+		return schema
+	}
+
+	if isRequired {
+		for k, v := range constraints {
+			schema[k] = v
+		}
+		return schema
+	}
+
+	schema["anyOf"] = append(zeroSchemas(t), constraints)
+
+	return schema
+}
+
+// zeroSchemas are the schemas of the stored zero values of a type
+func zeroSchemas(t reflect.Type) []bson.M {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return []bson.M{{"bsonType": "null"}}
+	case reflect.Slice:
+		return []bson.M{{"bsonType": "null"}, {"bsonType": "array", "maxItems": 0}}
+	case reflect.Map:
+		return []bson.M{{"bsonType": "null"}, {"bsonType": "object", "maxProperties": 0}}
+	}
+
+	return []bson.M{{"enum": []interface{}{reflect.Zero(t).Interface()}}}
+}
+
+// typeSchema is the schema of the BSON type of a Go type, a pointer also
+// accepts null.
+func typeSchema(t reflect.Type, visited map[reflect.Type]bool) bson.M {
+	if t.Kind() == reflect.Ptr {
+		schema := typeSchema(t.Elem(), visited)
+		if bsonType, ok := schema["bsonType"].(string); ok {
+			schema["bsonType"] = []string{bsonType, "null"}
+		}
+		return schema
+	}
+
+	switch t {
+	case typeTime:
+		return bson.M{"bsonType": "date"}
+	case typeObjectId:
+		return bson.M{"bsonType": "objectId"}
+	case typeBinary, typeBytes, reflect.TypeOf(UUID{}):
+		return bson.M{"bsonType": "binData"}
+	case typeBinary128:
+		return bson.M{"bsonType": "decimal"}
+	case typeRaw:
+		return bson.M{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return bson.M{"bsonType": "string"}
+	case reflect.Bool:
+		return bson.M{"bsonType": "bool"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return bson.M{"bsonType": "int"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return bson.M{"bsonType": []string{"int", "long"}}
+	case reflect.Float32, reflect.Float64:
+		return bson.M{"bsonType": "double"}
+	case reflect.Slice, reflect.Array:
+		schema := bson.M{"bsonType": []string{"array", "null"}}
+		if t.Kind() == reflect.Array {
+			schema["bsonType"] = "array"
+		}
+		if items := typeSchema(t.Elem(), visited); len(items) > 0 {
+			schema["items"] = items
+		}
+		return schema
+	case reflect.Map:
+		return bson.M{"bsonType": []string{"object", "null"}}
+	case reflect.Struct:
+		if visited[t] {
+			return bson.M{"bsonType": "object"}
+		}
+		return GetSchemaStruct(t).objectSchema(visited)
+	}
+
+	return bson.M{}
+}
+
+func kindOf(t reflect.Type) reflect.Kind {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return t.Kind()
+}
+
+// limitKey is the keyword of a min or max rule, by the type measured
+func limitKey(t reflect.Type, rule string) string {
+	keys := map[reflect.Kind][2]string{
+		reflect.String: {"minLength", "maxLength"},
+		reflect.Slice:  {"minItems", "maxItems"},
+		reflect.Array:  {"minItems", "maxItems"},
+		reflect.Map:    {"minProperties", "maxProperties"},
+	}
+
+	kind := kindOf(t)
+	pair, ok := keys[kind]
+	if !ok {
+		if kind >= reflect.Int && kind <= reflect.Float64 {
+			pair = [2]string{"minimum", "maximum"}
+		} else {
+			return ""
+		}
+	}
+
+	if rule == "min" {
+		return pair[0]
+	}
+
+	return pair[1]
+}
+
+func limitValue(key string, limit float64) interface{} {
+	if key == "minimum" || key == "maximum" {
+		return limit
+	}
+
+	return int64(limit)
+}
+
+// enumValues converts the options of an enum rule to the type of the field
+func enumValues(t reflect.Type, param string) []interface{} {
+	values := make([]interface{}, 0)
+	for _, option := range strings.Split(param, "|") {
+		switch kind := kindOf(t); {
+		case kind >= reflect.Int && kind <= reflect.Uint64:
+			if n, err := strconv.ParseInt(option, 10, 64); err == nil {
+				values = append(values, n)
+				continue
+			}
+		case kind == reflect.Float32 || kind == reflect.Float64:
+			if n, err := strconv.ParseFloat(option, 64); err == nil {
+				values = append(values, n)
+				continue
+			}
+		}
+		values = append(values, option)
+	}
+
+	return values
+}
+
+// collectionValidators returns the validator manager of a collection
+func collectionValidators(coll Collection) (ValidatorManager, error) {
+	manager, ok := coll.(ValidatorManager)
+	if !ok {
+		return nil, &InvalidParamsError{NewError(fmt.Sprintf("[monger] the collection %T does not manage validators", coll))}
+	}
+
+	return manager, nil
+}
+
+// validator returns the validator of the schema under the policy
+func (m *model) validator(policy ValidationPolicy) Validator {
+	validator := Validator{
+		Schema: bson.M{"$jsonSchema": m.jsonSchema()},
+		Level:  policy.Level,
+		Action: policy.Action,
+	}
+	if validator.Level == "" {
+		validator.Level = ValidationLevelStrict
+	}
+	if validator.Action == "" {
+		validator.Action = ValidationActionError
+	}
+
+	return validator
+}
+
+// EnsureValidator sets the $jsonSchema of the schema as the validator of
+// the collection, with the policy of the connection or the default one.
+// The tenant of ctx selects the tenant collection.
+func (m *model) EnsureValidator(ctx context.Context) error {
+	policy := ValidationPolicy{}
+	if p := m.connection.GetConfig().Validation; p != nil {
+		policy = *p
+	}
+
+	return m.collection.(*sessionCollection).run(ctx, func(coll Collection, namespace string) error {
+		return m.ensureValidator(ctx, coll, policy)
+	})
+}
+
+func (m *model) ensureValidator(ctx context.Context, coll Collection, policy ValidationPolicy) error {
+	if m.kind != "" {
+		// the validator of the base schema covers its collection
+		return nil
+	}
+
+	manager, err := collectionValidators(coll)
+	if err != nil {
+		return err
+	}
+
+	return manager.SetValidator(ctx, m.validator(policy))
+}
+
+// setupCollection is the setup of the tenant collections of the model, it
+// builds the indexes then sets the validator.
+func (m *model) setupCollection(ctx context.Context, coll Collection, namespace string) error {
+	if err := m.setupIndexes(ctx, coll, namespace); err != nil {
+		return err
+	}
+
+	policy := m.connection.GetConfig().Validation
+	if policy == nil {
+		return nil
+	}
+
+	return m.ensureValidator(ctx, coll, *policy)
+}
+
+// modelValidation tells if the validator of the model is set for the
+// collection of the schema, the collections of the tenants are set up by
+// the session collection.
+type modelValidation struct {
+	mu   sync.Mutex
+	done bool
+}
+
+// autoValidator sets the validator of the collection when the schema is
+// registered on a connection with a validation policy, like autoIndex.
+func (m *model) autoValidator() error {
+	policy := m.connection.GetConfig().Validation
+	if policy == nil {
+		return nil
+	}
+
+	c := m.collection.(*sessionCollection)
+	if tenancy := m.connection.GetConfig().Tenancy; tenancy != nil && tenancy.Strict && !c.shared {
+		return nil
+	}
+
+	m.validation.mu.Lock()
+	defer m.validation.mu.Unlock()
+	if m.validation.done {
+		return nil
+	}
+
+	ctx := context.Background()
+	err := c.run(ctx, func(coll Collection, namespace string) error {
+		return m.ensureValidator(ctx, coll, *policy)
+	})
+	if err != nil {
+		log.Printf("[monger] can not set the validator of %s: %v\n", m.collectionName, err)
+	}
+	m.validation.done = err == nil
+
+	return err
+}
@@ -0,0 +1,93 @@
+package monger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type ShippingAddress struct {
+	City string `json:"city" bson:"city" monger:"required"`
+	Zip  string `json:"zip" bson:"zip" monger:"pattern=^[0-9]{5}$"`
+}
+
+type Shipment struct {
+	Schema    `json:",inline" bson:",inline"`
+	Weight    float64           `json:"weight" bson:"weight" monger:"required,max=30"`
+	Priority  int32             `json:"priority" bson:"priority" monger:"enum=1|2|3"`
+	To        ShippingAddress   `json:"to" bson:"to"`
+	Stops     []ShippingAddress `json:"stops,omitempty" bson:"stops,omitempty"`
+	Delivered *time.Time        `json:"delivered,omitempty" bson:"delivered,omitempty"`
+	Secret    string            `json:"secret" bson:"secret" monger:"encrypt,required"`
+	Label     string            `json:"label" bson:"-" monger:"required"`
+}
+
+func TestJSONSchema(t *testing.T) {
+	schema := GetSchemaStruct(new(Account)).JSONSchema()
+	assert.Equal(t, "object", schema["bsonType"])
+	assert.Equal(t, []string{"username"}, schema["required"])
+
+	properties := schema["properties"].(bson.M)
+	assert.Equal(t, bson.M{"bsonType": "objectId"}, properties["_id"])
+	assert.Equal(t, bson.M{"bsonType": "date"}, properties["created_at"])
+	assert.Equal(t, bson.M{"bsonType": []string{"date", "null"}}, properties["deleted_at"])
+	assert.Equal(t, bson.M{
+		"bsonType":  "string",
+		"minLength": int64(3),
+		"pattern":   "^[a-z0-9_]+$",
+		"maxLength": int64(16),
+	}, properties["username"])
+
+	// the zero value of a field which is not required is accepted
+	assert.Equal(t, bson.M{
+		"bsonType": "string",
+		"anyOf":    []bson.M{{"enum": []interface{}{""}}, {"enum": []interface{}{"active", "disabled"}}},
+	}, properties["status"])
+	assert.Equal(t, bson.M{
+		"bsonType": []string{"int", "long"},
+		"anyOf":    []bson.M{{"enum": []interface{}{0}}, {"minimum": float64(18)}},
+	}, properties["age"])
+	assert.Equal(t, bson.M{
+		"bsonType": []string{"array", "null"},
+		"items":    bson.M{"bsonType": "string"},
+		"anyOf": []bson.M{
+			{"bsonType": "null"},
+			{"bsonType": "array", "maxItems": 0},
+			{"maxItems": int64(2)},
+		},
+	}, properties["tags"])
+	// custom validators only get the type
+	assert.Equal(t, bson.M{"bsonType": "string"}, properties["code"])
+}
+
+func TestJSONSchemaNested(t *testing.T) {
+	schema := GetSchemaStruct(new(Shipment)).JSONSchema()
+	assert.Equal(t, []string{"weight", "secret"}, schema["required"])
+
+	properties := schema["properties"].(bson.M)
+	assert.Equal(t, bson.M{"bsonType": "double", "maximum": float64(30)}, properties["weight"])
+	assert.Equal(t, bson.M{
+		"bsonType": "int",
+		"anyOf":    []bson.M{{"enum": []interface{}{int32(0)}}, {"enum": []interface{}{int64(1), int64(2), int64(3)}}},
+	}, properties["priority"])
+	assert.Equal(t, bson.M{"bsonType": "string"}, properties["secret"])
+	// a field which is not stored has no property
+	assert.NotContains(t, properties, "-")
+
+	address := bson.M{
+		"bsonType": "object",
+		"required": []string{"city"},
+		"properties": bson.M{
+			"city": bson.M{"bsonType": "string", "minLength": int64(1)},
+			"zip": bson.M{
+				"bsonType": "string",
+				"anyOf":    []bson.M{{"enum": []interface{}{""}}, {"pattern": "^[0-9]{5}$"}},
+			},
+		},
+	}
+	assert.Equal(t, address, properties["to"])
+	assert.Equal(t, bson.M{"bsonType": []string{"array", "null"}, "items": address}, properties["stops"])
+	assert.Equal(t, bson.M{"bsonType": []string{"date", "null"}}, properties["delivered"])
+}
@@ -0,0 +1,222 @@
+package monger
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// SchemaProblem is a mistake in the declaration of a schema, Field is the
+// name of the struct field
+type SchemaProblem struct {
+	Schema  string
+	Field   string
+	Message string
+}
+
+func (p *SchemaProblem) Error() string {
+	if p.Field == "" {
+		return fmt.Sprintf("%s: %s", p.Schema, p.Message)
+	}
+
+	return fmt.Sprintf("%s.%s: %s", p.Schema, p.Field, p.Message)
+}
+
+// mongerTagKeys are the keys of the monger tag, the registered validators
+// are also known.
+var mongerTagKeys = map[string]bool{
+	"-": true, "REQUIRED": true, "MIN": true, "MAX": true, "PATTERN": true, "ENUM": true,
+	"DEFAULT": true, "ID": true, "VERSION": true, "SEQUENCE": true, "ENCRYPT": true,
+	"DISCRIMINATOR": true, "INDEX": true, "UNIQUE": true, "SPARSE": true, "TTL": true,
+	"TEXT": true, "2DSPHERE": true, "HASONE": true, "HASMANY": true, "BELONGTO": true,
+	"FOREIGNKEY": true, "LOCALFIELD": true, "FOREIGNFIELD": true, "INLINE": true, "OMITEMPTY": true,
+}
+
+/*
+LintSchema returns the problems of the declaration of a schema that monger
+would silently ignore: the unknown keys of the monger tag, the relations
+without keys or to a type which is not a schema, and the fields sharing a
+column:
+
+	for _, problem := range monger.LintSchema(new(Member)) {
+		t.Error(problem)
+	}
+
+Registering a schema also reports the relations to the schemas which are
+not registered, see StrictSchemas.
+*/
+func LintSchema(schema interface{}) []*SchemaProblem {
+	return lintSchema(schema, nil)
+}
+
+// lintSchema lints a schema, registered tells if the schema of a relation
+// is registered, nil skips the check.
+func lintSchema(schema interface{}, registered func(t reflect.Type) bool) []*SchemaProblem {
+	schemaStruct := GetSchemaStruct(schema)
+	if schemaStruct.Type == nil {
+		return nil
+	}
+
+	problems := make([]*SchemaProblem, 0)
+	report := func(field *SchemaField, format string, args ...interface{}) {
+		problem := &SchemaProblem{Schema: schemaStruct.Type.Name(), Message: fmt.Sprintf(format, args...)}
+		if field != nil {
+			problem.Field = field.Name
+		}
+		problems = append(problems, problem)
+	}
+
+	columns := make(map[string]*SchemaField)
+	for _, field := range schemaStruct.Fields {
+		for _, key := range unknownTagKeys(field) {
+			if strings.EqualFold(key, "column") {
+				report(field, "the monger tag key %q is ignored, the column is the one of the bson tag", key)
+			} else {
+				report(field, "unknown monger tag key %q", key)
+			}
+		}
+
+		if field.IsIgnored || field.columnName() == "-" {
+			continue
+		}
+
+		if other, ok := columns[field.columnName()]; ok {
+			report(field, "the column %s is also the column of %s", field.columnName(), other.Name)
+		} else {
+			columns[field.columnName()] = field
+		}
+
+		lintRelation(field, registered, report)
+	}
+
+	return problems
+}
+
+// unknownTagKeys returns the keys of the monger tag of a field which are
+// neither monger keys nor registered validators
+func unknownTagKeys(field *SchemaField) []string {
+	keys := make([]string, 0)
+	for _, option := range mongerTagOptions(field.Tag.Get("monger")) {
+		key := strings.TrimSpace(strings.SplitN(option, "=", 2)[0])
+		if key == "" {
+			continue
+		}
+
+		upper := strings.ToUpper(key)
+		if _, ok := getValidator(upper); mongerTagKeys[upper] || ok {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// lintRelation reports the relation tags GetSchemaStruct can not use
+func lintRelation(field *SchemaField, registered func(t reflect.Type) bool, report func(*SchemaField, string, ...interface{})) {
+	kinds := make([]string, 0)
+	for _, kind := range []string{"HASONE", "HASMANY", "BELONGTO"} {
+		if _, ok := field.TagMap[kind]; ok {
+			kinds = append(kinds, relationTagName(kind))
+		}
+	}
+
+	switch len(kinds) {
+	case 0:
+		return
+	case 1:
+	default:
+		report(field, "conflicting relation tags %s", strings.Join(kinds, ", "))
+		return
+	}
+
+	kind := kinds[0]
+	t := field.Struct.Type
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	isSlice := t.Kind() == reflect.Slice
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+
+	switch {
+	case t.Kind() != reflect.Struct || !isImplementsSchemer(t):
+		report(field, "%s relation to %v which is not a schema", kind, t)
+		return
+	case kind == "hasMany" && !isSlice:
+		report(field, "hasMany relation needs a slice of %v", t.Name())
+		return
+	case kind != "hasMany" && isSlice:
+		report(field, "%s relation needs a single %v, use hasMany for a slice", kind, t.Name())
+		return
+	}
+
+	if rs := field.Relationship; rs == nil || rs.LocalFieldKey == "" || rs.ForeignFieldKey == "" {
+		report(field, "%s relation to %v without foreignKey, or localField and foreignField", kind, t.Name())
+	}
+
+	if registered != nil && !registered(t) {
+		report(field, "%s relation to %v which is not registered", kind, t.Name())
+	}
+}
+
+func relationTagName(key string) string {
+	switch key {
+	case "HASONE":
+		return "hasOne"
+	case "HASMANY":
+		return "hasMany"
+	}
+
+	return "belongTo"
+}
+
+// lint lints the schemas before they are registered, the relations between
+// them are fine. The problems fail the registration with StrictSchemas,
+// they are logged otherwise.
+func (conn *connection) lint(docs ...Schemer) error {
+	pending := make(map[reflect.Type]bool)
+	for _, doc := range docs {
+		pending[schemaType(reflect.TypeOf(doc))] = true
+	}
+
+	registered := func(t reflect.Type) bool {
+		if pending[t] {
+			return true
+		}
+
+		_, err := conn.Lookup(t)
+		return err == nil
+	}
+
+	problems := make([]*SchemaProblem, 0)
+	for _, doc := range docs {
+		problems = append(problems, lintSchema(doc, registered)...)
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	if conn.Config.StrictSchemas {
+		messages := make([]string, 0, len(problems))
+		for _, problem := range problems {
+			messages = append(messages, problem.Error())
+		}
+
+		return &SchemaLintError{
+			MongerQueryError: NewError("[monger] invalid schemas: " + strings.Join(messages, "; ")),
+			Problems:         problems,
+		}
+	}
+
+	for _, problem := range problems {
+		log.Printf("[monger] schema %v\n", problem)
+	}
+
+	return nil
+}
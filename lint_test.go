@@ -0,0 +1,76 @@
+package monger
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type Badge struct {
+	Schema `json:",inline" bson:",inline"`
+	Name   string `json:"name" bson:"name"`
+}
+
+type Coordinates struct {
+	Lat float64 `json:"lat" bson:"lat"`
+}
+
+type Gamer struct {
+	Schema   `json:",inline" bson:",inline"`
+	Nickname string       `json:"nickname" bson:"nickname" monger:"required,uniqe"`
+	Handle   string       `json:"handle" bson:"nickname" monger:"column=handle"`
+	Badge    *Badge       `json:"badge" bson:"badge" monger:"hasOne"`
+	Badges   []*Badge     `json:"badges" bson:"badges" monger:"hasMany,foreignKey=gamer_id"`
+	Home     *Coordinates `json:"home" bson:"home" monger:"belongTo,foreignKey=home_id"`
+	Friend   []*Badge     `json:"friend" bson:"friend" monger:"hasOne,foreignKey=gamer_id"`
+	Rank     *Badge       `json:"rank" bson:"rank" monger:"hasOne,belongTo,foreignKey=gamer_id"`
+}
+
+func problemsOf(problems []*SchemaProblem) []string {
+	messages := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		messages = append(messages, problem.Error())
+	}
+
+	return messages
+}
+
+func TestLintSchema(t *testing.T) {
+	assert.Empty(t, LintSchema(new(Badge)))
+	assert.Empty(t, LintSchema(new(Account)))
+
+	assert.Equal(t, []string{
+		`Gamer.Nickname: unknown monger tag key "uniqe"`,
+		`Gamer.Handle: the monger tag key "column" is ignored, the column is the one of the bson tag`,
+		`Gamer.Handle: the column nickname is also the column of Nickname`,
+		`Gamer.Badge: hasOne relation to Badge without foreignKey, or localField and foreignField`,
+		`Gamer.Home: belongTo relation to monger.Coordinates which is not a schema`,
+		`Gamer.Friend: hasOne relation needs a single Badge, use hasMany for a slice`,
+		`Gamer.Rank: conflicting relation tags hasOne, belongTo`,
+	}, problemsOf(LintSchema(new(Gamer))))
+
+	// the registered validators are known keys
+	RegisterValidator("uniqe", func(value interface{}, param string) error { return nil })
+	defer func() {
+		validators.Lock()
+		delete(validators.byName, "UNIQE")
+		validators.Unlock()
+	}()
+	assert.NotContains(t, problemsOf(LintSchema(new(Gamer))), `Gamer.Nickname: unknown monger tag key "uniqe"`)
+}
+
+func TestLintSchemaRegistered(t *testing.T) {
+	type Club struct {
+		Schema `json:",inline" bson:",inline"`
+		Badges []*Badge `json:"badges" bson:"badges" monger:"hasMany,foreignKey=club_id"`
+	}
+
+	registered := func(t reflect.Type) bool { return false }
+	assert.Equal(t, []string{
+		"Club.Badges: hasMany relation to Badge which is not registered",
+	}, problemsOf(lintSchema(new(Club), registered)))
+
+	registered = func(t reflect.Type) bool { return t == reflect.TypeOf(Badge{}) }
+	assert.Empty(t, lintSchema(new(Club), registered))
+}
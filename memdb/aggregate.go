@@ -0,0 +1,650 @@
+package memdb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// aggregate runs pipeline over docs, the server read lock must be held
+// since $lookup reads the other collections of db.
+func (s *server) aggregate(db string, docs []bson.M, pipeline []interface{}, vars bson.M) ([]bson.M, error) {
+	for _, item := range pipeline {
+		stage, ok := asDoc(item)
+		if !ok || len(stage) != 1 {
+			return nil, fmt.Errorf("memdb: a pipeline stage must be a document with a single field")
+		}
+
+		for name, arg := range stage {
+			var err error
+			switch name {
+			case "$match":
+				docs, err = stageMatch(docs, arg, vars)
+			case "$lookup":
+				docs, err = s.stageLookup(db, docs, arg, vars)
+			case "$unwind":
+				docs, err = stageUnwind(docs, arg)
+			case "$project":
+				docs, err = stageProject(docs, arg, vars)
+			case "$addFields", "$set":
+				docs, err = stageAddFields(docs, arg, vars)
+			case "$sort":
+				err = sortDocs(docs, arg)
+			case "$skip":
+				n, _ := toFloat(arg)
+				if int(n) >= len(docs) {
+					docs = docs[:0]
+				} else if n > 0 {
+					docs = docs[int(n):]
+				}
+			case "$limit":
+				n, _ := toFloat(arg)
+				if n > 0 && int(n) < len(docs) {
+					docs = docs[:int(n)]
+				}
+			case "$group":
+				docs, err = stageGroup(docs, arg, vars)
+			case "$count":
+				field, _ := arg.(string)
+				if len(docs) == 0 {
+					docs = []bson.M{}
+				} else {
+					docs = []bson.M{{field: len(docs)}}
+				}
+			default:
+				err = fmt.Errorf("memdb: unsupported pipeline stage %s", name)
+			}
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return docs, nil
+}
+
+func stageMatch(docs []bson.M, arg interface{}, vars bson.M) ([]bson.M, error) {
+	filter, ok := asDoc(arg)
+	if !ok {
+		return nil, fmt.Errorf("memdb: $match needs a document")
+	}
+
+	matched := make([]bson.M, 0, len(docs))
+	for _, doc := range docs {
+		ok, err := match(doc, filter, vars)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, doc)
+		}
+	}
+
+	return matched, nil
+}
+
+func (s *server) stageLookup(db string, docs []bson.M, arg interface{}, vars bson.M) ([]bson.M, error) {
+	spec, ok := asDoc(arg)
+	if !ok {
+		return nil, fmt.Errorf("memdb: $lookup needs a document")
+	}
+
+	from, _ := spec["from"].(string)
+	as, _ := spec["as"].(string)
+	if from == "" || as == "" {
+		return nil, fmt.Errorf("memdb: $lookup needs from and as")
+	}
+
+	foreign := s.collections[db+"."+from]
+
+	for _, doc := range docs {
+		joined := make([]interface{}, 0)
+
+		if pipeline, ok := spec["pipeline"].([]interface{}); ok {
+			subVars := bson.M{}
+			for k, v := range vars {
+				subVars[k] = v
+			}
+
+			if let, ok := asDoc(spec["let"]); ok {
+				for name, expr := range let {
+					v, err := eval(expr, doc, vars)
+					if err != nil {
+						return nil, err
+					}
+					subVars[name] = v
+				}
+			}
+
+			results, err := s.aggregate(db, copyDocs(foreign), pipeline, subVars)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, result := range results {
+				joined = append(joined, result)
+			}
+		} else {
+			localField, _ := spec["localField"].(string)
+			foreignField, _ := spec["foreignField"].(string)
+			locals := expand(lookup(doc, splitPath(localField)))
+			if len(locals) == 0 {
+				locals = []interface{}{nil}
+			}
+
+			for _, other := range foreign {
+				for _, local := range locals {
+					if ok, _ := matchEqual(lookup(other, splitPath(foreignField)), local); ok {
+						joined = append(joined, copyDoc(other))
+						break
+					}
+				}
+			}
+		}
+
+		setPath(doc, splitPath(as), joined)
+	}
+
+	return docs, nil
+}
+
+func stageUnwind(docs []bson.M, arg interface{}) ([]bson.M, error) {
+	var (
+		path     string
+		preserve bool
+		index    string
+	)
+
+	switch spec := arg.(type) {
+	case string:
+		path = spec
+	default:
+		opts, ok := asDoc(spec)
+		if !ok {
+			return nil, fmt.Errorf("memdb: $unwind needs a path")
+		}
+		path, _ = opts["path"].(string)
+		preserve = truthy(opts["preserveNullAndEmptyArrays"])
+		index, _ = opts["includeArrayIndex"].(string)
+	}
+
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("memdb: $unwind path must start with $")
+	}
+	keys := splitPath(path[1:])
+
+	unwound := make([]bson.M, 0, len(docs))
+	for _, doc := range docs {
+		value, found := getPath(doc, keys)
+		items, isArray := value.([]interface{})
+
+		switch {
+		case isArray && len(items) > 0:
+			for i, item := range items {
+				d := copyDoc(doc)
+				setPath(d, keys, copyValue(item))
+				if index != "" {
+					d[index] = int64(i)
+				}
+				unwound = append(unwound, d)
+			}
+		case isArray || !found || value == nil:
+			if preserve {
+				if isArray {
+					unsetPath(doc, keys)
+				}
+				if index != "" {
+					doc[index] = nil
+				}
+				unwound = append(unwound, doc)
+			}
+		default:
+			if index != "" {
+				doc[index] = nil
+			}
+			unwound = append(unwound, doc)
+		}
+	}
+
+	return unwound, nil
+}
+
+func stageProject(docs []bson.M, arg interface{}, vars bson.M) ([]bson.M, error) {
+	spec, ok := asDoc(arg)
+	if !ok {
+		return nil, fmt.Errorf("memdb: $project needs a document")
+	}
+
+	projected := make([]bson.M, 0, len(docs))
+	for _, doc := range docs {
+		d, err := project(doc, spec, vars)
+		if err != nil {
+			return nil, err
+		}
+		projected = append(projected, d)
+	}
+
+	return projected, nil
+}
+
+// project applies an inclusion or exclusion projection, the fields of an
+// inclusion projection may also be expressions.
+func project(doc bson.M, spec bson.M, vars bson.M) (bson.M, error) {
+	exclusion := true
+	for k, v := range spec {
+		if k == "_id" {
+			continue
+		}
+		if n, ok := toFloat(v); !(ok && n == 0) && v != false {
+			exclusion = false
+		}
+	}
+
+	if exclusion {
+		d := copyDoc(doc)
+		for k := range spec {
+			unsetPath(d, splitPath(k))
+		}
+		return d, nil
+	}
+
+	d := bson.M{}
+	if id, ok := doc["_id"]; ok {
+		d["_id"] = id
+	}
+
+	for k, v := range spec {
+		keys := splitPath(k)
+		n, isNumber := toFloat(v)
+		switch {
+		case (isNumber && n == 0) || v == false:
+			unsetPath(d, keys)
+		case (isNumber && n != 0) || v == true:
+			if value, ok := getPath(doc, keys); ok {
+				setPath(d, keys, copyValue(value))
+			}
+		default:
+			value, err := eval(v, doc, vars)
+			if err != nil {
+				return nil, err
+			}
+			setPath(d, keys, value)
+		}
+	}
+
+	return d, nil
+}
+
+func stageAddFields(docs []bson.M, arg interface{}, vars bson.M) ([]bson.M, error) {
+	spec, ok := asDoc(arg)
+	if !ok {
+		return nil, fmt.Errorf("memdb: $addFields needs a document")
+	}
+
+	for _, doc := range docs {
+		values := bson.M{}
+		for k, expr := range spec {
+			v, err := eval(expr, doc, vars)
+			if err != nil {
+				return nil, err
+			}
+			values[k] = v
+		}
+
+		for k, v := range values {
+			setPath(doc, splitPath(k), v)
+		}
+	}
+
+	return docs, nil
+}
+
+func stageGroup(docs []bson.M, arg interface{}, vars bson.M) ([]bson.M, error) {
+	spec, ok := asDoc(arg)
+	if !ok {
+		return nil, fmt.Errorf("memdb: $group needs a document")
+	}
+
+	idExpr, ok := spec["_id"]
+	if !ok {
+		return nil, fmt.Errorf("memdb: $group needs an _id")
+	}
+
+	groups := make([]bson.M, 0)
+	members := make([][]bson.M, 0)
+	for _, doc := range docs {
+		id, err := eval(idExpr, doc, vars)
+		if err != nil {
+			return nil, err
+		}
+
+		found := -1
+		for i, g := range groups {
+			if equal(g["_id"], id) {
+				found = i
+				break
+			}
+		}
+
+		if found < 0 {
+			groups = append(groups, bson.M{"_id": id})
+			members = append(members, nil)
+			found = len(groups) - 1
+		}
+		members[found] = append(members[found], doc)
+	}
+
+	for i, g := range groups {
+		for field, acc := range spec {
+			if field == "_id" {
+				continue
+			}
+
+			ops, ok := asDoc(acc)
+			if !ok || len(ops) != 1 {
+				return nil, fmt.Errorf("memdb: $group field %s must be an accumulator", field)
+			}
+
+			for op, expr := range ops {
+				v, err := accumulate(op, expr, members[i], vars)
+				if err != nil {
+					return nil, err
+				}
+				g[field] = v
+			}
+		}
+	}
+
+	return groups, nil
+}
+
+func accumulate(op string, expr interface{}, docs []bson.M, vars bson.M) (interface{}, error) {
+	values := make([]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		v, err := eval(expr, doc, vars)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+
+	switch op {
+	case "$sum", "$avg":
+		sum, n := 0.0, 0
+		for _, v := range values {
+			if f, ok := toFloat(v); ok {
+				sum += f
+				n++
+			}
+		}
+		if op == "$avg" {
+			if n == 0 {
+				return nil, nil
+			}
+			return sum / float64(n), nil
+		}
+		if sum == float64(int(sum)) {
+			return int(sum), nil
+		}
+		return sum, nil
+	case "$first":
+		if len(values) == 0 {
+			return nil, nil
+		}
+		return values[0], nil
+	case "$last":
+		if len(values) == 0 {
+			return nil, nil
+		}
+		return values[len(values)-1], nil
+	case "$min", "$max":
+		var result interface{}
+		for _, v := range values {
+			if v == nil {
+				continue
+			}
+			if result == nil || (op == "$min" && compare(v, result) < 0) || (op == "$max" && compare(v, result) > 0) {
+				result = v
+			}
+		}
+		return result, nil
+	case "$push":
+		return values, nil
+	case "$addToSet":
+		set := make([]interface{}, 0, len(values))
+		for _, v := range values {
+			if !contains(set, v) {
+				set = append(set, v)
+			}
+		}
+		return set, nil
+	}
+
+	return nil, fmt.Errorf("memdb: unsupported accumulator %s", op)
+}
+
+// sortDocs sorts docs in place by a sort specification, either a document
+// or the mgo style field list.
+func sortDocs(docs []bson.M, spec interface{}) error {
+	type key struct {
+		path  []string
+		order int
+	}
+
+	keys := make([]key, 0)
+	switch t := spec.(type) {
+	case bson.D:
+		for _, elem := range t {
+			n, _ := toFloat(elem.Value)
+			keys = append(keys, key{splitPath(elem.Name), int(n)})
+		}
+	case bson.M:
+		for _, name := range sortedKeys(t) {
+			n, _ := toFloat(t[name])
+			keys = append(keys, key{splitPath(name), int(n)})
+		}
+	case []string:
+		for _, field := range t {
+			order := 1
+			switch {
+			case strings.HasPrefix(field, "-"):
+				order, field = -1, field[1:]
+			case strings.HasPrefix(field, "+"):
+				field = field[1:]
+			}
+			keys = append(keys, key{splitPath(field), order})
+		}
+	default:
+		return fmt.Errorf("memdb: unsupported sort specification %T", spec)
+	}
+
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, k := range keys {
+			a, _ := getPath(docs[i], k.path)
+			b, _ := getPath(docs[j], k.path)
+			if c := compare(a, b); c != 0 {
+				return c*k.order < 0
+			}
+		}
+		return false
+	})
+
+	return nil
+}
+
+func copyDocs(docs []bson.M) []bson.M {
+	copies := make([]bson.M, len(docs))
+	for i, doc := range docs {
+		copies[i] = copyDoc(doc)
+	}
+
+	return copies
+}
+
+func contains(items []interface{}, v interface{}) bool {
+	for _, item := range items {
+		if equal(item, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	}
+
+	if f, ok := toFloat(v); ok {
+		return f != 0
+	}
+
+	return true
+}
+
+// eval evaluates an aggregation expression against doc
+func eval(expr interface{}, doc bson.M, vars bson.M) (interface{}, error) {
+	switch t := expr.(type) {
+	case string:
+		switch {
+		case strings.HasPrefix(t, "$$"):
+			keys := splitPath(t[2:])
+			var root interface{}
+			switch keys[0] {
+			case "ROOT", "CURRENT":
+				root = doc
+			default:
+				v, ok := vars[keys[0]]
+				if !ok {
+					return nil, fmt.Errorf("memdb: undefined variable %s", keys[0])
+				}
+				root = v
+			}
+			v, _ := resolve(root, keys[1:])
+			return v, nil
+		case strings.HasPrefix(t, "$"):
+			v, _ := resolve(doc, splitPath(t[1:]))
+			return v, nil
+		}
+		return t, nil
+	case []interface{}:
+		items := make([]interface{}, 0, len(t))
+		for _, item := range t {
+			v, err := eval(item, doc, vars)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+		}
+		return items, nil
+	case bson.M, bson.D:
+		spec, _ := asDoc(t)
+		if len(spec) == 1 {
+			for op, arg := range spec {
+				if strings.HasPrefix(op, "$") {
+					return evalOperator(op, arg, doc, vars)
+				}
+			}
+		}
+
+		result := bson.M{}
+		for k, v := range spec {
+			value, err := eval(v, doc, vars)
+			if err != nil {
+				return nil, err
+			}
+			result[k] = value
+		}
+		return result, nil
+	}
+
+	return expr, nil
+}
+
+func evalOperator(op string, arg interface{}, doc bson.M, vars bson.M) (interface{}, error) {
+	if op == "$literal" {
+		return arg, nil
+	}
+
+	v, err := eval(arg, doc, vars)
+	if err != nil {
+		return nil, err
+	}
+	args, _ := v.([]interface{})
+
+	switch op {
+	case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte", "$cmp":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("memdb: %s needs 2 arguments", op)
+		}
+		c := compare(args[0], args[1])
+		switch op {
+		case "$eq":
+			return c == 0, nil
+		case "$ne":
+			return c != 0, nil
+		case "$gt":
+			return c > 0, nil
+		case "$gte":
+			return c >= 0, nil
+		case "$lt":
+			return c < 0, nil
+		case "$lte":
+			return c <= 0, nil
+		}
+		return c, nil
+	case "$and":
+		for _, a := range args {
+			if !truthy(a) {
+				return false, nil
+			}
+		}
+		return true, nil
+	case "$or":
+		for _, a := range args {
+			if truthy(a) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$not":
+		if len(args) > 0 {
+			return !truthy(args[0]), nil
+		}
+		return !truthy(v), nil
+	case "$in":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("memdb: $in needs 2 arguments")
+		}
+		items, ok := args[1].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("memdb: $in needs an array")
+		}
+		return contains(items, args[0]), nil
+	case "$size":
+		if len(args) == 1 {
+			v = args[0]
+		}
+		items, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("memdb: $size needs an array")
+		}
+		return len(items), nil
+	case "$ifNull":
+		for _, a := range args {
+			if a != nil {
+				return a, nil
+			}
+		}
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("memdb: unsupported expression operator %s", op)
+}
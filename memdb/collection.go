@@ -0,0 +1,438 @@
+package memdb
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/iron-kit/monger"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type collection struct {
+	server *server
+	db     string
+	name   string
+}
+
+func (c *collection) key() string {
+	return c.db + "." + c.name
+}
+
+func (c *collection) Name() string {
+	return c.name
+}
+
+// With returns the collection itself, a memdb server is a single member so
+// read preferences and write concerns make no difference.
+func (c *collection) With(readPreference *monger.ReadPreference, writeConcern *monger.WriteConcern) monger.Collection {
+	return c
+}
+
+func (c *collection) Find(ctx context.Context, filter interface{}, opts *monger.FindOptions) monger.Cursor {
+	return &cursor{ctx: ctx, fetch: func() ([]bson.M, error) {
+		f, err := toDoc(filter)
+		if err != nil {
+			return nil, err
+		}
+
+		c.server.mu.RLock()
+		docs, err := c.server.find(c.key(), f)
+		c.server.mu.RUnlock()
+		if err != nil || opts == nil {
+			return docs, err
+		}
+
+		if len(opts.Sort) > 0 {
+			if err := sortDocs(docs, opts.Sort); err != nil {
+				return nil, err
+			}
+		}
+
+		if opts.Skip > 0 {
+			if opts.Skip >= len(docs) {
+				docs = docs[:0]
+			} else {
+				docs = docs[opts.Skip:]
+			}
+		}
+
+		if opts.Limit > 0 && opts.Limit < len(docs) {
+			docs = docs[:opts.Limit]
+		}
+
+		if opts.Selector != nil {
+			spec, err := toDoc(opts.Selector)
+			if err != nil {
+				return nil, err
+			}
+			return stageProject(docs, spec, nil)
+		}
+
+		return docs, nil
+	}}
+}
+
+func (c *collection) Aggregate(ctx context.Context, pipeline []bson.M) monger.Cursor {
+	return &cursor{ctx: ctx, fetch: func() ([]bson.M, error) {
+		p, err := normalize(pipeline)
+		if err != nil {
+			return nil, err
+		}
+		stages, _ := p.([]interface{})
+
+		c.server.mu.RLock()
+		defer c.server.mu.RUnlock()
+
+		return c.server.aggregate(c.db, copyDocs(c.server.collections[c.key()]), stages, bson.M{})
+	}}
+}
+
+func (c *collection) Count(ctx context.Context, filter interface{}) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	f, err := toDoc(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	c.server.mu.RLock()
+	defer c.server.mu.RUnlock()
+
+	docs, err := c.server.find(c.key(), f)
+	return len(docs), err
+}
+
+func (c *collection) Insert(ctx context.Context, docs ...interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	inserts := make([]bson.M, 0, len(docs))
+	for _, d := range docs {
+		doc, err := toDoc(d)
+		if err != nil {
+			return err
+		}
+		if _, ok := doc["_id"]; !ok {
+			doc["_id"] = bson.NewObjectId()
+		}
+		inserts = append(inserts, doc)
+	}
+
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+
+	for _, doc := range inserts {
+		if err := c.server.insert(c.key(), doc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *collection) Update(ctx context.Context, selector interface{}, update interface{}) error {
+	info, err := c.update(ctx, selector, update, false, false)
+	if err != nil {
+		return err
+	}
+
+	if info.Matched == 0 {
+		return monger.ErrNotFound
+	}
+
+	return nil
+}
+
+func (c *collection) UpdateAll(ctx context.Context, selector interface{}, update interface{}) (*monger.ChangeInfo, error) {
+	return c.update(ctx, selector, update, true, false)
+}
+
+func (c *collection) Upsert(ctx context.Context, selector interface{}, update interface{}) (*monger.ChangeInfo, error) {
+	return c.update(ctx, selector, update, false, true)
+}
+
+func (c *collection) update(ctx context.Context, selector interface{}, update interface{}, multi bool, upsert bool) (*monger.ChangeInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f, err := toDoc(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := toDoc(update)
+	if err != nil {
+		return nil, err
+	}
+
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+
+	info := &monger.ChangeInfo{}
+	docs := c.server.collections[c.key()]
+	for i, doc := range docs {
+		ok, err := match(doc, f, nil)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		updated, err := applyUpdate(copyDoc(doc), u, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := checkUnique(c.key(), c.server.indexes[c.key()], docs, updated, i); err != nil {
+			return nil, err
+		}
+		if err := c.server.validate(c.key(), doc, updated); err != nil {
+			return nil, err
+		}
+
+		info.Matched++
+		if !reflect.DeepEqual(updated, doc) {
+			info.Updated++
+		}
+		docs[i] = updated
+
+		if !multi {
+			return info, nil
+		}
+	}
+
+	if info.Matched > 0 || !upsert {
+		return info, nil
+	}
+
+	doc, err := applyUpdate(upsertDoc(f), u, true)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = bson.NewObjectId()
+	}
+
+	if err := c.server.insert(c.key(), doc); err != nil {
+		return nil, err
+	}
+	info.UpsertedId = doc["_id"]
+
+	return info, nil
+}
+
+func (c *collection) FindAndModify(ctx context.Context, selector interface{}, change monger.Change, result interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f, err := toDoc(selector)
+	if err != nil {
+		return err
+	}
+
+	u, err := toDoc(change.Update)
+	if err != nil {
+		return err
+	}
+
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+
+	docs := c.server.collections[c.key()]
+	for i, doc := range docs {
+		ok, err := match(doc, f, nil)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		updated, err := applyUpdate(copyDoc(doc), u, false)
+		if err != nil {
+			return err
+		}
+
+		if err := checkUnique(c.key(), c.server.indexes[c.key()], docs, updated, i); err != nil {
+			return err
+		}
+		if err := c.server.validate(c.key(), doc, updated); err != nil {
+			return err
+		}
+		docs[i] = updated
+
+		if change.ReturnNew {
+			return decode(copyDoc(updated), result)
+		}
+		return decode(copyDoc(doc), result)
+	}
+
+	if !change.Upsert {
+		return monger.ErrNotFound
+	}
+
+	doc, err := applyUpdate(upsertDoc(f), u, true)
+	if err != nil {
+		return err
+	}
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = bson.NewObjectId()
+	}
+
+	if err := c.server.insert(c.key(), doc); err != nil {
+		return err
+	}
+
+	if change.ReturnNew {
+		return decode(copyDoc(doc), result)
+	}
+	return monger.ErrNotFound
+}
+
+func (c *collection) Remove(ctx context.Context, selector interface{}) error {
+	info, err := c.remove(ctx, selector, false)
+	if err != nil {
+		return err
+	}
+
+	if info.Removed == 0 {
+		return monger.ErrNotFound
+	}
+
+	return nil
+}
+
+func (c *collection) RemoveAll(ctx context.Context, selector interface{}) (*monger.ChangeInfo, error) {
+	return c.remove(ctx, selector, true)
+}
+
+func (c *collection) remove(ctx context.Context, selector interface{}, multi bool) (*monger.ChangeInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f, err := toDoc(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+
+	info := &monger.ChangeInfo{}
+	docs := c.server.collections[c.key()]
+	kept := make([]bson.M, 0, len(docs))
+	for _, doc := range docs {
+		if multi || info.Removed == 0 {
+			ok, err := match(doc, f, nil)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				info.Removed++
+				info.Matched++
+				continue
+			}
+		}
+		kept = append(kept, doc)
+	}
+	c.server.collections[c.key()] = kept
+
+	return info, nil
+}
+
+// find returns copies of the documents matching filter, the read lock must be held
+func (s *server) find(key string, filter bson.M) ([]bson.M, error) {
+	docs := make([]bson.M, 0)
+	for _, doc := range s.collections[key] {
+		ok, err := match(doc, filter, nil)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			docs = append(docs, copyDoc(doc))
+		}
+	}
+
+	return docs, nil
+}
+
+// insert stores doc, the write lock must be held
+func (s *server) insert(key string, doc bson.M) error {
+	for _, other := range s.collections[key] {
+		if equal(other["_id"], doc["_id"]) {
+			return &monger.DuplicateDocumentError{
+				MongerQueryError: monger.NewError(fmt.Sprintf("memdb: duplicate key %v in %s", doc["_id"], key)),
+			}
+		}
+	}
+
+	if err := checkUnique(key, s.indexes[key], s.collections[key], doc, -1); err != nil {
+		return err
+	}
+	if err := s.validate(key, nil, doc); err != nil {
+		return err
+	}
+
+	s.collections[key] = append(s.collections[key], doc)
+	return nil
+}
+
+// cursor computes its documents once One or All is called
+type cursor struct {
+	ctx   context.Context
+	fetch func() ([]bson.M, error)
+}
+
+func (c *cursor) One(result interface{}) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+
+	docs, err := c.fetch()
+	if err != nil {
+		return err
+	}
+
+	if len(docs) == 0 {
+		return monger.ErrNotFound
+	}
+
+	return decode(docs[0], result)
+}
+
+func (c *cursor) All(result interface{}) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+
+	resultv := reflect.ValueOf(result)
+	if resultv.Kind() != reflect.Ptr || resultv.Elem().Kind() != reflect.Slice {
+		panic("result argument must be a slice address")
+	}
+
+	docs, err := c.fetch()
+	if err != nil {
+		return err
+	}
+
+	slicev := resultv.Elem().Slice(0, 0)
+	elemt := slicev.Type().Elem()
+	for _, doc := range docs {
+		elemp := reflect.New(elemt)
+		if err := decode(doc, elemp.Interface()); err != nil {
+			return err
+		}
+		slicev = reflect.Append(slicev, elemp.Elem())
+	}
+	resultv.Elem().Set(slicev)
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package memdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/iron-kit/monger"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// idIndex is the index every collection has
+var idIndex = monger.Index{Name: "_id_", Key: []string{"_id"}, Unique: true}
+
+func (c *collection) Indexes(ctx context.Context) ([]monger.Index, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.server.mu.RLock()
+	defer c.server.mu.RUnlock()
+
+	return append([]monger.Index{idIndex}, c.server.indexes[c.key()]...), nil
+}
+
+// EnsureIndex stores the index, unique indexes are enforced by the writes
+// which follow. Like a server it fails when the key or the name is already
+// indexed with other options, and when the documents break a unique index.
+func (c *collection) EnsureIndex(ctx context.Context, index monger.Index) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if index.Name == "" {
+		index.Name = indexName(index.Key)
+	}
+
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+
+	for _, other := range c.server.indexes[c.key()] {
+		sameKey := strings.Join(other.Key, ",") == strings.Join(index.Key, ",")
+		if !sameKey && other.Name != index.Name {
+			continue
+		}
+
+		if sameKey && other.Name == index.Name && other.Unique == index.Unique &&
+			other.Sparse == index.Sparse && other.ExpireAfter == index.ExpireAfter {
+			return nil
+		}
+
+		return fmt.Errorf("memdb: index %s conflicts with index %s of %s", index.Name, other.Name, c.key())
+	}
+
+	docs := c.server.collections[c.key()]
+	for i, doc := range docs {
+		if err := checkUnique(c.key(), []monger.Index{index}, docs[:i], doc, -1); err != nil {
+			return err
+		}
+	}
+
+	c.server.indexes[c.key()] = append(c.server.indexes[c.key()], index)
+	return nil
+}
+
+func (c *collection) DropIndex(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+
+	indexes := c.server.indexes[c.key()]
+	for i, index := range indexes {
+		if index.Name == name {
+			c.server.indexes[c.key()] = append(indexes[:i:i], indexes[i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("memdb: index %s not found in %s", name, c.key())
+}
+
+// SetIndexExpiry changes the expiry of a ttl index like collMod
+func (c *collection) SetIndexExpiry(ctx context.Context, name string, expireAfter time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+
+	for i, index := range c.server.indexes[c.key()] {
+		if index.Name != name {
+			continue
+		}
+		if index.ExpireAfter == 0 {
+			return fmt.Errorf("memdb: index %s of %s is not a ttl index", name, c.key())
+		}
+
+		c.server.indexes[c.key()][i].ExpireAfter = expireAfter
+		return nil
+	}
+
+	return fmt.Errorf("memdb: index %s not found in %s", name, c.key())
+}
+
+// indexName is the name a server gives to an index key
+func indexName(keys []string) string {
+	parts := make([]string, 0, len(keys)*2)
+	for _, key := range keys {
+		switch {
+		case strings.HasPrefix(key, "$"):
+			kind := strings.SplitN(key[1:], ":", 2)
+			parts = append(parts, kind[len(kind)-1], kind[0])
+		case strings.HasPrefix(key, "-"):
+			parts = append(parts, key[1:], "-1")
+		default:
+			parts = append(parts, strings.TrimPrefix(key, "+"), "1")
+		}
+	}
+
+	return strings.Join(parts, "_")
+}
+
+// indexValues returns the values of the key of a unique index in doc, ok
+// is false when a sparse index skips the document.
+func indexValues(index monger.Index, doc bson.M) (values []interface{}, ok bool) {
+	found := false
+	for _, key := range index.Key {
+		path := splitPath(strings.TrimLeft(key, "+-"))
+		v, exists := getPath(doc, path)
+		found = found || exists
+		values = append(values, v)
+	}
+
+	return values, found || !index.Sparse
+}
+
+// checkUnique fails when doc has the key of a unique index of another
+// document in docs, self is the position of doc in docs or -1.
+func checkUnique(key string, indexes []monger.Index, docs []bson.M, doc bson.M, self int) error {
+	for _, index := range indexes {
+		if !index.Unique {
+			continue
+		}
+
+		values, ok := indexValues(index, doc)
+		if !ok {
+			continue
+		}
+
+		for i, other := range docs {
+			if i == self {
+				continue
+			}
+
+			otherValues, ok := indexValues(index, other)
+			if !ok {
+				continue
+			}
+
+			duplicate := true
+			for j := range values {
+				duplicate = duplicate && equal(values[j], otherValues[j])
+			}
+
+			if duplicate {
+				return &monger.DuplicateDocumentError{
+					MongerQueryError: monger.NewError(fmt.Sprintf("memdb: duplicate key %v of index %s in %s", values, index.Name, key)),
+				}
+			}
+		}
+	}
+
+	return nil
+}
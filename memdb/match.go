@@ -0,0 +1,287 @@
+package memdb
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// match reports whether doc satisfies the query filter, vars are the
+// variables visible to $expr.
+func match(doc bson.M, filter bson.M, vars bson.M) (bool, error) {
+	for key, cond := range filter {
+		var (
+			ok  bool
+			err error
+		)
+
+		switch key {
+		case "$and", "$or", "$nor":
+			ok, err = matchLogical(doc, key, cond, vars)
+		case "$expr":
+			var v interface{}
+			v, err = eval(cond, doc, vars)
+			ok = truthy(v)
+		case "$comment":
+			ok = true
+		default:
+			if strings.HasPrefix(key, "$") {
+				return false, fmt.Errorf("memdb: unsupported query operator %s", key)
+			}
+			ok, err = matchField(doc, key, cond)
+		}
+
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func matchLogical(doc bson.M, op string, cond interface{}, vars bson.M) (bool, error) {
+	items, ok := cond.([]interface{})
+	if !ok || len(items) == 0 {
+		return false, fmt.Errorf("memdb: %s must be a nonempty array", op)
+	}
+
+	for _, item := range items {
+		sub, ok := asDoc(item)
+		if !ok {
+			return false, fmt.Errorf("memdb: %s entries must be documents", op)
+		}
+
+		matched, err := match(doc, sub, vars)
+		if err != nil {
+			return false, err
+		}
+
+		switch {
+		case op == "$and" && !matched:
+			return false, nil
+		case op == "$or" && matched:
+			return true, nil
+		case op == "$nor" && matched:
+			return false, nil
+		}
+	}
+
+	return op != "$or", nil
+}
+
+func matchField(doc bson.M, path string, cond interface{}) (bool, error) {
+	values := lookup(doc, splitPath(path))
+
+	if isOperatorDoc(cond) {
+		ops, _ := asDoc(cond)
+		for op, arg := range ops {
+			if op == "$options" {
+				continue
+			}
+
+			if _, ok := arg.(bson.RegEx); op == "$regex" && !ok {
+				options, _ := ops["$options"].(string)
+				arg = bson.RegEx{Pattern: fmt.Sprint(arg), Options: options}
+			}
+
+			ok, err := matchOperator(values, op, arg)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+
+		return true, nil
+	}
+
+	return matchEqual(values, cond)
+}
+
+// matchEqual implements the implicit $eq of a filter, a regular expression
+// matches strings and null matches missing fields.
+func matchEqual(values []interface{}, cond interface{}) (bool, error) {
+	if re, ok := cond.(bson.RegEx); ok {
+		return matchRegex(values, re)
+	}
+
+	if cond == nil && len(values) == 0 {
+		return true, nil
+	}
+
+	for _, v := range values {
+		if equal(v, cond) {
+			return true, nil
+		}
+
+		if items, ok := v.([]interface{}); ok {
+			for _, item := range items {
+				if equal(item, cond) {
+					return true, nil
+				}
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// expand returns the values and the elements of the array values
+func expand(values []interface{}) []interface{} {
+	all := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		if items, ok := v.([]interface{}); ok {
+			all = append(all, items...)
+		}
+		all = append(all, v)
+	}
+
+	return all
+}
+
+func matchOperator(values []interface{}, op string, arg interface{}) (bool, error) {
+	switch op {
+	case "$eq":
+		return matchEqual(values, arg)
+	case "$ne":
+		ok, err := matchEqual(values, arg)
+		return !ok, err
+	case "$gt", "$gte", "$lt", "$lte":
+		for _, v := range expand(values) {
+			if typeOrder(v) != typeOrder(arg) {
+				continue
+			}
+
+			c := compare(v, arg)
+			if (op == "$gt" && c > 0) || (op == "$gte" && c >= 0) ||
+				(op == "$lt" && c < 0) || (op == "$lte" && c <= 0) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$in", "$nin":
+		items, ok := arg.([]interface{})
+		if !ok {
+			return false, fmt.Errorf("memdb: %s needs an array", op)
+		}
+
+		found := false
+		for _, item := range items {
+			ok, err := matchEqual(values, item)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				found = true
+				break
+			}
+		}
+		return found == (op == "$in"), nil
+	case "$exists":
+		return (len(values) > 0) == truthy(arg), nil
+	case "$regex":
+		return matchRegex(values, arg.(bson.RegEx))
+	case "$not":
+		var (
+			ok  bool
+			err error
+		)
+		if ops, isDoc := asDoc(arg); isDoc {
+			ok = true
+			for sub, subArg := range ops {
+				matched, err := matchOperator(values, sub, subArg)
+				if err != nil {
+					return false, err
+				}
+				ok = ok && matched
+			}
+		} else if re, isRegex := arg.(bson.RegEx); isRegex {
+			ok, err = matchRegex(values, re)
+		} else {
+			return false, fmt.Errorf("memdb: $not needs a document or a regular expression")
+		}
+		return !ok, err
+	case "$size":
+		n, ok := toFloat(arg)
+		if !ok {
+			return false, fmt.Errorf("memdb: $size needs a number")
+		}
+		for _, v := range values {
+			if items, ok := v.([]interface{}); ok && float64(len(items)) == n {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$all":
+		items, ok := arg.([]interface{})
+		if !ok {
+			return false, fmt.Errorf("memdb: $all needs an array")
+		}
+		if len(items) == 0 {
+			return false, nil
+		}
+		for _, item := range items {
+			ok, err := matchEqual(values, item)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	case "$elemMatch":
+		cond, ok := asDoc(arg)
+		if !ok {
+			return false, fmt.Errorf("memdb: $elemMatch needs a document")
+		}
+		for _, v := range values {
+			items, ok := v.([]interface{})
+			if !ok {
+				continue
+			}
+			for _, item := range items {
+				var (
+					matched bool
+					err     error
+				)
+				if isOperatorDoc(cond) {
+					matched, err = matchField(bson.M{"v": item}, "v", cond)
+				} else if sub, isDoc := item.(bson.M); isDoc {
+					matched, err = match(sub, cond, nil)
+				}
+				if err != nil {
+					return false, err
+				}
+				if matched {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	}
+
+	return false, fmt.Errorf("memdb: unsupported query operator %s", op)
+}
+
+func matchRegex(values []interface{}, re bson.RegEx) (bool, error) {
+	pattern := re.Pattern
+	if flags := strings.Map(func(r rune) rune {
+		if strings.ContainsRune("ims", r) {
+			return r
+		}
+		return -1
+	}, re.Options); flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+
+	exp, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, err
+	}
+
+	for _, v := range expand(values) {
+		if s, ok := v.(string); ok && exp.MatchString(s) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,118 @@
+/*
+Package memdb is an in-memory monger Driver, it lets models be unit tested
+without a MongoDB server:
+
+	conn, err := monger.Connect(monger.UseDriver(memdb.New()))
+
+It implements the part of the query language monger relies on: query
+filters with the comparison, logical, element and array operators, the
+$set/$unset/$inc/$push/$addToSet/$pull/$setOnInsert update operators, sort,
+skip, limit and projections, and the $match, $lookup, $unwind, $project,
+$addFields, $sort, $skip, $limit, $group and $count aggregation stages.
+Unsupported operators are reported as errors instead of being ignored.
+Indexes are kept so EnsureIndexes works, only unique indexes are enforced.
+The $jsonSchema validators set by EnsureValidator check the writes, with
+the keywords monger generates.
+
+Every connection dialed with the same driver shares its data, use a new
+driver per test to start from an empty server.
+*/
+package memdb
+
+import (
+	"context"
+	"sync"
+
+	"github.com/iron-kit/monger"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const defaultDBName = "test"
+
+// server holds the documents, the indexes and the validators of every database, keyed by
+// "database.collection"
+type server struct {
+	mu          sync.RWMutex
+	collections map[string][]bson.M
+	indexes     map[string][]monger.Index
+	validators  map[string]monger.Validator
+}
+
+type driver struct {
+	server *server
+}
+
+// New returns an empty in-memory server for monger.Connect
+func New() monger.Driver {
+	return &driver{
+		server: &server{
+			collections: make(map[string][]bson.M),
+			indexes:     make(map[string][]monger.Index),
+			validators:  make(map[string]monger.Validator),
+		},
+	}
+}
+
+func (d *driver) Dial(ctx context.Context, config *monger.Config) (monger.Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	dbName := config.DBName
+	if config.DialInfo != nil && config.DialInfo.Database != "" {
+		dbName = config.DialInfo.Database
+	}
+
+	if dbName == "" {
+		dbName = defaultDBName
+	}
+
+	return &session{server: d.server, dbName: dbName}, nil
+}
+
+type session struct {
+	server *server
+	dbName string
+}
+
+func (s *session) DB(name string) monger.Database {
+	if name == "" {
+		name = s.dbName
+	}
+
+	return &database{server: s.server, name: name}
+}
+
+func (s *session) Clone() monger.Session {
+	return &session{server: s.server, dbName: s.dbName}
+}
+
+func (s *session) Copy() monger.Session {
+	return s.Clone()
+}
+
+func (s *session) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (s *session) Status() monger.SessionStatus {
+	return monger.SessionStatus{
+		Topology: "Single",
+		Servers:  []monger.ServerStatus{{Address: "memdb", Kind: "Standalone"}},
+	}
+}
+
+func (s *session) Close() {}
+
+type database struct {
+	server *server
+	name   string
+}
+
+func (db *database) Name() string {
+	return db.name
+}
+
+func (db *database) C(name string) monger.Collection {
+	return &collection{server: db.server, db: db.name, name: name}
+}
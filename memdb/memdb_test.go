@@ -0,0 +1,774 @@
+package memdb_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iron-kit/monger"
+	"github.com/iron-kit/monger/memdb"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type Member struct {
+	monger.Schema `json:",inline" bson:",inline"`
+	Username      string   `json:"username,omitempty" bson:"username,omitempty"`
+	Age           int      `json:"age,omitempty" bson:"age,omitempty"`
+	Profile       *Profile `json:"profile,omitempty" bson:"profile,omitempty" monger:"hasOne,foreignKey=user_id"`
+}
+
+type Profile struct {
+	monger.Schema `json:",inline" bson:",inline"`
+	Nickname      string        `json:"nickname,omitempty" bson:"nickname,omitempty"`
+	UserID        bson.ObjectId `json:"user_id,omitempty" bson:"user_id,omitempty"`
+}
+
+func conn(t *testing.T) monger.Connection {
+	conn, err := monger.Connect(
+		monger.UseDriver(memdb.New()),
+		monger.DBName("monger_test"),
+	)
+	assert.NoError(t, err)
+
+	conn.BatchRegister(
+		new(Member),
+		new(Profile),
+	)
+
+	return conn
+}
+
+func TestCreateAndFind(t *testing.T) {
+	c := conn(t)
+	MemberModel := c.M("Member")
+
+	for i, name := range []string{"alice", "bob", "carol"} {
+		assert.NoError(t, MemberModel.Create(&Member{Username: name, Age: 20 + i}))
+	}
+
+	member := new(Member)
+	assert.NoError(t, MemberModel.FindOne(member, bson.M{"username": "bob"}))
+	assert.Equal(t, 21, member.Age)
+	assert.False(t, member.IsEmpty())
+
+	members := make([]*Member, 0)
+	err := MemberModel.
+		Where(bson.M{"age": bson.M{"$gte": 21}}).
+		Sort("-age").
+		Limit(1).
+		FindAll(&members)
+	assert.NoError(t, err)
+	assert.Len(t, members, 1)
+	assert.Equal(t, "carol", members[0].Username)
+
+	assert.Equal(t, 3, MemberModel.Count())
+	assert.Equal(t, monger.ErrNotFound, MemberModel.FindOne(member, bson.M{"username": "dave"}))
+}
+
+func TestUpdateAndSoftDelete(t *testing.T) {
+	c := conn(t)
+	MemberModel := c.M("Member")
+
+	member := &Member{Username: "alice"}
+	assert.NoError(t, MemberModel.Create(member))
+
+	err := MemberModel.Update(bson.M{"_id": member.ID}, bson.M{"$set": bson.M{"age": 30}})
+	assert.NoError(t, err)
+
+	found := new(Member)
+	assert.NoError(t, MemberModel.FindByID(member.ID, found))
+	assert.Equal(t, 30, found.Age)
+	assert.Equal(t, "alice", found.Username)
+
+	assert.NoError(t, MemberModel.Delete(bson.M{"_id": member.ID}))
+	assert.Equal(t, monger.ErrNotFound, MemberModel.FindByID(member.ID, found))
+	assert.Equal(t, 1, MemberModel.OffSoftDeletes().Where(bson.M{"_id": member.ID}).Count())
+
+	assert.NoError(t, MemberModel.Restore(bson.M{"_id": member.ID}))
+	assert.NoError(t, MemberModel.FindByID(member.ID, found))
+}
+
+func TestPopulate(t *testing.T) {
+	c := conn(t)
+	MemberModel := c.M("Member")
+	ProfileModel := c.M("Profile")
+
+	member := &Member{Username: "alice"}
+	assert.NoError(t, MemberModel.Create(member))
+	assert.NoError(t, ProfileModel.Create(&Profile{Nickname: "nova", UserID: member.ID}))
+
+	found := new(Member)
+	err := MemberModel.
+		Where(bson.M{"_id": member.ID}).
+		Populate("Profile").
+		FindOne(found)
+	assert.NoError(t, err)
+	assert.NotNil(t, found.Profile)
+	assert.Equal(t, "nova", found.Profile.Nickname)
+
+	assert.Equal(t, 1, MemberModel.Where(bson.M{"_id": member.ID}).Populate("Profile").Count())
+}
+
+func TestUnsupportedOperator(t *testing.T) {
+	c := conn(t)
+	assert.NoError(t, c.M("Member").Create(&Member{Username: "alice"}))
+
+	members := make([]*Member, 0)
+	err := c.M("Member").Where(bson.M{"$where": "this.age > 1"}).FindAll(&members)
+	assert.Error(t, err)
+}
+
+func TestConcurrentQueries(t *testing.T) {
+	c := conn(t)
+	MemberModel := c.M("Member")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			username := fmt.Sprintf("member%d", i)
+			assert.NoError(t, MemberModel.Create(&Member{Username: username, Age: i}))
+
+			found := new(Member)
+			assert.NoError(t, MemberModel.FindOne(found, bson.M{"username": username}))
+			assert.Equal(t, i, found.Age)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 20, MemberModel.Count())
+}
+
+func TestClosedConnection(t *testing.T) {
+	c := conn(t)
+	MemberModel := c.M("Member")
+
+	c.Close()
+	assert.Equal(t, monger.ErrConnectionClosed, MemberModel.Create(&Member{Username: "alice"}))
+
+	assert.NoError(t, c.Open())
+	assert.NoError(t, MemberModel.Create(&Member{Username: "alice"}))
+}
+
+type Device struct {
+	monger.Schema `json:",inline" bson:",inline"`
+	OrgID         string    `json:"org_id" bson:"org_id"`
+	Serial        string    `json:"serial" bson:"serial" monger:"unique"`
+	Note          string    `json:"note" bson:"note" monger:"text"`
+	SeenAt        time.Time `json:"seen_at" bson:"seen_at" monger:"ttl=720h"`
+}
+
+func (d *Device) Indexes() []monger.Index {
+	return []monger.Index{{Key: []string{"org_id", "-seen_at"}}}
+}
+
+func indexConn(t *testing.T, options ...monger.ConfigOption) monger.Connection {
+	c, err := monger.Connect(append([]monger.ConfigOption{
+		monger.ConnectionName("indexes"),
+		monger.UseDriver(memdb.New()),
+		monger.DBName("monger_test"),
+	}, options...)...)
+	if assert.NoError(t, err) {
+		t.Cleanup(c.Close)
+	}
+
+	return c
+}
+
+func TestEnsureIndexes(t *testing.T) {
+	c := indexConn(t)
+	DeviceModel := c.M(new(Device))
+
+	indexes, err := c.CloneSession().DB("").C("device").(monger.IndexManager).Indexes(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, indexes, 5)
+
+	diff, err := DeviceModel.DiffIndexes(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, diff.IsEmpty(), diff.String())
+
+	assert.NoError(t, DeviceModel.Create(&Device{Serial: "A1"}))
+	err = DeviceModel.Create(&Device{Serial: "A1"})
+	assert.IsType(t, &monger.DuplicateDocumentError{}, err)
+}
+
+func TestIndexDryRun(t *testing.T) {
+	c := indexConn(t, monger.AutoIndex(monger.IndexDryRun))
+	DeviceModel := c.M(new(Device))
+
+	coll := c.CloneSession().DB("").C("device").(monger.IndexManager)
+	assert.NoError(t, coll.EnsureIndex(context.Background(), monger.Index{Key: []string{"serial"}}))
+	assert.NoError(t, coll.EnsureIndex(context.Background(), monger.Index{Key: []string{"note"}}))
+
+	diffs, err := c.DiffIndexes(context.Background())
+	assert.NoError(t, err)
+	if assert.Len(t, diffs, 1) {
+		diff := diffs[0]
+		assert.Equal(t, "monger_test.device", diff.Collection)
+		assert.Len(t, diff.Missing, 3)
+		assert.Len(t, diff.Extra, 1)
+		if assert.Len(t, diff.Changed, 1) {
+			assert.False(t, diff.Changed[0].From.Unique)
+			assert.True(t, diff.Changed[0].To.Unique)
+		}
+	}
+
+	assert.NoError(t, DeviceModel.EnsureIndexes(context.Background()))
+	diff, err := DeviceModel.DiffIndexes(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, diff.Missing)
+	assert.Empty(t, diff.Changed)
+	assert.Len(t, diff.Extra, 1)
+}
+
+func TestIndexRebuild(t *testing.T) {
+	ctx := context.Background()
+	c := indexConn(t)
+	coll := c.CloneSession().DB("").C("device").(monger.IndexManager)
+	assert.NoError(t, coll.EnsureIndex(ctx, monger.Index{Key: []string{"serial"}}))
+	assert.NoError(t, coll.EnsureIndex(ctx, monger.Index{Key: []string{"seen_at"}, ExpireAfter: time.Hour}))
+
+	// registering a schema does not drop the changed indexes
+	DeviceModel := c.M(new(Device))
+	diff, err := DeviceModel.DiffIndexes(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, diff.Missing)
+	assert.Len(t, diff.Changed, 2)
+
+	rebuild := indexConn(t, monger.ConnectionName("rebuild"), monger.AutoIndex(monger.IndexRebuild))
+	existing := rebuild.CloneSession().DB("").C("device").(monger.IndexManager)
+	assert.NoError(t, existing.EnsureIndex(ctx, monger.Index{Key: []string{"serial"}}))
+	diff, err = rebuild.M(new(Device)).DiffIndexes(ctx)
+	assert.NoError(t, err)
+	assert.True(t, diff.IsEmpty(), diff.String())
+
+	assert.NoError(t, DeviceModel.EnsureIndexes(ctx))
+	diff, err = DeviceModel.DiffIndexes(ctx)
+	assert.NoError(t, err)
+	assert.True(t, diff.IsEmpty(), diff.String())
+}
+
+func TestTenantIndexes(t *testing.T) {
+	c := indexConn(t, monger.WithTenancy(monger.TenantPolicy{Strict: true}))
+	DeviceModel := c.M(new(Device))
+
+	ctx := monger.WithTenant(context.Background(), "acme")
+	assert.NoError(t, DeviceModel.WithContext(ctx).Create(&Device{Serial: "A1"}))
+	assert.IsType(t, &monger.DuplicateDocumentError{}, DeviceModel.WithContext(ctx).Create(&Device{Serial: "A1"}))
+
+	indexes, err := c.CloneSession().DB("monger_test").C("device").(monger.IndexManager).Indexes(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, indexes, 1)
+
+	diff, err := DeviceModel.DiffIndexes(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "monger_test_acme.device", diff.Collection)
+	assert.True(t, diff.IsEmpty(), diff.String())
+}
+
+type Conversation struct {
+	monger.Schema `json:",inline" bson:",inline"`
+	Topic         string `json:"topic" bson:"topic"`
+	Version       int    `json:"version" bson:"version" monger:"version"`
+}
+
+func TestOptimisticLocking(t *testing.T) {
+	c := conn(t)
+	ConversationModel := c.M(new(Conversation))
+
+	conversation := &Conversation{Topic: "billing"}
+	assert.NoError(t, ConversationModel.Create(conversation))
+
+	first, second := new(Conversation), new(Conversation)
+	assert.NoError(t, ConversationModel.FindOne(first, bson.M{"_id": conversation.ID}))
+	assert.NoError(t, ConversationModel.FindOne(second, bson.M{"_id": conversation.ID}))
+
+	first.Topic = "refunds"
+	assert.NoError(t, ConversationModel.Update(bson.M{"_id": first.ID}, first))
+	assert.Equal(t, 1, first.Version)
+
+	second.Topic = "invoices"
+	err := ConversationModel.Update(bson.M{"_id": second.ID}, second)
+	assert.IsType(t, &monger.ConcurrentModificationError{}, err)
+	assert.Equal(t, 0, second.Version)
+
+	err = ConversationModel.Update(bson.M{"_id": bson.NewObjectId()}, first)
+	assert.Equal(t, monger.ErrNotFound, err)
+	assert.Equal(t, 1, first.Version)
+
+	assert.NoError(t, ConversationModel.Update(bson.M{"_id": first.ID}, bson.M{"$set": bson.M{"topic": "tax"}}))
+	found := new(Conversation)
+	assert.NoError(t, ConversationModel.FindOne(found, bson.M{"_id": first.ID}))
+	assert.Equal(t, "tax", found.Topic)
+	assert.Equal(t, 2, found.Version)
+}
+
+type APIKey struct {
+	monger.BaseSchema `json:",inline" bson:",inline"`
+	ID                string `json:"id" bson:"_id" monger:"id=uuidv4"`
+	Label             string `json:"label" bson:"label"`
+}
+
+func TestCustomPrimaryKey(t *testing.T) {
+	c := conn(t)
+	KeyModel := c.M(new(APIKey))
+
+	key := &APIKey{Label: "ci"}
+	assert.NoError(t, KeyModel.Create(key))
+	assert.Len(t, key.ID, 36)
+
+	found := new(APIKey)
+	assert.NoError(t, KeyModel.FindByID(key.ID, found))
+	assert.Equal(t, "ci", found.Label)
+	assert.False(t, found.IsEmpty())
+
+	// a hex string is not an ObjectId for a string primary key
+	_, err := KeyModel.UpsertID("5bb8649f16a44b47ae85aa41", bson.M{"$set": bson.M{"label": "deploy"}})
+	assert.NoError(t, err)
+	n, err := c.CloneSession().DB("").C("a_p_i_key").Count(context.Background(), bson.M{"_id": "5bb8649f16a44b47ae85aa41"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	member := &Member{Username: "alice"}
+	assert.NoError(t, c.M("Member").Create(member))
+	assert.NoError(t, c.M("Member").FindByID(member.ID.Hex(), new(Member)))
+}
+
+type Invoice struct {
+	monger.Schema `json:",inline" bson:",inline"`
+	Year          string `json:"year" bson:"year"`
+	Number        int64  `json:"number" bson:"number" monger:"sequence=invoice_no,required"`
+}
+
+func TestSequences(t *testing.T) {
+	monger.RegisterSequence("invoice_no", monger.Sequence{
+		Start: 1000,
+		Step:  10,
+		Scope: func(doc interface{}) string {
+			return doc.(*Invoice).Year
+		},
+	})
+
+	c, err := monger.Connect(
+		monger.ConnectionName("sequences"),
+		monger.UseDriver(memdb.New()),
+		monger.DBName("monger_test"),
+		monger.WithTenancy(monger.TenantPolicy{}),
+	)
+	assert.NoError(t, err)
+	defer c.Close()
+	InvoiceModel := c.M(new(Invoice))
+
+	first := &Invoice{Year: "2026"}
+	assert.NoError(t, InvoiceModel.Create(first))
+	assert.Equal(t, int64(1000), first.Number)
+
+	batch := []*Invoice{{Year: "2026"}, {Year: "2027"}, {Year: "2026", Number: 7}, {Year: "2026"}}
+	assert.NoError(t, InvoiceModel.Create(&batch))
+	assert.Equal(t, int64(1010), batch[0].Number)
+	assert.Equal(t, int64(1000), batch[1].Number)
+	assert.Equal(t, int64(7), batch[2].Number)
+	assert.Equal(t, int64(1020), batch[3].Number)
+	assert.Equal(t, 5, InvoiceModel.Count())
+
+	// every tenant has its own counters
+	other := &Invoice{Year: "2026"}
+	assert.NoError(t, InvoiceModel.ForTenant("acme").Create(other))
+	assert.Equal(t, int64(1000), other.Number)
+
+	n, err := c.CloneSession().DB("").C(monger.DefaultSequenceCollection).Count(context.Background(), bson.M{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+}
+
+func TestSoftDeleteTimestamps(t *testing.T) {
+	c := conn(t)
+	MemberModel := c.M("Member")
+
+	member := &Member{Username: "alice"}
+	assert.NoError(t, MemberModel.Create(member))
+	assert.False(t, member.CreatedAt.IsZero())
+
+	ctx := monger.WithActor(context.Background(), "admin")
+	assert.NoError(t, MemberModel.Where(bson.M{"_id": member.ID}).WithContext(ctx).Delete())
+
+	found := new(Member)
+	assert.NoError(t, MemberModel.WithContext(context.Background()).OnlyTrashed().Where(bson.M{"_id": member.ID}).FindOne(found))
+	if assert.NotNil(t, found.DeletedAt) {
+		assert.WithinDuration(t, time.Now(), *found.DeletedAt, time.Second)
+	}
+	assert.Equal(t, "admin", found.DeletedBy)
+	assert.False(t, found.Deleted)
+
+	// the conditions of the caller on deleted_at are kept
+	trashed := MemberModel.WithContext(context.Background()).OnlyTrashed()
+	assert.Equal(t, 1, trashed.Where(bson.M{"deleted_at": bson.M{"$gt": time.Now().Add(-time.Minute)}}).Count())
+	assert.Equal(t, 0, trashed.Where(bson.M{"deleted_at": bson.M{"$gt": time.Now().Add(time.Minute)}}).Count())
+	assert.Equal(t, 0, MemberModel.Where(bson.M{"deleted_at": bson.M{"$exists": true}}).Count())
+
+	assert.NoError(t, MemberModel.Restore(bson.M{"_id": member.ID}))
+	found = new(Member)
+	assert.NoError(t, MemberModel.FindByID(member.ID, found))
+	assert.Nil(t, found.DeletedAt)
+	assert.Empty(t, found.DeletedBy)
+
+	// a document created by an upsert is found like the created ones
+	id := bson.NewObjectId()
+	_, err := MemberModel.UpsertID(id, bson.M{"$set": bson.M{"username": "bob"}})
+	assert.NoError(t, err)
+	found = new(Member)
+	assert.NoError(t, MemberModel.FindByID(id, found))
+	assert.False(t, found.CreatedAt.IsZero())
+}
+
+func TestLegacySoftDeletes(t *testing.T) {
+	driver := memdb.New()
+	legacy := bson.M{"_id": bson.NewObjectId(), "username": "alice", "deleted": true}
+
+	c, err := monger.Connect(monger.UseDriver(driver), monger.DBName("monger_test"))
+	assert.NoError(t, err)
+	assert.NoError(t, c.BatchRegister(new(Member)))
+	assert.NoError(t, c.CloneSession().DB("").C("member").Insert(context.Background(), legacy))
+	assert.Equal(t, 1, c.M("Member").Where(nil).Count())
+
+	c, err = monger.Connect(
+		monger.ConnectionName("legacy"),
+		monger.UseDriver(driver),
+		monger.DBName("monger_test"),
+		monger.LegacySoftDeletes(),
+	)
+	assert.NoError(t, err)
+	defer c.Close()
+	assert.NoError(t, c.BatchRegister(new(Member)))
+	MemberModel := c.M("Member")
+	assert.Equal(t, 0, MemberModel.Where(nil).Count())
+	assert.Equal(t, 1, MemberModel.WithContext(context.Background()).OnlyTrashed().Where(nil).Count())
+
+	member := &Member{Username: "bob"}
+	assert.NoError(t, MemberModel.Create(member))
+	n, err := c.CloneSession().DB("").C("member").Count(context.Background(), bson.M{"deleted": bson.M{"$exists": true}, "username": "bob"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// a $nor of the caller is kept
+	assert.Equal(t, 1, MemberModel.Where(bson.M{"$nor": []bson.M{{"username": "alice"}}}).Count())
+	assert.Equal(t, 0, MemberModel.WithContext(context.Background()).OnlyTrashed().Where(bson.M{"$nor": []bson.M{{"username": "alice"}}}).Count())
+
+	assert.NoError(t, MemberModel.Delete(bson.M{"_id": member.ID}))
+	n, err = c.CloneSession().DB("").C("member").Count(context.Background(), bson.M{"deleted": true})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	assert.NoError(t, MemberModel.Restore(bson.M{}))
+	assert.Equal(t, 2, MemberModel.Where(nil).Count())
+}
+
+type Event struct {
+	monger.Schema `json:",inline" bson:",inline"`
+	Name          string    `json:"name" bson:"name"`
+	Created       time.Time `json:"created" bson:"created"`
+}
+
+func (e *Event) GetTimestamps() monger.Timestamps {
+	return monger.Timestamps{CreatedAt: "created", UpdatedAt: "-", DeletedAt: "-"}
+}
+
+func TestCustomTimestamps(t *testing.T) {
+	c := conn(t)
+	EventModel := c.M(new(Event))
+
+	event := &Event{Name: "launch"}
+	assert.NoError(t, EventModel.Create(event))
+	assert.False(t, event.Created.IsZero())
+	assert.True(t, event.CreatedAt.IsZero())
+
+	assert.NoError(t, EventModel.Update(bson.M{"_id": event.ID}, bson.M{"$set": bson.M{"name": "release"}}))
+	raw := bson.M{}
+	err := c.CloneSession().DB("").C("event").Find(context.Background(), bson.M{"_id": event.ID}, nil).One(&raw)
+	assert.NoError(t, err)
+	assert.Contains(t, raw, "created")
+	assert.NotContains(t, raw, "created_at")
+	assert.NotContains(t, raw, "updated_at")
+
+	// without DeletedAt a delete removes the document
+	assert.NoError(t, EventModel.Delete(bson.M{"_id": event.ID}))
+	assert.Equal(t, 0, EventModel.OffSoftDeletes().Count())
+}
+
+func TestSave(t *testing.T) {
+	c := conn(t)
+	MemberModel := c.M("Member")
+
+	member := &Member{Username: "alice", Age: 30}
+	assert.NoError(t, MemberModel.Save(member, monger.InsertIfNew()))
+	assert.False(t, member.IsUpdated())
+
+	found := new(Member)
+	assert.NoError(t, MemberModel.FindByID(member.ID, found))
+	assert.False(t, found.IsUpdated())
+
+	// a concurrent change of another field is kept
+	coll := c.CloneSession().DB("").C("member")
+	assert.NoError(t, coll.Update(context.Background(), bson.M{"_id": member.ID}, bson.M{"$set": bson.M{"username": "alicia"}}))
+
+	found.Age = 0
+	assert.True(t, found.IsUpdated())
+	assert.NoError(t, MemberModel.Save(found))
+	assert.False(t, found.IsUpdated())
+
+	raw := bson.M{}
+	assert.NoError(t, coll.Find(context.Background(), bson.M{"_id": member.ID}, nil).One(&raw))
+	assert.Equal(t, "alicia", raw["username"])
+	assert.NotContains(t, raw, "age")
+
+	// nothing changed, nothing is written
+	updatedAt := found.UpdatedAt
+	assert.NoError(t, MemberModel.Save(found))
+	assert.Equal(t, updatedAt, found.UpdatedAt)
+
+	// the copies of the documents found are saved
+	all := make([]Member, 0)
+	assert.NoError(t, MemberModel.FindAll(&all))
+	for _, m := range all {
+		m.Age = 99
+		assert.NoError(t, MemberModel.Save(&m))
+	}
+	assert.NoError(t, MemberModel.FindByID(member.ID, found))
+	assert.Equal(t, 99, found.Age)
+
+	ConversationModel := c.M(new(Conversation))
+	conversation := &Conversation{Topic: "billing"}
+	assert.NoError(t, ConversationModel.Create(conversation))
+	first, second := new(Conversation), new(Conversation)
+	assert.NoError(t, ConversationModel.FindByID(conversation.ID, first))
+	assert.NoError(t, ConversationModel.FindByID(conversation.ID, second))
+
+	first.Topic = "refunds"
+	assert.NoError(t, ConversationModel.Save(first))
+	assert.Equal(t, 1, first.Version)
+
+	second.Topic = "invoices"
+	assert.IsType(t, &monger.ConcurrentModificationError{}, ConversationModel.Save(second))
+	assert.Equal(t, 0, second.Version)
+
+	assert.IsType(t, &monger.InvalidIdError{}, MemberModel.Save(&Member{Username: "bob"}))
+}
+
+type Citizen struct {
+	monger.Schema `json:",inline" bson:",inline"`
+	Name          string `json:"name" bson:"name"`
+	NationalID    string `json:"national_id" bson:"national_id" monger:"encrypt=deterministic"`
+	Phone         string `json:"phone,omitempty" bson:"phone,omitempty" monger:"encrypt"`
+}
+
+func TestEncryptedFields(t *testing.T) {
+	keys := &monger.StaticKeys{
+		Current: "k1",
+		Keys:    map[string][]byte{"k1": []byte("0123456789abcdef0123456789abcdef")},
+	}
+	monger.SetKeyProvider(keys)
+	defer monger.SetKeyProvider(nil)
+
+	c := conn(t)
+	CitizenModel := c.M(new(Citizen))
+
+	citizen := &Citizen{Name: "ada", NationalID: "AB123", Phone: "+33 1 23"}
+	assert.NoError(t, CitizenModel.Create(citizen))
+	assert.Equal(t, "AB123", citizen.NationalID)
+
+	coll := c.CloneSession().DB("").C("citizen")
+	raw := bson.M{}
+	assert.NoError(t, coll.Find(context.Background(), bson.M{"_id": citizen.ID}, nil).One(&raw))
+	assert.True(t, strings.HasPrefix(raw["national_id"].(string), "menc:1:k1:"))
+	assert.NotContains(t, raw["phone"], "+33")
+
+	found := new(Citizen)
+	assert.NoError(t, CitizenModel.FindOne(found, bson.M{"national_id": "AB123"}))
+	assert.Equal(t, "+33 1 23", found.Phone)
+
+	found.Phone = "+33 4 56"
+	assert.NoError(t, CitizenModel.Save(found))
+	assert.NoError(t, coll.Find(context.Background(), bson.M{"_id": citizen.ID}, nil).One(&raw))
+	assert.NotContains(t, raw["phone"], "+33")
+
+	// the values of the old key are still read and matched after a rotation
+	keys.Keys["k2"] = []byte("fedcba9876543210fedcba9876543210")
+	keys.Current = "k2"
+	assert.NoError(t, CitizenModel.Create(&Citizen{Name: "bob", NationalID: "CD456"}))
+
+	citizens := make([]*Citizen, 0)
+	assert.NoError(t, CitizenModel.FindAll(&citizens, bson.M{"national_id": bson.M{"$in": []string{"AB123", "CD456"}}}))
+	assert.Len(t, citizens, 2)
+	assert.Equal(t, "+33 4 56", citizens[0].Phone)
+
+	assert.NoError(t, coll.Find(context.Background(), bson.M{"name": "bob"}, nil).One(&raw))
+	assert.True(t, strings.HasPrefix(raw["national_id"].(string), "menc:1:k2:"))
+
+	monger.SetKeyProvider(nil)
+	assert.IsType(t, &monger.EncryptionError{}, CitizenModel.FindOne(new(Citizen), bson.M{"name": "ada"}))
+
+	// a filter which can not be encrypted fails instead of matching nothing
+	assert.IsType(t, &monger.EncryptionError{}, CitizenModel.FindOne(new(Citizen), bson.M{"national_id": "AB123"}))
+	assert.IsType(t, &monger.EncryptionError{}, CitizenModel.Delete(bson.M{"national_id": "AB123"}))
+	assert.IsType(t, &monger.EncryptionError{}, CitizenModel.Update(bson.M{"national_id": "AB123"}, bson.M{"$set": bson.M{"name": "x"}}))
+	assert.Equal(t, 2, CitizenModel.Count())
+}
+
+type Issue struct {
+	monger.Schema `json:",inline" bson:",inline"`
+	Title         string   `json:"title" bson:"title" monger:"required,max=20"`
+	Priority      int      `json:"priority" bson:"priority" monger:"enum=1|2|3"`
+	Labels        []string `json:"labels,omitempty" bson:"labels,omitempty" monger:"max=2"`
+}
+
+func TestValidator(t *testing.T) {
+	ctx := context.Background()
+	c, err := monger.Connect(
+		monger.UseDriver(memdb.New()),
+		monger.DBName("monger_test"),
+		monger.WithValidation(monger.ValidationPolicy{}),
+	)
+	assert.NoError(t, err)
+	IssueModel, err := c.Register(new(Issue))
+	assert.NoError(t, err)
+
+	issue := &Issue{Title: "login fails", Priority: 1}
+	assert.NoError(t, IssueModel.Create(issue))
+	assert.NoError(t, IssueModel.Create(&Issue{Title: "no priority"}))
+
+	// the writes which skip the validation of monger are checked by the server
+	coll := c.CloneSession().DB("").C("issue")
+	invalid := []bson.M{
+		{"title": ""},
+		{"title": "wrong priority", "priority": 5},
+		{"title": "wrong type", "priority": "high"},
+		{"title": "too many labels", "labels": []string{"a", "b", "c"}},
+		{"priority": 2},
+	}
+	for _, doc := range invalid {
+		assert.IsType(t, &monger.DocumentValidationError{}, coll.Insert(ctx, doc), "%v", doc)
+	}
+	assert.NoError(t, coll.Insert(ctx, bson.M{"title": "raw", "priority": int64(3), "labels": []string{"a"}}))
+
+	err = coll.Update(ctx, bson.M{"_id": issue.ID}, bson.M{"$set": bson.M{"priority": 9}})
+	assert.IsType(t, &monger.DocumentValidationError{}, err)
+
+	// the validator is set once, registering the schema again keeps it
+	issues := coll.(monger.ValidatorManager)
+	schema := bson.M{"$jsonSchema": monger.GetSchemaStruct(new(Issue)).JSONSchema()}
+	assert.NoError(t, issues.SetValidator(ctx, monger.Validator{Schema: schema, Action: monger.ValidationActionWarn}))
+	_, err = c.Register(new(Issue))
+	assert.NoError(t, err)
+	assert.NoError(t, coll.Insert(ctx, bson.M{"title": ""}))
+
+	// a document stored before the validator only passes a moderate one
+	plain := conn(t)
+	raw := plain.CloneSession().DB("").C("issue")
+	assert.NoError(t, raw.Insert(ctx, bson.M{"_id": bson.NewObjectId(), "title": ""}))
+	PlainModel, err := plain.Register(new(Issue))
+	assert.NoError(t, err)
+	assert.NoError(t, PlainModel.EnsureValidator(ctx))
+
+	update := bson.M{"$set": bson.M{"labels": []string{"old"}}}
+	assert.IsType(t, &monger.DocumentValidationError{}, raw.Update(ctx, bson.M{"title": ""}, update))
+
+	manager := raw.(monger.ValidatorManager)
+	assert.NoError(t, manager.SetValidator(ctx, monger.Validator{Schema: schema, Level: monger.ValidationLevelModerate}))
+	assert.NoError(t, raw.Update(ctx, bson.M{"title": ""}, update))
+
+	assert.NoError(t, manager.SetValidator(ctx, monger.Validator{Schema: schema, Action: monger.ValidationActionWarn}))
+	assert.NoError(t, raw.Insert(ctx, bson.M{"title": ""}))
+
+	err = manager.SetValidator(ctx, monger.Validator{Schema: bson.M{"$jsonSchema": bson.M{"oneOf": []bson.M{}}}})
+	assert.Error(t, err)
+}
+
+type Alert struct {
+	monger.Schema `json:",inline" bson:",inline"`
+	Kind          string `json:"kind" bson:"kind" monger:"discriminator"`
+	Title         string `json:"title" bson:"title" monger:"required"`
+}
+
+type PushAlert struct {
+	Alert  `json:",inline" bson:",inline"`
+	Device string `json:"device" bson:"device" monger:"required,unique,sparse"`
+}
+
+func TestKindValidator(t *testing.T) {
+	ctx := context.Background()
+	c := indexConn(t, monger.WithValidation(monger.ValidationPolicy{}))
+	AlertModel := c.M(new(Alert))
+	PushModel, err := AlertModel.RegisterKind("push", new(PushAlert))
+	assert.NoError(t, err)
+
+	// the validator of the base model accepts the documents of each kind
+	push := &PushAlert{Device: "phone"}
+	push.Title = "disk full"
+	assert.NoError(t, PushModel.Create(push))
+	assert.NoError(t, AlertModel.Create(&Alert{Title: "cpu", Kind: "mail"}))
+
+	coll := c.CloneSession().DB("").C("alert")
+	for _, doc := range []bson.M{
+		{"kind": "push", "title": "no device"},
+		{"kind": "push", "device": "watch"},
+		{"kind": "mail"},
+	} {
+		assert.IsType(t, &monger.DocumentValidationError{}, coll.Insert(ctx, doc), "%v", doc)
+	}
+	assert.NoError(t, coll.Insert(ctx, bson.M{"kind": "push", "title": "raw", "device": "tablet"}))
+
+	// a subtype index which can not be built fails its registration
+	plain := conn(t)
+	raw := plain.CloneSession().DB("").C("alert")
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, raw.Insert(ctx, bson.M{"_id": bson.NewObjectId(), "kind": "push", "title": "dup", "device": "phone"}))
+	}
+	_, err = plain.M(new(Alert)).RegisterKind("push", new(PushAlert))
+	assert.IsType(t, &monger.DuplicateDocumentError{}, err)
+}
+
+type Season struct {
+	monger.Schema `json:",inline" bson:",inline"`
+	Name          string     `json:"name" bson:"name"`
+	Fixtures      []*Fixture `json:"fixtures,omitempty" bson:"fixtures,omitempty" monger:"hasMany,foreignKey=season_id"`
+}
+
+type Fixture struct {
+	monger.Schema `json:",inline" bson:",inline"`
+	SeasonID      bson.ObjectId `json:"season_id" bson:"season_id" monger:"requird"`
+}
+
+func TestStrictSchemas(t *testing.T) {
+	c, err := monger.Connect(
+		monger.UseDriver(memdb.New()),
+		monger.DBName("monger_test"),
+		monger.StrictSchemas(),
+	)
+	assert.NoError(t, err)
+
+	_, err = c.Register(new(Season))
+	lintErr, ok := err.(*monger.SchemaLintError)
+	assert.True(t, ok)
+	assert.Equal(t, []*monger.SchemaProblem{
+		{Schema: "Season", Field: "Fixtures", Message: "hasMany relation to Fixture which is not registered"},
+	}, lintErr.Problems)
+	_, err = c.Lookup(new(Season))
+	assert.IsType(t, &monger.NotRegisteredError{}, err)
+
+	// the schemas registered together are linted together
+	err = c.BatchRegister(new(Season), new(Fixture))
+	assert.IsType(t, &monger.SchemaLintError{}, err)
+	assert.Contains(t, err.Error(), `Fixture.SeasonID: unknown monger tag key "requird"`)
+	assert.NotContains(t, err.Error(), "not registered")
+
+	// the problems are only logged by default
+	plain := conn(t)
+	assert.NoError(t, plain.BatchRegister(new(Season), new(Fixture)))
+	_, err = plain.Lookup(new(Season))
+	assert.NoError(t, err)
+}
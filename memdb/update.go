@@ -0,0 +1,180 @@
+package memdb
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// applyUpdate modifies doc with an update document, an update without
+// operators replaces the whole document but its _id.
+func applyUpdate(doc bson.M, update bson.M, insert bool) (bson.M, error) {
+	if !isOperatorDoc(update) {
+		for k := range update {
+			if strings.HasPrefix(k, "$") {
+				return nil, fmt.Errorf("memdb: an update can not mix operators and fields")
+			}
+		}
+
+		replacement := copyDoc(update)
+		if id, ok := doc["_id"]; ok {
+			if other, ok := replacement["_id"]; ok && !equal(id, other) {
+				return nil, fmt.Errorf("memdb: the _id field can not be modified")
+			}
+			replacement["_id"] = id
+		}
+		return replacement, nil
+	}
+
+	for op, arg := range update {
+		fields, ok := asDoc(arg)
+		if !ok {
+			return nil, fmt.Errorf("memdb: %s needs a document", op)
+		}
+
+		for field, value := range fields {
+			keys := splitPath(field)
+			if field == "_id" && op != "$setOnInsert" {
+				if id, ok := doc["_id"]; ok && (op != "$set" || !equal(id, value)) {
+					return nil, fmt.Errorf("memdb: the _id field can not be modified")
+				}
+			}
+
+			if err := applyOperator(doc, op, keys, value, insert); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return doc, nil
+}
+
+func applyOperator(doc bson.M, op string, keys []string, value interface{}, insert bool) error {
+	current, found := getPath(doc, keys)
+
+	switch op {
+	case "$set":
+		setPath(doc, keys, copyValue(value))
+	case "$setOnInsert":
+		if insert {
+			setPath(doc, keys, copyValue(value))
+		}
+	case "$unset":
+		unsetPath(doc, keys)
+	case "$inc":
+		delta, ok := toFloat(value)
+		if !ok {
+			return fmt.Errorf("memdb: $inc needs a number")
+		}
+		if !found {
+			setPath(doc, keys, value)
+			return nil
+		}
+		n, ok := toFloat(current)
+		if !ok {
+			return fmt.Errorf("memdb: $inc on a non numeric field %s", strings.Join(keys, "."))
+		}
+		setPath(doc, keys, addNumbers(current, value, n+delta))
+	case "$min", "$max":
+		if !found || (op == "$min" && compare(value, current) < 0) || (op == "$max" && compare(value, current) > 0) {
+			setPath(doc, keys, copyValue(value))
+		}
+	case "$push", "$addToSet":
+		items, ok := current.([]interface{})
+		if found && !ok {
+			return fmt.Errorf("memdb: %s on a non array field %s", op, strings.Join(keys, "."))
+		}
+
+		values := []interface{}{value}
+		if spec, ok := asDoc(value); ok {
+			if each, ok := spec["$each"].([]interface{}); ok {
+				values = each
+			}
+		}
+
+		for _, v := range values {
+			if op == "$addToSet" && contains(items, v) {
+				continue
+			}
+			items = append(items, copyValue(v))
+		}
+		setPath(doc, keys, items)
+	case "$pull":
+		items, ok := current.([]interface{})
+		if !ok {
+			return nil
+		}
+
+		kept := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			var (
+				matched bool
+				err     error
+			)
+			if cond, isDoc := asDoc(value); isDoc && isOperatorDoc(cond) {
+				matched, err = matchField(bson.M{"v": item}, "v", cond)
+			} else if cond, isDoc := asDoc(value); isDoc {
+				if sub, ok := item.(bson.M); ok {
+					matched, err = match(sub, cond, nil)
+				}
+			} else {
+				matched = equal(item, value)
+			}
+			if err != nil {
+				return err
+			}
+			if !matched {
+				kept = append(kept, item)
+			}
+		}
+		setPath(doc, keys, kept)
+	default:
+		return fmt.Errorf("memdb: unsupported update operator %s", op)
+	}
+
+	return nil
+}
+
+// addNumbers keeps the integer type of an $inc when both operands are integers
+func addNumbers(a, b interface{}, sum float64) interface{} {
+	switch a.(type) {
+	case float32, float64:
+		return sum
+	}
+
+	switch b.(type) {
+	case float32, float64:
+		return sum
+	}
+
+	if _, ok := a.(int64); ok {
+		return int64(sum)
+	}
+
+	if _, ok := b.(int64); ok {
+		return int64(sum)
+	}
+
+	return int(sum)
+}
+
+// upsertDoc builds the document inserted by an upsert from the equality
+// fields of its filter.
+func upsertDoc(filter bson.M) bson.M {
+	doc := bson.M{}
+	for k, v := range filter {
+		if strings.HasPrefix(k, "$") || isOperatorDoc(v) {
+			if ops, ok := asDoc(v); ok {
+				if eq, ok := ops["$eq"]; ok {
+					setPath(doc, splitPath(k), copyValue(eq))
+				}
+			}
+			continue
+		}
+
+		setPath(doc, splitPath(k), copyValue(v))
+	}
+
+	return doc
+}
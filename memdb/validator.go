@@ -0,0 +1,290 @@
+package memdb
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+	"unicode/utf8"
+
+	"github.com/iron-kit/monger"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// SetValidator stores the validator, the writes which follow are checked
+// against its $jsonSchema. Like a server it leaves the stored documents as
+// they are.
+func (c *collection) SetValidator(ctx context.Context, validator monger.Validator) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	doc, err := toDoc(validator.Schema)
+	if err != nil {
+		return err
+	}
+
+	schema, ok := asDoc(doc["$jsonSchema"])
+	if !ok || len(doc) != 1 {
+		return fmt.Errorf("memdb: only $jsonSchema validators are supported")
+	}
+
+	if err := checkKeywords(schema); err != nil {
+		return err
+	}
+
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+
+	validator.Schema = schema
+	c.server.validators[c.key()] = validator
+	if _, ok := c.server.collections[c.key()]; !ok {
+		c.server.collections[c.key()] = make([]bson.M, 0)
+	}
+
+	return nil
+}
+
+// validate checks a written document against the validator of the
+// collection, before is the document an update replaces, nil for an
+// insert. The write lock must be held.
+func (s *server) validate(key string, before bson.M, doc bson.M) error {
+	validator, ok := s.validators[key]
+	if !ok || validator.Action == monger.ValidationActionWarn {
+		return nil
+	}
+
+	if before != nil && validator.Level == monger.ValidationLevelModerate {
+		if valid, err := validSchema(before, validator.Schema); err != nil || !valid {
+			return err
+		}
+	}
+
+	valid, err := validSchema(doc, validator.Schema)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return &monger.DocumentValidationError{
+			MongerQueryError: monger.NewError(fmt.Sprintf("memdb: document %v failed the validation of %s", doc["_id"], key)),
+		}
+	}
+
+	return nil
+}
+
+// validSchema tells if a value matches a $jsonSchema, it supports the
+// keywords monger generates.
+func validSchema(value interface{}, schema bson.M) (bool, error) {
+	for _, keyword := range sortedKeys(schema) {
+		arg := schema[keyword]
+		ok := true
+
+		switch keyword {
+		case "bsonType":
+			ok = false
+			for _, name := range toList(arg) {
+				if isBSONType(value, fmt.Sprint(name)) {
+					ok = true
+					break
+				}
+			}
+		case "required":
+			doc, isDoc := value.(bson.M)
+			for _, column := range toList(arg) {
+				if _, found := doc[fmt.Sprint(column)]; isDoc && !found {
+					ok = false
+				}
+			}
+		case "properties":
+			properties, isDoc := asDoc(arg)
+			if !isDoc {
+				return false, fmt.Errorf("memdb: $jsonSchema properties must be a document")
+			}
+			doc, _ := value.(bson.M)
+			for _, column := range sortedKeys(properties) {
+				v, found := doc[column]
+				if !found {
+					continue
+				}
+				property, _ := asDoc(properties[column])
+				valid, err := validSchema(v, property)
+				if err != nil {
+					return false, err
+				}
+				ok = ok && valid
+			}
+		case "items":
+			items, _ := asDoc(arg)
+			list, _ := value.([]interface{})
+			for _, item := range list {
+				valid, err := validSchema(item, items)
+				if err != nil {
+					return false, err
+				}
+				ok = ok && valid
+			}
+		case "anyOf":
+			ok = false
+			for _, item := range toList(arg) {
+				sub, _ := asDoc(item)
+				valid, err := validSchema(value, sub)
+				if err != nil {
+					return false, err
+				}
+				ok = ok || valid
+			}
+		case "not":
+			sub, _ := asDoc(arg)
+			valid, err := validSchema(value, sub)
+			if err != nil {
+				return false, err
+			}
+			ok = !valid
+		case "enum":
+			ok = false
+			for _, option := range toList(arg) {
+				if equal(value, option) {
+					ok = true
+					break
+				}
+			}
+		case "minimum", "maximum":
+			limit, _ := toFloat(arg)
+			if n, isNumber := toFloat(value); isNumber {
+				ok = (keyword == "minimum" && n >= limit) || (keyword == "maximum" && n <= limit)
+			}
+		case "minLength", "maxLength", "minItems", "maxItems", "minProperties", "maxProperties":
+			limit, _ := toFloat(arg)
+			if n, measured := measure(value, keyword); measured {
+				if keyword[:3] == "min" {
+					ok = n >= int(limit)
+				} else {
+					ok = n <= int(limit)
+				}
+			}
+		case "pattern":
+			re, err := regexp.Compile(fmt.Sprint(arg))
+			if err != nil {
+				return false, fmt.Errorf("memdb: invalid $jsonSchema pattern: %v", err)
+			}
+			if s, isString := value.(string); isString {
+				ok = re.MatchString(s)
+			}
+		}
+
+		if !ok {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// checkKeywords refuses the schemas using keywords validSchema ignores
+func checkKeywords(schema bson.M) error {
+	for keyword, arg := range schema {
+		switch keyword {
+		case "properties":
+			properties, ok := asDoc(arg)
+			if !ok {
+				return fmt.Errorf("memdb: $jsonSchema properties must be a document")
+			}
+			for _, property := range properties {
+				if err := checkSubschemas(property); err != nil {
+					return err
+				}
+			}
+		case "items", "anyOf", "not":
+			if err := checkSubschemas(arg); err != nil {
+				return err
+			}
+		case "bsonType", "required", "enum", "minimum", "maximum", "minLength", "maxLength",
+			"minItems", "maxItems", "minProperties", "maxProperties", "pattern", "title", "description":
+		default:
+			return fmt.Errorf("memdb: unsupported $jsonSchema keyword %s", keyword)
+		}
+	}
+
+	return nil
+}
+
+// checkSubschemas checks a schema or a list of schemas
+func checkSubschemas(v interface{}) error {
+	for _, item := range toList(v) {
+		schema, ok := asDoc(item)
+		if !ok {
+			return fmt.Errorf("memdb: a $jsonSchema subschema must be a document")
+		}
+		if err := checkKeywords(schema); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// measure returns the length checked by a keyword, false when the keyword
+// does not apply to the value.
+func measure(value interface{}, keyword string) (int, bool) {
+	switch v := value.(type) {
+	case string:
+		if keyword == "minLength" || keyword == "maxLength" {
+			return utf8.RuneCountInString(v), true
+		}
+	case []interface{}:
+		if keyword == "minItems" || keyword == "maxItems" {
+			return len(v), true
+		}
+	case bson.M:
+		if keyword == "minProperties" || keyword == "maxProperties" {
+			return len(v), true
+		}
+	}
+
+	return 0, false
+}
+
+func toList(v interface{}) []interface{} {
+	if list, ok := v.([]interface{}); ok {
+		return list
+	}
+
+	return []interface{}{v}
+}
+
+// isBSONType tells if a decoded value is of a $jsonSchema bsonType
+func isBSONType(value interface{}, name string) bool {
+	switch value.(type) {
+	case nil:
+		return name == "null"
+	case bool:
+		return name == "bool"
+	case int, int8, int16, int32:
+		return name == "int" || name == "number"
+	case int64:
+		return name == "long" || name == "number"
+	case float32, float64:
+		return name == "double" || name == "number"
+	case bson.Decimal128:
+		return name == "decimal" || name == "number"
+	case string:
+		return name == "string"
+	case bson.M:
+		return name == "object"
+	case []interface{}:
+		return name == "array"
+	case []byte, bson.Binary:
+		return name == "binData"
+	case bson.ObjectId:
+		return name == "objectId"
+	case time.Time:
+		return name == "date"
+	case bson.RegEx:
+		return name == "regex"
+	case bson.MongoTimestamp:
+		return name == "timestamp"
+	}
+
+	return false
+}
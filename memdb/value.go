@@ -0,0 +1,427 @@
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// normalize converts v to the values mgo's bson decodes to: bson.M
+// documents, []interface{} arrays and bson scalars, so GetBSON hooks and
+// custom types behave like they do on a server. bson.D keeps its order so
+// sort specifications survive.
+func normalize(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case nil:
+		return nil, nil
+	case bson.D:
+		doc := make(bson.D, 0, len(t))
+		for _, elem := range t {
+			value, err := normalize(elem.Value)
+			if err != nil {
+				return nil, err
+			}
+			doc = append(doc, bson.DocElem{Name: elem.Name, Value: value})
+		}
+		return doc, nil
+	case bson.M:
+		return normalizeMap(t)
+	case map[string]interface{}:
+		return normalizeMap(t)
+	case []byte:
+		return t, nil
+	}
+
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		items := make([]interface{}, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			item, err := normalize(rv.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+
+	b, err := bson.Marshal(bson.M{"v": v})
+	if err != nil {
+		return nil, err
+	}
+
+	out := bson.M{}
+	if err := bson.Unmarshal(b, &out); err != nil {
+		return nil, err
+	}
+
+	return out["v"], nil
+}
+
+func normalizeMap(m map[string]interface{}) (bson.M, error) {
+	doc := make(bson.M, len(m))
+	for k, v := range m {
+		value, err := normalize(v)
+		if err != nil {
+			return nil, err
+		}
+		doc[k] = value
+	}
+
+	return doc, nil
+}
+
+// toDoc normalizes a document, nil is an empty document
+func toDoc(v interface{}) (bson.M, error) {
+	n, err := normalize(v)
+	if err != nil {
+		return nil, err
+	}
+
+	switch doc := n.(type) {
+	case nil:
+		return bson.M{}, nil
+	case bson.M:
+		return doc, nil
+	case bson.D:
+		return doc.Map(), nil
+	}
+
+	return nil, fmt.Errorf("memdb: %T is not a document", v)
+}
+
+// decode stores doc into out the same way a driver decodes a server reply
+func decode(doc bson.M, out interface{}) error {
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	return bson.Unmarshal(b, out)
+}
+
+func copyValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case bson.M:
+		return copyDoc(t)
+	case bson.D:
+		doc := make(bson.D, len(t))
+		for i, elem := range t {
+			doc[i] = bson.DocElem{Name: elem.Name, Value: copyValue(elem.Value)}
+		}
+		return doc
+	case []interface{}:
+		items := make([]interface{}, len(t))
+		for i, item := range t {
+			items[i] = copyValue(item)
+		}
+		return items
+	}
+
+	return v
+}
+
+func copyDoc(doc bson.M) bson.M {
+	c := make(bson.M, len(doc))
+	for k, v := range doc {
+		c[k] = copyValue(v)
+	}
+
+	return c
+}
+
+func asDoc(v interface{}) (bson.M, bool) {
+	switch t := v.(type) {
+	case bson.M:
+		return t, true
+	case bson.D:
+		return t.Map(), true
+	}
+
+	return nil, false
+}
+
+func isOperatorDoc(v interface{}) bool {
+	doc, ok := asDoc(v)
+	if !ok || len(doc) == 0 {
+		return false
+	}
+
+	for k := range doc {
+		if !strings.HasPrefix(k, "$") {
+			return false
+		}
+	}
+
+	return true
+}
+
+func splitPath(path string) []string {
+	return strings.Split(path, ".")
+}
+
+// lookup returns the values found at path, arrays met on the way are
+// traversed the way query filters do.
+func lookup(v interface{}, path []string) []interface{} {
+	if len(path) == 0 {
+		return []interface{}{v}
+	}
+
+	switch t := v.(type) {
+	case bson.M:
+		child, ok := t[path[0]]
+		if !ok {
+			return nil
+		}
+		return lookup(child, path[1:])
+	case []interface{}:
+		if i, err := strconv.Atoi(path[0]); err == nil {
+			if i >= 0 && i < len(t) {
+				return lookup(t[i], path[1:])
+			}
+			return nil
+		}
+
+		values := make([]interface{}, 0)
+		for _, item := range t {
+			if _, ok := item.(bson.M); ok {
+				values = append(values, lookup(item, path)...)
+			}
+		}
+		return values
+	}
+
+	return nil
+}
+
+// resolve returns the value of an aggregation field path, arrays met on
+// the way become arrays of the values found in their documents.
+func resolve(v interface{}, path []string) (interface{}, bool) {
+	if len(path) == 0 {
+		return v, true
+	}
+
+	switch t := v.(type) {
+	case bson.M:
+		child, ok := t[path[0]]
+		if !ok {
+			return nil, false
+		}
+		return resolve(child, path[1:])
+	case []interface{}:
+		values := make([]interface{}, 0)
+		for _, item := range t {
+			if value, ok := resolve(item, path); ok {
+				values = append(values, value)
+			}
+		}
+		return values, true
+	}
+
+	return nil, false
+}
+
+// setPath sets value at path, creating the documents on the way
+func setPath(doc bson.M, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		child, ok := doc[key].(bson.M)
+		if !ok {
+			child = bson.M{}
+			doc[key] = child
+		}
+		doc = child
+	}
+
+	doc[path[len(path)-1]] = value
+}
+
+func unsetPath(doc bson.M, path []string) {
+	for _, key := range path[:len(path)-1] {
+		child, ok := doc[key].(bson.M)
+		if !ok {
+			return
+		}
+		doc = child
+	}
+
+	delete(doc, path[len(path)-1])
+}
+
+func getPath(doc bson.M, path []string) (interface{}, bool) {
+	for _, key := range path[:len(path)-1] {
+		child, ok := doc[key].(bson.M)
+		if !ok {
+			return nil, false
+		}
+		doc = child
+	}
+
+	v, ok := doc[path[len(path)-1]]
+	return v, ok
+}
+
+// typeOrder is the BSON comparison order of the types
+func typeOrder(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 1
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return 2
+	case string, bson.Symbol:
+		return 3
+	case bson.M, bson.D:
+		return 4
+	case []interface{}:
+		return 5
+	case []byte, bson.Binary:
+		return 6
+	case bson.ObjectId:
+		return 7
+	case bool:
+		return 8
+	case time.Time:
+		return 9
+	case bson.MongoTimestamp:
+		return 10
+	case bson.RegEx:
+		return 11
+	}
+
+	return 12
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+
+	return 0, false
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+
+	return 0
+}
+
+// compare orders two values like the server does, values of different
+// types are ordered by type.
+func compare(a, b interface{}) int {
+	ta, tb := typeOrder(a), typeOrder(b)
+	if ta != tb {
+		return sign(ta - tb)
+	}
+
+	switch ta {
+	case 2:
+		fa, _ := toFloat(a)
+		fb, _ := toFloat(b)
+		switch {
+		case fa < fb || (math.IsNaN(fa) && !math.IsNaN(fb)):
+			return -1
+		case fa > fb || (!math.IsNaN(fa) && math.IsNaN(fb)):
+			return 1
+		}
+		return 0
+	case 3:
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	case 4:
+		da, _ := asDoc(a)
+		db, _ := asDoc(b)
+		return compareDocs(da, db)
+	case 5:
+		aa, ab := a.([]interface{}), b.([]interface{})
+		for i := 0; i < len(aa) && i < len(ab); i++ {
+			if c := compare(aa[i], ab[i]); c != 0 {
+				return c
+			}
+		}
+		return sign(len(aa) - len(ab))
+	case 6:
+		return bytes.Compare(toBytes(a), toBytes(b))
+	case 7:
+		return strings.Compare(string(a.(bson.ObjectId)), string(b.(bson.ObjectId)))
+	case 8:
+		ba, bb := a.(bool), b.(bool)
+		switch {
+		case ba == bb:
+			return 0
+		case bb:
+			return -1
+		}
+		return 1
+	case 9:
+		ta, tb := a.(time.Time), b.(time.Time)
+		switch {
+		case ta.Before(tb):
+			return -1
+		case ta.After(tb):
+			return 1
+		}
+		return 0
+	case 10:
+		return sign(int(a.(bson.MongoTimestamp) - b.(bson.MongoTimestamp)))
+	}
+
+	if reflect.DeepEqual(a, b) {
+		return 0
+	}
+
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func compareDocs(a, b bson.M) int {
+	ka, kb := sortedKeys(a), sortedKeys(b)
+	for i := 0; i < len(ka) && i < len(kb); i++ {
+		if c := strings.Compare(ka[i], kb[i]); c != 0 {
+			return c
+		}
+		if c := compare(a[ka[i]], b[kb[i]]); c != 0 {
+			return c
+		}
+	}
+
+	return sign(len(ka) - len(kb))
+}
+
+func sortedKeys(doc bson.M) []string {
+	keys := make([]string, 0, len(doc))
+	for k := range doc {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func toBytes(v interface{}) []byte {
+	switch t := v.(type) {
+	case []byte:
+		return t
+	case bson.Binary:
+		return t.Data
+	}
+
+	return nil
+}
+
+func equal(a, b interface{}) bool {
+	return typeOrder(a) == typeOrder(b) && compare(a, b) == 0
+}
@@ -1,7 +1,10 @@
 package monger
 
 import (
-	"gopkg.in/mgo.v2"
+	"context"
+	"reflect"
+	"sync"
+
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -10,14 +13,15 @@ Model is mongodb actoin model
 */
 type Model interface {
 	OffSoftDeletes() Query
-	UpsertID(id interface{}, data interface{}) (*mgo.ChangeInfo, error)
-	Upsert(condition bson.M, data interface{}) (*mgo.ChangeInfo, error)
+	UpsertID(id interface{}, data interface{}) (*ChangeInfo, error)
+	Upsert(condition bson.M, data interface{}) (*ChangeInfo, error)
 	Update(condition bson.M, data interface{}) error
 	Count(condition ...bson.M) int
 	Create(doc interface{}) error
+	Save(doc interface{}, options ...SaveOption) error
 	FindOne(doc interface{}, where ...bson.M) error
 	FindAll(doc interface{}, where ...bson.M) error
-	FindByID(id bson.ObjectId, doc interface{}) error
+	FindByID(id interface{}, doc interface{}) error
 	Where(...bson.M) Query
 	Select(...bson.M) Query
 	Aggregate([]bson.M) Query
@@ -28,23 +32,64 @@ type Model interface {
 	Restore(bson.M) error
 	getCollectionName() string
 	getSchemaStruct() *SchemaStruct
-	Collection() *mgo.Collection
+	Collection() Collection
+	WithContext(ctx context.Context) Query
+	ForTenant(tenant string) Query
+	SetReadPreference(readPreference *ReadPreference)
+	SetWriteConcern(writeConcern *WriteConcern)
+	EnsureIndexes(ctx context.Context) error
+	DiffIndexes(ctx context.Context) (*IndexDiff, error)
+	EnsureValidator(ctx context.Context) error
+	RegisterKind(kind string, schema Schemer) (Model, error)
 }
 
 type model struct {
 	schema         Schemer
 	schemaStruct   *SchemaStruct
-	collection     *mgo.Collection
+	collection     Collection
 	connection     Connection
 	collectionName string
+	readPreference *ReadPreference
+	writeConcern   *WriteConcern
+	// schemaStructOnce makes the lazy schema struct safe for goroutines
+	schemaStructOnce sync.Once
+	indexes          modelIndexes
+	validation       modelValidation
+	// kinds are the subtypes of the base model, kind is the discriminator
+	// of a subtype model
+	kinds *modelKinds
+	kind  string
 }
 
-func (m *model) Collection() *mgo.Collection {
+func (m *model) Collection() Collection {
 	return m.collection
 }
 
+// WithContext returns a query bound to ctx, use it to run any model
+// operation with cancellation and deadlines.
+func (m *model) WithContext(ctx context.Context) Query {
+	return m.query().WithContext(ctx)
+}
+
+// ForTenant returns a query routed to tenant, see WithTenancy
+func (m *model) ForTenant(tenant string) Query {
+	return m.query().ForTenant(tenant)
+}
+
+// SetReadPreference overrides the read preference of the connection for the
+// model, set it before the model is used by other goroutines.
+func (m *model) SetReadPreference(readPreference *ReadPreference) {
+	m.readPreference = readPreference
+}
+
+// SetWriteConcern overrides the write concern of the connection for the
+// model, set it before the model is used by other goroutines.
+func (m *model) SetWriteConcern(writeConcern *WriteConcern) {
+	m.writeConcern = writeConcern
+}
+
 func (m *model) Restore(condition bson.M) error {
-	return m.query().Where(condition).Restore()
+	return m.query().OnlyTrashed().Where(condition).Restore()
 }
 
 func (m *model) Delete(condition bson.M) error {
@@ -68,14 +113,23 @@ func (m *model) ForceDeleteAll(condition bson.M) error {
 }
 
 func (m *model) query() Query {
-	return newQuery(m.collection, m.getSchemaStruct())
+	q := newQuery(m.collection, m.getSchemaStruct()).(*query)
+	q.kinds = m.kinds
+	q.kind = m.kind
+	q.where = q.kindFilter()
+
+	return q.
+		ReadPreference(m.readPreference).
+		WriteConcern(m.writeConcern)
 }
 
 func (m *model) getSchemaStruct() *SchemaStruct {
-	if m.schemaStruct == nil {
-		// m.schemaStruct = getStructInfoOfSchema(m.schema, m.connection)
-		m.schemaStruct = GetSchemaStruct(m.schema)
-	}
+	m.schemaStructOnce.Do(func() {
+		if m.schemaStruct == nil {
+			// m.schemaStruct = getStructInfoOfSchema(m.schema, m.connection)
+			m.schemaStruct = GetSchemaStruct(m.schema)
+		}
+	})
 
 	return m.schemaStruct
 }
@@ -84,11 +138,11 @@ func (m *model) OffSoftDeletes() Query {
 	return m.query().OffSoftDeletes()
 }
 
-func (m *model) UpsertID(id interface{}, data interface{}) (*mgo.ChangeInfo, error) {
+func (m *model) UpsertID(id interface{}, data interface{}) (*ChangeInfo, error) {
 	return m.query().UpsertID(id, data)
 }
 
-func (m *model) Upsert(condition bson.M, data interface{}) (*mgo.ChangeInfo, error) {
+func (m *model) Upsert(condition bson.M, data interface{}) (*ChangeInfo, error) {
 	return m.query().Upsert(condition, data)
 }
 
@@ -105,6 +159,11 @@ func (m *model) Count(condition ...bson.M) int {
 	return q.Count()
 }
 
+// Save writes the changes of a document, see Query.Save
+func (m *model) Save(doc interface{}, options ...SaveOption) error {
+	return m.query().Save(doc, options...)
+}
+
 func (m *model) Create(doc interface{}) error {
 	// panic("not implemented")
 	return m.query().Create(doc)
@@ -128,7 +187,14 @@ func (m *model) FindAll(doc interface{}, where ...bson.M) error {
 	return q.FindAll(doc)
 }
 
-func (m *model) FindByID(id bson.ObjectId, doc interface{}) error {
+// FindByID finds the document of a primary key of the type of the _id
+// field, an ObjectId is accepted as a hex string.
+func (m *model) FindByID(id interface{}, doc interface{}) error {
+	id, err := toID(m.getSchemaStruct(), id)
+	if err != nil {
+		return err
+	}
+
 	return m.query().Where(bson.M{"_id": id}).FindOne(doc)
 }
 
@@ -159,14 +225,31 @@ func (m *model) getCollectionName() string {
 func newModel(connection *connection, schema Schemer) Model {
 	collectionName := getCollectionName(schema)
 
-	collection := connection.Session.DB("").C(collectionName)
-	return &model{
+	collection := &sessionCollection{
+		conn:   connection,
+		db:     getDatabaseName(schema),
+		name:   collectionName,
+		shared: isTenantShared(schema),
+	}
+	mdl := &model{
 		schema: schema,
 		// schemaStruct:   getStructInfoOfSchema(schema, connection),
 		connection:     connection,
 		collection:     collection,
 		collectionName: collectionName,
+		kinds:          &modelKinds{byKind: make(map[string]reflect.Type)},
 	}
+	collection.setup = mdl.setupCollection
+
+	return mdl
+}
+
+func getDatabaseName(schema interface{}) string {
+	if dbGetter, ok := schema.(SchemaDatabaseGetter); ok {
+		return dbGetter.GetDatabaseName()
+	}
+
+	return ""
 }
 
 func getCollectionName(schema interface{}) string {
@@ -0,0 +1,132 @@
+package monger_test
+
+import (
+	"testing"
+
+	"github.com/iron-kit/monger"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func modelConn(t *testing.T) monger.Connection {
+	c := memConn(t)
+	assert.NoError(t, c.BatchRegister(
+		new(monger.Member),
+		new(monger.Profile),
+		new(monger.Task),
+		new(monger.Conversation),
+	))
+
+	return c
+}
+
+func TestModelUpdate(t *testing.T) {
+	MemberModel := modelConn(t).M("Member")
+
+	m := &monger.Member{Username: "alice"}
+	assert.NoError(t, MemberModel.Create(m))
+	assert.NoError(t, MemberModel.Update(bson.M{"_id": m.ID}, &monger.Member{Username: "AAlicss"}))
+
+	a := new(monger.Member)
+	assert.NoError(t, MemberModel.FindByID(m.ID, a))
+	assert.Equal(t, "AAlicss", a.Username)
+}
+
+func TestModelRegsiterOK(t *testing.T) {
+	c := memConn(t)
+	assert.NoError(t, c.BatchRegister(
+		new(monger.Member),
+		new(monger.Profile),
+	))
+
+	assert.NotNil(t, c.M("Member"))
+	assert.NotNil(t, c.M("Profile"))
+}
+
+func TestCreateFuncOK(t *testing.T) {
+	c := modelConn(t)
+
+	member := &monger.Member{
+		Username: "alixezz",
+		Password: "123456",
+	}
+	assert.NoError(t, c.M("Member").Create(member))
+
+	profile := &monger.Profile{
+		Avatar:   "Hello",
+		Nickname: "nova",
+		UserID:   member.ID,
+	}
+	assert.NoError(t, c.M("Profile").Create(profile))
+}
+
+func TestCreateTaskOK(t *testing.T) {
+	task := &monger.Task{TaskName: "Task1"}
+	assert.NoError(t, modelConn(t).M("Task").Create(task))
+	assert.True(t, task.ID.Valid())
+}
+
+func TestDeepPopulateOK(t *testing.T) {
+	c := modelConn(t)
+
+	task := &monger.Task{TaskName: "Task1"}
+	assert.NoError(t, c.M("Task").Create(task))
+	member := &monger.Member{Username: "alice", TaskID: task.ID}
+	assert.NoError(t, c.M("Member").Create(member))
+	assert.NoError(t, c.M("Profile").Create(&monger.Profile{Nickname: "nova", UserID: member.ID}))
+
+	found := new(monger.Task)
+	err := c.M("Task").
+		Where(bson.M{"_id": task.ID}).
+		Populate("Member", "Member.Profile").
+		FindOne(found)
+	assert.NoError(t, err)
+
+	assert.Equal(t, task.ID, found.ID)
+	if assert.NotNil(t, found.Member) {
+		assert.Equal(t, member.ID, found.Member.ID)
+		if assert.NotNil(t, found.Member.Profile) {
+			assert.Equal(t, "nova", found.Member.Profile.Nickname)
+		}
+	}
+}
+
+func TestPopulateFuncOK(t *testing.T) {
+	c := modelConn(t)
+
+	member := &monger.Member{Username: "alice"}
+	assert.NoError(t, c.M("Member").Create(member))
+	assert.NoError(t, c.M("Profile").Create(&monger.Profile{Nickname: "nova", UserID: member.ID}))
+
+	found := new(monger.Member)
+	err := c.M("Member").Where(bson.M{"_id": member.ID}).Populate("Profile").FindOne(found)
+	assert.NoError(t, err)
+
+	assert.Equal(t, member.ID, found.ID)
+	if assert.NotNil(t, found.Profile) {
+		assert.Equal(t, "nova", found.Profile.Nickname)
+	}
+}
+
+func TestArrayPopulateFuncOK(t *testing.T) {
+	c := modelConn(t)
+
+	member := &monger.Member{Username: "alice"}
+	assert.NoError(t, c.M("Member").Create(member))
+	assert.NoError(t, c.M("Profile").Create(&monger.Profile{Nickname: "nova", UserID: member.ID}))
+
+	conversation := &monger.Conversation{
+		Kind:    "simple",
+		Members: []monger.ConversationMember{{UserID: member.ID, Nickname: "al"}},
+	}
+	assert.NoError(t, c.M("Conversation").Create(conversation))
+
+	found := new(monger.Conversation)
+	err := c.M("Conversation").
+		Where(bson.M{"_id": conversation.ID}).
+		Populate("Members", "Members.User", "Members.User.Profile").
+		FindOne(found)
+	assert.NoError(t, err)
+
+	assert.Equal(t, conversation.ID, found.ID)
+}
@@ -1,7 +1,23 @@
 package monger
 
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// DefaultConnectionName is the name of a connection without ConnectionName
+const DefaultConnectionName = "default"
+
+// connections are the named connections opened by Connect
+var connections = struct {
+	sync.RWMutex
+	byName map[string]*connection
+}{byName: make(map[string]*connection)}
+
 func newConfigs(opts ...ConfigOption) *Config {
 	config := Config{
+		Name:  DefaultConnectionName,
 		Hosts: []string{"localhost"},
 	}
 
@@ -14,10 +30,23 @@ func newConfigs(opts ...ConfigOption) *Config {
 
 /*
 Connect is the function to connect MongoDB with mgo.v2 deiver
+
+The connection is registered by its name. A connection without
+ConnectionName replaces the previous default one in the registry, which
+stays open for its holders. Connecting again with the name of an open
+named connection fails, close it first:
+
+	analytics, err := monger.Connect(
+		monger.ConnectionName("analytics"),
+		monger.URI("mongodb://analytics.example.com/analytics"),
+	)
 */
 func Connect(opts ...ConfigOption) (Connection, error) {
 	config := newConfigs(opts...)
 	// panic("The function has not Impl")
+	if config.err != nil {
+		return nil, config.err
+	}
 
 	conn := newConnection(config, nil)
 
@@ -25,5 +54,51 @@ func Connect(opts ...ConfigOption) (Connection, error) {
 		return nil, err
 	}
 
+	connections.Lock()
+	if old, ok := connections.byName[config.Name]; ok && config.Name != DefaultConnectionName && old.isOpen() {
+		connections.Unlock()
+		conn.Close()
+		return nil, &ConnectionError{NewError(fmt.Sprintf("[monger] connection '%v' is already connected", config.Name))}
+	}
+	connections.byName[config.Name] = conn.(*connection)
+	connections.Unlock()
+
 	return conn, nil
 }
+
+// GetConnection returns the connection opened by Connect with name
+func GetConnection(name string) (Connection, error) {
+	conn, ok := namedConnection(name)
+	if !ok {
+		return nil, &ConnectionError{NewError(fmt.Sprintf("[monger] connection '%v' is not connected", name))}
+	}
+
+	return conn, nil
+}
+
+func namedConnection(name string) (*connection, bool) {
+	connections.RLock()
+	defer connections.RUnlock()
+
+	conn, ok := connections.byName[name]
+	return conn, ok
+}
+
+// namedConnections returns the named connections sorted by name
+func namedConnections() []*connection {
+	connections.RLock()
+	defer connections.RUnlock()
+
+	names := make([]string, 0, len(connections.byName))
+	for name := range connections.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	conns := make([]*connection, 0, len(names))
+	for _, name := range names {
+		conns = append(conns, connections.byName[name])
+	}
+
+	return conns
+}
@@ -0,0 +1,92 @@
+package monger_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/iron-kit/monger"
+	"github.com/iron-kit/monger/memdb"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// memConn connects to a new in-memory database
+func memConn(t *testing.T, options ...monger.ConfigOption) monger.Connection {
+	c, err := monger.Connect(append([]monger.ConfigOption{
+		monger.UseDriver(memdb.New()),
+		monger.DBName("monger_test"),
+	}, options...)...)
+	if assert.NoError(t, err) {
+		t.Cleanup(c.Close)
+	}
+
+	return c
+}
+
+type Report struct {
+	monger.Schema `json:",inline" bson:",inline"`
+	Title         string `json:"title,omitempty" bson:"title,omitempty"`
+}
+
+func (r *Report) GetConnectionName() string {
+	return "analytics"
+}
+
+func (r *Report) GetDatabaseName() string {
+	return "reports"
+}
+
+func TestNamedConnections(t *testing.T) {
+	transactions := memConn(t)
+
+	_, err := transactions.Register(new(Report))
+	assert.IsType(t, &monger.ConnectionError{}, err)
+
+	analytics := memConn(t, monger.ConnectionName("analytics"), monger.DBName("analytics"))
+
+	named, err := monger.GetConnection("analytics")
+	assert.NoError(t, err)
+	assert.Equal(t, analytics, named)
+
+	ReportModel := transactions.M(new(Report))
+	assert.Equal(t, ReportModel, analytics.M("Report"))
+	assert.Equal(t, ReportModel, monger.For[Report](transactions).Model())
+	assert.NoError(t, ReportModel.Create(&Report{Title: "daily"}))
+
+	// a schema name finds the models bound to a named connection only
+	assert.Equal(t, ReportModel, transactions.M("Report"))
+	analytics.M(new(Recipient))
+	_, err = transactions.Lookup("Recipient")
+	assert.IsType(t, &monger.NotRegisteredError{}, err)
+
+	n, err := analytics.CloneSession().DB("reports").C("report").Count(context.Background(), bson.M{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	n, err = transactions.CloneSession().DB("reports").C("report").Count(context.Background(), bson.M{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestConnectSameName(t *testing.T) {
+	// a new default connection leaves the previous one open
+	first := memConn(t)
+	PlanModel := first.M(new(Plan))
+	second := memConn(t)
+	assert.NoError(t, PlanModel.Create(&Plan{Name: "free"}))
+
+	named, err := monger.GetConnection(monger.DefaultConnectionName)
+	assert.NoError(t, err)
+	assert.Equal(t, second, named)
+
+	// a named connection is connected once until it is closed
+	c := memConn(t, monger.ConnectionName("replaced"))
+	_, err = monger.Connect(monger.UseDriver(memdb.New()), monger.ConnectionName("replaced"))
+	assert.IsType(t, &monger.ConnectionError{}, err)
+
+	c.Close()
+	again := memConn(t, monger.ConnectionName("replaced"))
+	named, err = monger.GetConnection("replaced")
+	assert.NoError(t, err)
+	assert.Equal(t, again, named)
+}
@@ -0,0 +1,376 @@
+package mongodriver
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	"github.com/iron-kit/monger"
+	mongobson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type collection struct {
+	collection *mongo.Collection
+	// err is returned by every operation, it is set by an invalid With
+	err error
+}
+
+func (c *collection) Name() string {
+	return c.collection.Name()
+}
+
+func (c *collection) With(readPreference *monger.ReadPreference, writeConcern *monger.WriteConcern) monger.Collection {
+	if c.err != nil {
+		return c
+	}
+
+	opts := options.Collection()
+	if readPreference != nil {
+		rp, err := newReadPref(readPreference)
+		if err != nil {
+			return &collection{collection: c.collection, err: err}
+		}
+		opts.SetReadPreference(rp)
+	}
+
+	if writeConcern != nil {
+		opts.SetWriteConcern(newWriteConcern(writeConcern))
+	}
+
+	coll, err := c.collection.Clone(opts)
+	if err != nil {
+		return &collection{collection: c.collection, err: err}
+	}
+
+	return &collection{collection: coll}
+}
+
+func (c *collection) Find(ctx context.Context, filter interface{}, opts *monger.FindOptions) monger.Cursor {
+	return &cursor{ctx: ctx, collection: c.collection, filter: filter, opts: opts, err: c.err}
+}
+
+func (c *collection) Aggregate(ctx context.Context, pipeline []bson.M) monger.Cursor {
+	return &cursor{ctx: ctx, collection: c.collection, pipeline: pipeline, aggregate: true, err: c.err}
+}
+
+func (c *collection) Count(ctx context.Context, filter interface{}) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	f, err := toRaw(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := c.collection.CountDocuments(ctx, f)
+	return int(n), toError(err)
+}
+
+func (c *collection) Insert(ctx context.Context, docs ...interface{}) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	raws := make([]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		raw, err := toRaw(doc)
+		if err != nil {
+			return err
+		}
+		raws = append(raws, raw)
+	}
+
+	if len(raws) == 1 {
+		_, err := c.collection.InsertOne(ctx, raws[0])
+		return toError(err)
+	}
+
+	_, err := c.collection.InsertMany(ctx, raws)
+	return toError(err)
+}
+
+func (c *collection) Update(ctx context.Context, selector interface{}, update interface{}) error {
+	info, err := c.update(ctx, selector, update, false, false)
+	if err != nil {
+		return err
+	}
+
+	if info.Matched == 0 {
+		return monger.ErrNotFound
+	}
+
+	return nil
+}
+
+func (c *collection) UpdateAll(ctx context.Context, selector interface{}, update interface{}) (*monger.ChangeInfo, error) {
+	return c.update(ctx, selector, update, true, false)
+}
+
+func (c *collection) Upsert(ctx context.Context, selector interface{}, update interface{}) (*monger.ChangeInfo, error) {
+	return c.update(ctx, selector, update, false, true)
+}
+
+// update runs an update, or a replacement when the update document has no
+// operators, which is what mgo does for the same document.
+func (c *collection) update(ctx context.Context, selector interface{}, update interface{}, multi bool, upsert bool) (*monger.ChangeInfo, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	f, err := toRaw(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := toRaw(update)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *mongo.UpdateResult
+	switch {
+	case !isUpdateDocument(u):
+		result, err = c.collection.ReplaceOne(ctx, f, u, options.Replace().SetUpsert(upsert))
+	case multi:
+		result, err = c.collection.UpdateMany(ctx, f, u, options.Update().SetUpsert(upsert))
+	default:
+		result, err = c.collection.UpdateOne(ctx, f, u, options.Update().SetUpsert(upsert))
+	}
+
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	info := &monger.ChangeInfo{
+		Updated: int(result.ModifiedCount),
+		Matched: int(result.MatchedCount),
+	}
+
+	if result.UpsertedID != nil {
+		info.UpsertedId = fromMongoValue(result.UpsertedID)
+	}
+
+	return info, nil
+}
+
+func (c *collection) Remove(ctx context.Context, selector interface{}) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	f, err := toRaw(selector)
+	if err != nil {
+		return err
+	}
+
+	result, err := c.collection.DeleteOne(ctx, f)
+	if err != nil {
+		return toError(err)
+	}
+
+	if result.DeletedCount == 0 {
+		return monger.ErrNotFound
+	}
+
+	return nil
+}
+
+func (c *collection) RemoveAll(ctx context.Context, selector interface{}) (*monger.ChangeInfo, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	f, err := toRaw(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := c.collection.DeleteMany(ctx, f)
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	return &monger.ChangeInfo{Removed: int(result.DeletedCount), Matched: int(result.DeletedCount)}, nil
+}
+
+func (c *collection) FindAndModify(ctx context.Context, selector interface{}, change monger.Change, result interface{}) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	f, err := toRaw(selector)
+	if err != nil {
+		return err
+	}
+
+	u, err := toRaw(change.Update)
+	if err != nil {
+		return err
+	}
+
+	returnDocument := options.Before
+	if change.ReturnNew {
+		returnDocument = options.After
+	}
+
+	var single *mongo.SingleResult
+	if isUpdateDocument(u) {
+		single = c.collection.FindOneAndUpdate(ctx, f, u, options.FindOneAndUpdate().
+			SetUpsert(change.Upsert).
+			SetReturnDocument(returnDocument))
+	} else {
+		single = c.collection.FindOneAndReplace(ctx, f, u, options.FindOneAndReplace().
+			SetUpsert(change.Upsert).
+			SetReturnDocument(returnDocument))
+	}
+
+	raw, err := single.Raw()
+	if err != nil {
+		return toError(err)
+	}
+
+	return bson.Unmarshal(raw, result)
+}
+
+type cursor struct {
+	ctx        context.Context
+	collection *mongo.Collection
+	filter     interface{}
+	opts       *monger.FindOptions
+	pipeline   []bson.M
+	aggregate  bool
+	err        error
+}
+
+func (c *cursor) One(result interface{}) error {
+	cur, err := c.open(1)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(c.context())
+
+	if !cur.Next(c.context()) {
+		if err := cur.Err(); err != nil {
+			return toError(err)
+		}
+		return monger.ErrNotFound
+	}
+
+	return bson.Unmarshal(cur.Current, result)
+}
+
+func (c *cursor) All(result interface{}) error {
+	resultv := reflect.ValueOf(result)
+	if resultv.Kind() != reflect.Ptr || resultv.Elem().Kind() != reflect.Slice {
+		panic("result argument must be a slice address")
+	}
+
+	cur, err := c.open(0)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(c.context())
+
+	slicev := resultv.Elem().Slice(0, 0)
+	elemt := slicev.Type().Elem()
+	for cur.Next(c.context()) {
+		elemp := reflect.New(elemt)
+		if err := bson.Unmarshal(cur.Current, elemp.Interface()); err != nil {
+			return err
+		}
+		slicev = reflect.Append(slicev, elemp.Elem())
+	}
+	resultv.Elem().Set(slicev)
+
+	return toError(cur.Err())
+}
+
+func (c *cursor) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+
+	return c.ctx
+}
+
+// open sends the find or the aggregation, limit caps a find to the first n documents.
+func (c *cursor) open(limit int) (*mongo.Cursor, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	if c.aggregate {
+		pipeline := make([]interface{}, 0, len(c.pipeline))
+		for _, stage := range c.pipeline {
+			raw, err := toRaw(stage)
+			if err != nil {
+				return nil, err
+			}
+			pipeline = append(pipeline, raw)
+		}
+
+		cur, err := c.collection.Aggregate(c.context(), pipeline)
+		return cur, toError(err)
+	}
+
+	f, err := toRaw(c.filter)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find()
+	if c.opts != nil {
+		if c.opts.Selector != nil {
+			projection, err := toRaw(c.opts.Selector)
+			if err != nil {
+				return nil, err
+			}
+			opts.SetProjection(projection)
+		}
+
+		if len(c.opts.Sort) > 0 {
+			opts.SetSort(toSort(c.opts.Sort))
+		}
+
+		if c.opts.Skip > 0 {
+			opts.SetSkip(int64(c.opts.Skip))
+		}
+
+		if c.opts.Limit > 0 {
+			opts.SetLimit(int64(c.opts.Limit))
+		}
+	}
+
+	if limit > 0 && (opts.Limit == nil || int64(limit) < *opts.Limit) {
+		opts.SetLimit(int64(limit))
+	}
+
+	cur, err := c.collection.Find(c.context(), f, opts)
+	return cur, toError(err)
+}
+
+// toSort converts mgo style sort fields to a sort document
+func toSort(fields []string) mongobson.D {
+	sort := mongobson.D{}
+	for _, field := range fields {
+		order := 1
+		field = strings.TrimSpace(field)
+		switch {
+		case strings.HasPrefix(field, "-"):
+			order = -1
+			field = field[1:]
+		case strings.HasPrefix(field, "+"):
+			field = field[1:]
+		}
+
+		if field != "" {
+			sort = append(sort, mongobson.E{Key: field, Value: order})
+		}
+	}
+
+	return sort
+}
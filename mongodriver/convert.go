@@ -0,0 +1,74 @@
+package mongodriver
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/iron-kit/monger"
+	mongobson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// toRaw marshals a document with mgo's bson so monger documents keep their
+// GetBSON hooks, the official driver accepts the raw bytes as they are.
+func toRaw(doc interface{}) (mongobson.Raw, error) {
+	if doc == nil {
+		doc = bson.M{}
+	}
+
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return mongobson.Raw(b), nil
+}
+
+// isUpdateDocument reports whether the document is made of update operators
+func isUpdateDocument(doc mongobson.Raw) bool {
+	elem, err := doc.IndexErr(0)
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(elem.Key(), "$")
+}
+
+// fromMongoValue converts a value decoded by the official driver to its mgo bson equivalent
+func fromMongoValue(v interface{}) interface{} {
+	b, err := mongobson.Marshal(mongobson.M{"v": v})
+	if err != nil {
+		return v
+	}
+
+	doc := bson.M{}
+	if err := bson.Unmarshal(b, &doc); err != nil {
+		return v
+	}
+
+	return doc["v"]
+}
+
+// toError translates official driver errors to monger errors
+func toError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if err == mongo.ErrNoDocuments {
+		return monger.ErrNotFound
+	}
+
+	if mongo.IsDuplicateKeyError(err) {
+		return &monger.DuplicateDocumentError{MongerQueryError: monger.NewError(err.Error())}
+	}
+
+	var serr mongo.ServerError
+	if errors.As(err, &serr) && serr.HasErrorCode(121) {
+		// DocumentValidationFailure
+		return &monger.DocumentValidationError{MongerQueryError: monger.NewError(err.Error())}
+	}
+
+	return err
+}
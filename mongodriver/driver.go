@@ -0,0 +1,307 @@
+/*
+Package mongodriver is a monger Driver backed by the official MongoDB Go driver.
+
+	conn, err := monger.Connect(
+		monger.UseDriver(mongodriver.New()),
+		monger.DBName("your_database_name"),
+		monger.Hosts([]string{"127.0.0.1"}),
+	)
+
+Documents keep using the gopkg.in/mgo.v2/bson types and hooks (GetBSON), they
+are marshaled to raw BSON before they are handed to the official driver.
+*/
+package mongodriver
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/iron-kit/monger"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"go.mongodb.org/mongo-driver/tag"
+)
+
+const dialTimeout = 3 * time.Second
+
+type driver struct{}
+
+// New returns the official MongoDB Go driver for monger.Connect
+func New() monger.Driver {
+	return new(driver)
+}
+
+func (d *driver) Dial(ctx context.Context, config *monger.Config) (monger.Session, error) {
+	opts, err := clientOptions(config)
+	if err != nil {
+		return nil, err
+	}
+
+	poolLimit := uint64(100) // the default of the driver
+	if opts.MaxPoolSize != nil {
+		poolLimit = *opts.MaxPoolSize
+	}
+	monitor := newMonitor(poolLimit)
+	opts.SetPoolMonitor(monitor.poolMonitor()).SetServerMonitor(monitor.serverMonitor())
+
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// mongo.Connect is lazy, ping the deployment so a bad config fails
+	// on Connect like it does with mgo.
+	pingCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	if err := client.Ping(pingCtx, nil); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+
+	dbName := config.DBName
+	if config.DialInfo != nil {
+		dbName = config.DialInfo.Database
+	}
+
+	return &session{client: client, dbName: dbName, monitor: monitor, owner: true}, nil
+}
+
+func clientOptions(config *monger.Config) (*options.ClientOptions, error) {
+	opts := options.Client().
+		SetConnectTimeout(dialTimeout).
+		SetServerSelectionTimeout(dialTimeout)
+
+	hosts, user, password, source := config.Hosts, config.User, config.Password, config.AuthSource
+	if info := config.DialInfo; info != nil {
+		hosts, user, password, source = info.Addrs, info.Username, info.Password, info.Source
+		if info.Timeout > 0 {
+			opts.SetConnectTimeout(info.Timeout).SetServerSelectionTimeout(info.Timeout)
+		}
+		if info.PoolLimit > 0 {
+			opts.SetMaxPoolSize(uint64(info.PoolLimit))
+		}
+	}
+
+	if config.SRV && config.DialInfo == nil {
+		// the driver resolves the seed list and the TXT options itself
+		query := url.Values{}
+		if config.SRVServiceName != "" {
+			query.Set("srvServiceName", config.SRVServiceName)
+		}
+		if config.SRVMaxHosts > 0 {
+			query.Set("srvMaxHosts", strconv.Itoa(config.SRVMaxHosts))
+		}
+		opts.ApplyURI((&url.URL{Scheme: "mongodb+srv", Host: hosts[0], Path: "/", RawQuery: query.Encode()}).String())
+	} else {
+		opts.SetHosts(hosts)
+	}
+
+	if user != "" || config.AuthMechanism != "" {
+		opts.SetAuth(options.Credential{
+			AuthMechanism:           config.AuthMechanism,
+			AuthMechanismProperties: config.AuthMechanismProperties,
+			AuthSource:              source,
+			Username:                user,
+			Password:                password,
+			PasswordSet:             password != "",
+		})
+	}
+
+	if config.PoolLimit > 0 {
+		opts.SetMaxPoolSize(uint64(config.PoolLimit))
+	}
+
+	if config.ReplicaSet != "" {
+		opts.SetReplicaSet(config.ReplicaSet)
+	}
+
+	if config.Direct {
+		opts.SetDirect(true)
+	}
+
+	if config.LoadBalanced {
+		opts.SetLoadBalanced(true)
+	}
+
+	if config.TLS != nil {
+		tlsConfig, err := config.TLS.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	if config.ConnectTimeout > 0 {
+		opts.SetConnectTimeout(config.ConnectTimeout)
+	}
+
+	if config.SocketTimeout > 0 {
+		opts.SetSocketTimeout(config.SocketTimeout)
+	}
+
+	if config.ServerSelectionTimeout > 0 {
+		opts.SetServerSelectionTimeout(config.ServerSelectionTimeout)
+	}
+
+	if config.HeartbeatInterval > 0 {
+		opts.SetHeartbeatInterval(config.HeartbeatInterval)
+	}
+
+	if config.LocalThreshold > 0 {
+		opts.SetLocalThreshold(config.LocalThreshold)
+	}
+
+	if config.MinPoolSize > 0 {
+		opts.SetMinPoolSize(uint64(config.MinPoolSize))
+	}
+
+	if config.MaxIdleTime > 0 {
+		opts.SetMaxConnIdleTime(config.MaxIdleTime)
+	}
+
+	if config.MaxConnecting > 0 {
+		opts.SetMaxConnecting(uint64(config.MaxConnecting))
+	}
+
+	if config.AppName != "" {
+		opts.SetAppName(config.AppName)
+	}
+
+	if len(config.Compressors) > 0 {
+		opts.SetCompressors(config.Compressors)
+	}
+
+	if config.ZlibLevel != nil {
+		opts.SetZlibLevel(*config.ZlibLevel)
+	}
+
+	if config.RetryWrites != nil {
+		opts.SetRetryWrites(*config.RetryWrites)
+	}
+
+	if config.RetryReads != nil {
+		opts.SetRetryReads(*config.RetryReads)
+	}
+
+	if config.ReadConcern != "" {
+		opts.SetReadConcern(&readconcern.ReadConcern{Level: config.ReadConcern})
+	}
+
+	if config.ReadPreference != nil {
+		rp, err := newReadPref(config.ReadPreference)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetReadPreference(rp)
+	}
+
+	if config.WriteConcern != nil {
+		opts.SetWriteConcern(newWriteConcern(config.WriteConcern))
+	}
+
+	return opts, nil
+}
+
+func newReadPref(rp *monger.ReadPreference) (*readpref.ReadPref, error) {
+	mode, err := readpref.ModeFromString(rp.Mode)
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []readpref.Option
+	if len(rp.Tags) > 0 {
+		sets := make([]tag.Set, 0, len(rp.Tags))
+		for _, tags := range rp.Tags {
+			set := make(tag.Set, 0, len(tags))
+			for _, t := range tags {
+				set = append(set, tag.Tag{Name: t.Name, Value: fmt.Sprint(t.Value)})
+			}
+			sets = append(sets, set)
+		}
+		opts = append(opts, readpref.WithTagSets(sets...))
+	}
+
+	if rp.MaxStaleness > 0 {
+		opts = append(opts, readpref.WithMaxStaleness(rp.MaxStaleness))
+	}
+
+	return readpref.New(mode, opts...)
+}
+
+func newWriteConcern(wc *monger.WriteConcern) *writeconcern.WriteConcern {
+	if wc.Unacknowledged {
+		return writeconcern.Unacknowledged()
+	}
+
+	concern := &writeconcern.WriteConcern{WTimeout: wc.WTimeout}
+	switch {
+	case wc.WMode != "":
+		concern.W = wc.WMode
+	case wc.W > 0:
+		concern.W = wc.W
+	}
+
+	if wc.J {
+		concern.Journal = &wc.J
+	}
+
+	return concern
+}
+
+// session wraps a client, the client is pooled so clones share it and
+// only the session returned by Dial disconnects it.
+type session struct {
+	client  *mongo.Client
+	dbName  string
+	monitor *monitor
+	owner   bool
+}
+
+func (s *session) DB(name string) monger.Database {
+	if name == "" {
+		name = s.dbName
+	}
+
+	return &database{s.client.Database(name)}
+}
+
+func (s *session) Clone() monger.Session {
+	return &session{client: s.client, dbName: s.dbName, monitor: s.monitor}
+}
+
+// Copy is Clone, the client hands a pooled connection to every operation
+func (s *session) Copy() monger.Session {
+	return s.Clone()
+}
+
+func (s *session) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx, nil)
+}
+
+func (s *session) Status() monger.SessionStatus {
+	return s.monitor.Status()
+}
+
+func (s *session) Close() {
+	if s.owner {
+		s.client.Disconnect(context.Background())
+	}
+}
+
+type database struct {
+	database *mongo.Database
+}
+
+func (db *database) Name() string {
+	return db.database.Name()
+}
+
+func (db *database) C(name string) monger.Collection {
+	return &collection{collection: db.database.Collection(name)}
+}
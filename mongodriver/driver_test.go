@@ -0,0 +1,106 @@
+package mongodriver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iron-kit/monger"
+	"github.com/stretchr/testify/assert"
+	mongobson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestNewWriteConcern(t *testing.T) {
+	journal := true
+	for _, c := range []struct {
+		in   monger.WriteConcern
+		want *writeconcern.WriteConcern
+	}{
+		{monger.WriteConcern{}, &writeconcern.WriteConcern{}},
+		{monger.WriteConcern{W: 2}, &writeconcern.WriteConcern{W: 2}},
+		{monger.WriteConcern{W: 2, WMode: "majority"}, &writeconcern.WriteConcern{W: "majority"}},
+		{monger.WriteConcern{J: true, WTimeout: time.Second}, &writeconcern.WriteConcern{Journal: &journal, WTimeout: time.Second}},
+		{monger.WriteConcern{W: 2, Unacknowledged: true}, writeconcern.Unacknowledged()},
+	} {
+		assert.Equal(t, c.want, newWriteConcern(&c.in), "%+v", c.in)
+	}
+}
+
+func TestClientOptions(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		config monger.Config
+		check  func(t *testing.T, config monger.Config)
+	}{
+		{"hosts", monger.Config{Hosts: []string{"db1:27017", "db2"}, ReplicaSet: "rs0", PoolLimit: 50, AppName: "monger"}, func(t *testing.T, config monger.Config) {
+			opts, err := clientOptions(&config)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"db1:27017", "db2"}, opts.Hosts)
+			assert.Equal(t, "rs0", *opts.ReplicaSet)
+			assert.Equal(t, uint64(50), *opts.MaxPoolSize)
+			assert.Equal(t, "monger", *opts.AppName)
+			assert.Nil(t, opts.Auth)
+			assert.Equal(t, dialTimeout, *opts.ConnectTimeout)
+		}},
+		{"auth", monger.Config{Hosts: []string{"localhost"}, User: "admin", Password: "secret", AuthSource: "admin"}, func(t *testing.T, config monger.Config) {
+			opts, err := clientOptions(&config)
+			assert.NoError(t, err)
+			if assert.NotNil(t, opts.Auth) {
+				assert.Equal(t, "admin", opts.Auth.Username)
+				assert.Equal(t, "secret", opts.Auth.Password)
+				assert.Equal(t, "admin", opts.Auth.AuthSource)
+				assert.True(t, opts.Auth.PasswordSet)
+			}
+		}},
+		{"dial info", monger.Config{
+			Hosts:    []string{"localhost"},
+			DialInfo: &mgo.DialInfo{Addrs: []string{"db3"}, Username: "reader", Timeout: time.Second, PoolLimit: 5},
+		}, func(t *testing.T, config monger.Config) {
+			opts, err := clientOptions(&config)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"db3"}, opts.Hosts)
+			assert.Equal(t, "reader", opts.Auth.Username)
+			assert.Equal(t, time.Second, *opts.ConnectTimeout)
+			assert.Equal(t, uint64(5), *opts.MaxPoolSize)
+		}},
+		{"concerns", monger.Config{
+			Hosts:          []string{"localhost"},
+			ReadPreference: &monger.ReadPreference{Mode: monger.SecondaryPreferred, Tags: []bson.D{{{Name: "dc", Value: "ny"}}}},
+			WriteConcern:   &monger.WriteConcern{WMode: "majority"},
+		}, func(t *testing.T, config monger.Config) {
+			opts, err := clientOptions(&config)
+			assert.NoError(t, err)
+			assert.Equal(t, readpref.SecondaryPreferredMode, opts.ReadPreference.Mode())
+			assert.Len(t, opts.ReadPreference.TagSets(), 1)
+			assert.Equal(t, "majority", opts.WriteConcern.W)
+		}},
+		{"invalid read preference", monger.Config{
+			Hosts:          []string{"localhost"},
+			ReadPreference: &monger.ReadPreference{Mode: "anywhere"},
+		}, func(t *testing.T, config monger.Config) {
+			_, err := clientOptions(&config)
+			assert.Error(t, err)
+		}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			c.check(t, c.config)
+		})
+	}
+}
+
+func TestToSort(t *testing.T) {
+	for _, c := range []struct {
+		in   []string
+		want mongobson.D
+	}{
+		{nil, mongobson.D{}},
+		{[]string{"name"}, mongobson.D{{Key: "name", Value: 1}}},
+		{[]string{"-age", "+name"}, mongobson.D{{Key: "age", Value: -1}, {Key: "name", Value: 1}}},
+		{[]string{" -age ", "", "-"}, mongobson.D{{Key: "age", Value: -1}}},
+	} {
+		assert.Equal(t, c.want, toSort(c.in), "%v", c.in)
+	}
+}
@@ -0,0 +1,196 @@
+package mongodriver
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/iron-kit/monger"
+	mongobson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexSpec is an index listed by the server
+type indexSpec struct {
+	Name               string      `bson:"name"`
+	Key                mongobson.D `bson:"key"`
+	Unique             bool        `bson:"unique"`
+	Sparse             bool        `bson:"sparse"`
+	ExpireAfterSeconds interface{} `bson:"expireAfterSeconds"`
+	Weights            mongobson.M `bson:"weights"`
+}
+
+func (c *collection) Indexes(ctx context.Context) ([]monger.Index, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	cur, err := c.collection.Indexes().List(ctx)
+	if err != nil {
+		return nil, toError(err)
+	}
+	defer cur.Close(ctx)
+
+	indexes := make([]monger.Index, 0)
+	for cur.Next(ctx) {
+		var spec indexSpec
+		if err := cur.Decode(&spec); err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, fromIndexSpec(spec))
+	}
+
+	return indexes, toError(cur.Err())
+}
+
+func (c *collection) EnsureIndex(ctx context.Context, index monger.Index) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	opts := options.Index()
+	if index.Name != "" {
+		opts.SetName(index.Name)
+	}
+
+	if index.Unique {
+		opts.SetUnique(true)
+	}
+
+	if index.Sparse {
+		opts.SetSparse(true)
+	}
+
+	if index.ExpireAfter > 0 {
+		opts.SetExpireAfterSeconds(int32(index.ExpireAfter / time.Second))
+	}
+
+	_, err := c.collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: toIndexKeys(index.Key), Options: opts})
+	return toError(err)
+}
+
+func (c *collection) DropIndex(ctx context.Context, name string) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	_, err := c.collection.Indexes().DropOne(ctx, name)
+	return toError(err)
+}
+
+func (c *collection) SetIndexExpiry(ctx context.Context, name string, expireAfter time.Duration) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	err := c.collection.Database().RunCommand(ctx, mongobson.D{
+		{Key: "collMod", Value: c.collection.Name()},
+		{Key: "index", Value: mongobson.D{
+			{Key: "name", Value: name},
+			{Key: "expireAfterSeconds", Value: int64(expireAfter / time.Second)},
+		}},
+	}).Err()
+	return toError(err)
+}
+
+// toIndexKeys converts mgo style index keys to a key document
+func toIndexKeys(keys []string) mongobson.D {
+	doc := mongobson.D{}
+	for _, key := range keys {
+		switch {
+		case strings.HasPrefix(key, "$"):
+			// $text:field, $2dsphere:field
+			parts := strings.SplitN(key[1:], ":", 2)
+			if len(parts) == 2 {
+				doc = append(doc, mongobson.E{Key: parts[1], Value: parts[0]})
+			}
+		case strings.HasPrefix(key, "-"):
+			doc = append(doc, mongobson.E{Key: key[1:], Value: -1})
+		default:
+			doc = append(doc, mongobson.E{Key: strings.TrimPrefix(key, "+"), Value: 1})
+		}
+	}
+
+	return doc
+}
+
+// fromIndexSpec converts a listed index, the fields of a text index are
+// read from its weights like mgo does.
+func fromIndexSpec(spec indexSpec) monger.Index {
+	index := monger.Index{Name: spec.Name, Unique: spec.Unique, Sparse: spec.Sparse}
+	for _, elem := range spec.Key {
+		switch elem.Key {
+		case "_fts":
+			fields := make([]string, 0, len(spec.Weights))
+			for field := range spec.Weights {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			for _, field := range fields {
+				index.Key = append(index.Key, "$text:"+field)
+			}
+			continue
+		case "_ftsx":
+			continue
+		}
+
+		if kind, ok := elem.Value.(string); ok {
+			index.Key = append(index.Key, "$"+kind+":"+elem.Key)
+		} else if n, ok := toFloat(elem.Value); ok && n < 0 {
+			index.Key = append(index.Key, "-"+elem.Key)
+		} else {
+			index.Key = append(index.Key, elem.Key)
+		}
+	}
+
+	if seconds, ok := toFloat(spec.ExpireAfterSeconds); ok {
+		index.ExpireAfter = time.Duration(seconds) * time.Second
+	}
+
+	return index
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+
+	return 0, false
+}
+
+func (c *collection) SetValidator(ctx context.Context, validator monger.Validator) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	schema, err := toRaw(validator.Schema)
+	if err != nil {
+		return err
+	}
+
+	err = c.collection.Database().RunCommand(ctx, mongobson.D{
+		{Key: "collMod", Value: c.collection.Name()},
+		{Key: "validator", Value: schema},
+		{Key: "validationLevel", Value: validator.Level},
+		{Key: "validationAction", Value: validator.Action},
+	}).Err()
+	var cerr mongo.CommandError
+	if errors.As(err, &cerr) && cerr.Code == 26 {
+		// NamespaceNotFound, the collection is created with the validator
+		opts := options.CreateCollection().
+			SetValidator(schema).
+			SetValidationLevel(validator.Level).
+			SetValidationAction(validator.Action)
+		err = c.collection.Database().CreateCollection(ctx, c.collection.Name(), opts)
+	}
+
+	return toError(err)
+}
@@ -0,0 +1,60 @@
+package mongodriver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iron-kit/monger"
+	"github.com/stretchr/testify/assert"
+	mongobson "go.mongodb.org/mongo-driver/bson"
+)
+
+func TestToIndexKeys(t *testing.T) {
+	for _, c := range []struct {
+		in   []string
+		want mongobson.D
+	}{
+		{[]string{"name"}, mongobson.D{{Key: "name", Value: 1}}},
+		{[]string{"+name", "-age"}, mongobson.D{{Key: "name", Value: 1}, {Key: "age", Value: -1}}},
+		{[]string{"$text:title", "$text:body"}, mongobson.D{{Key: "title", Value: "text"}, {Key: "body", Value: "text"}}},
+		{[]string{"$2dsphere:location"}, mongobson.D{{Key: "location", Value: "2dsphere"}}},
+		{[]string{"$text"}, mongobson.D{}},
+	} {
+		assert.Equal(t, c.want, toIndexKeys(c.in), "%v", c.in)
+	}
+}
+
+func TestFromIndexSpec(t *testing.T) {
+	for _, c := range []struct {
+		spec indexSpec
+		want monger.Index
+	}{
+		{
+			indexSpec{Name: "name_1", Key: mongobson.D{{Key: "name", Value: int32(1)}}, Unique: true},
+			monger.Index{Name: "name_1", Key: []string{"name"}, Unique: true},
+		},
+		{
+			indexSpec{Name: "age_-1_name_1", Key: mongobson.D{{Key: "age", Value: float64(-1)}, {Key: "name", Value: int64(1)}}, Sparse: true},
+			monger.Index{Name: "age_-1_name_1", Key: []string{"-age", "name"}, Sparse: true},
+		},
+		{
+			indexSpec{Name: "seen_at_1", Key: mongobson.D{{Key: "seen_at", Value: int32(1)}}, ExpireAfterSeconds: int32(3600)},
+			monger.Index{Name: "seen_at_1", Key: []string{"seen_at"}, ExpireAfter: time.Hour},
+		},
+		{
+			indexSpec{Name: "location_2dsphere", Key: mongobson.D{{Key: "location", Value: "2dsphere"}}},
+			monger.Index{Name: "location_2dsphere", Key: []string{"$2dsphere:location"}},
+		},
+		{
+			// the fields of a text index are listed in its weights
+			indexSpec{
+				Name:    "title_text_body_text",
+				Key:     mongobson.D{{Key: "_fts", Value: "text"}, {Key: "_ftsx", Value: int32(1)}},
+				Weights: mongobson.M{"title": int32(1), "body": int32(1)},
+			},
+			monger.Index{Name: "title_text_body_text", Key: []string{"$text:body", "$text:title"}},
+		},
+	} {
+		assert.Equal(t, c.want, fromIndexSpec(c.spec), c.spec.Name)
+	}
+}
@@ -0,0 +1,67 @@
+package mongodriver
+
+import (
+	"sync"
+
+	"github.com/iron-kit/monger"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// monitor keeps the topology and the pool state from the driver events
+type monitor struct {
+	mu     sync.Mutex
+	status monger.SessionStatus
+}
+
+func newMonitor(poolLimit uint64) *monitor {
+	m := new(monitor)
+	m.status.Pool.Limit = int(poolLimit)
+
+	return m
+}
+
+func (m *monitor) poolMonitor() *event.PoolMonitor {
+	return &event.PoolMonitor{Event: func(e *event.PoolEvent) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		switch e.Type {
+		case event.ConnectionCreated:
+			m.status.Pool.Open++
+		case event.ConnectionClosed:
+			m.status.Pool.Open--
+		case event.GetSucceeded:
+			m.status.Pool.InUse++
+		case event.ConnectionReturned:
+			m.status.Pool.InUse--
+		}
+	}}
+}
+
+func (m *monitor) serverMonitor() *event.ServerMonitor {
+	return &event.ServerMonitor{TopologyDescriptionChanged: func(e *event.TopologyDescriptionChangedEvent) {
+		servers := make([]monger.ServerStatus, 0, len(e.NewDescription.Servers))
+		for _, server := range e.NewDescription.Servers {
+			servers = append(servers, monger.ServerStatus{
+				Address: server.Addr.String(),
+				Kind:    server.Kind.String(),
+			})
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.status.Topology = e.NewDescription.Kind.String()
+		m.status.Servers = servers
+	}}
+}
+
+func (m *monitor) Status() monger.SessionStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := m.status
+	status.Servers = append([]monger.ServerStatus(nil), m.status.Servers...)
+
+	return status
+}
@@ -1,13 +1,13 @@
 package monger
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"reflect"
 	"strings"
 	"time"
 
-	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -37,7 +37,7 @@ type Query interface {
 	// Unscoped() Query
 	OnlyTrashed() Query
 	OffSoftDeletes() Query
-	Collection() *mgo.Collection
+	Collection() Collection
 	Select(selector bson.M) Query
 	Where(condition bson.M) Query
 	FindOne(interface{}) error
@@ -47,26 +47,31 @@ type Query interface {
 	exec(interface{}) error
 	Create(interface{}) error
 	Update(condition bson.M, docs interface{}) error
-	Upsert(condition bson.M, docs interface{}) (*mgo.ChangeInfo, error)
-	UpsertID(id interface{}, docs interface{}) (*mgo.ChangeInfo, error)
+	Upsert(condition bson.M, docs interface{}) (*ChangeInfo, error)
+	UpsertID(id interface{}, docs interface{}) (*ChangeInfo, error)
+	Save(doc interface{}, options ...SaveOption) error
 	Restore() error
 	Delete() error
-	DeleteAll() (*mgo.ChangeInfo, error)
+	DeleteAll() (*ChangeInfo, error)
 	ForceDelete() error
-	ForceDeleteAll() (*mgo.ChangeInfo, error)
+	ForceDeleteAll() (*ChangeInfo, error)
 	Skip(skip int) Query
 	Limit(limit int) Query
 	Sort(fields ...string) Query
 	Aggregate([]bson.M) Query
-	Pipe(...bson.M) *mgo.Pipe
+	Pipe(...bson.M) Cursor
 	Query() Query
+	WithContext(ctx context.Context) Query
+	ReadPreference(readPreference *ReadPreference) Query
+	WriteConcern(writeConcern *WriteConcern) Query
+	ForTenant(tenant string) Query
 }
 
 type query struct {
 	withTrashed    bool
 	onlyTrashed    bool
 	offSoftDeletes bool
-	collection     *mgo.Collection
+	collection     Collection
 	where          bson.M
 	selector       interface{}
 	populate       []string
@@ -76,6 +81,14 @@ type query struct {
 	pipeline       []bson.M
 	multiple       bool
 	schemaStruct   *SchemaStruct
+	ctx            context.Context
+	readPreference *ReadPreference
+	writeConcern   *WriteConcern
+	tenant         string
+	kinds          *modelKinds
+	kind           string
+	// err is the error of a condition, the query fails with it
+	err error
 }
 
 func (q *query) Query() Query {
@@ -83,7 +96,83 @@ func (q *query) Query() Query {
 	return &qCopy
 }
 
-func (q *query) Pipe(pipes ...bson.M) *mgo.Pipe {
+// clone copies the query, changing the copy leaves q as it is
+func (q *query) clone() *query {
+	c := *q
+	if q.where != nil {
+		c.where = make(bson.M, len(q.where))
+		for key, value := range q.where {
+			c.where[key] = value
+		}
+	}
+	c.populate = q.populate[:len(q.populate):len(q.populate)]
+	c.sort = q.sort[:len(q.sort):len(q.sort)]
+	c.pipeline = q.pipeline[:len(q.pipeline):len(q.pipeline)]
+
+	return &c
+}
+
+// WithContext binds ctx to the query, every operation executed by the query
+// is handed to the driver with ctx.
+func (q *query) WithContext(ctx context.Context) Query {
+	q.ctx = ctx
+	return q
+}
+
+// ReadPreference overrides the read preference of the model for the query
+func (q *query) ReadPreference(readPreference *ReadPreference) Query {
+	q.readPreference = readPreference
+	return q
+}
+
+// WriteConcern overrides the write concern of the model for the query
+func (q *query) WriteConcern(writeConcern *WriteConcern) Query {
+	q.writeConcern = writeConcern
+	return q
+}
+
+// ForTenant routes the query to tenant, it overrides the tenant of the context
+func (q *query) ForTenant(tenant string) Query {
+	q.tenant = tenant
+	return q
+}
+
+// coll is the collection the query executes on
+func (q *query) coll() Collection {
+	coll := q.collection
+	if sc, ok := coll.(*sessionCollection); ok && q.tenant != "" {
+		coll = sc.forTenant(q.tenant)
+	}
+
+	if q.readPreference == nil && q.writeConcern == nil {
+		return coll
+	}
+
+	return coll.With(q.readPreference, q.writeConcern)
+}
+
+func (q *query) context() context.Context {
+	if q.ctx == nil {
+		return context.Background()
+	}
+
+	return q.ctx
+}
+
+// errCursor is the cursor of a query whose condition failed
+type errCursor struct {
+	err error
+}
+
+func (c *errCursor) One(result interface{}) error {
+	return c.err
+}
+
+func (c *errCursor) All(result interface{}) error {
+	return c.err
+}
+
+func (q *query) Pipe(pipes ...bson.M) Cursor {
 	return q.buildPipeQuery(pipes...)
 }
 
@@ -104,7 +193,7 @@ func (q *query) OffSoftDeletes() Query {
 	return q
 }
 
-func (q *query) Collection() *mgo.Collection {
+func (q *query) Collection() Collection {
 	return q.collection
 }
 
@@ -166,7 +255,11 @@ func (q *query) Count() int {
 		// q.pipeline = append(q.pipeline, )
 	}
 
-	c, err := q.collection.Find(q.where).Count()
+	if q.err != nil {
+		return 0
+	}
+
+	c, err := q.coll().Count(q.context(), q.where)
 	if err != nil {
 		return 0
 	}
@@ -177,37 +270,44 @@ func (q *query) Restore() error {
 	if q.offSoftDeletes {
 		return nil
 	}
+	if q.err != nil {
+		return q.err
+	}
 
-	_, err := q.collection.Upsert(q.where, bson.M{"$set": bson.M{
-		"deleted": false,
-	}})
+	_, err := q.coll().UpdateAll(q.context(), q.where, q.restoreUpdate())
 
 	return err
 }
 
 func (q *query) Delete() error {
 	if !q.offSoftDeletes {
-		return q.collection.Update(q.where, bson.M{"$set": bson.M{"deleted": true}})
+		return q.deleteOne(func(selector bson.M) error {
+			return q.coll().Update(q.context(), selector, q.softDeleteUpdate())
+		})
 	}
 	return q.ForceDelete()
 }
 
-func (q *query) DeleteAll() (info *mgo.ChangeInfo, err error) {
+func (q *query) DeleteAll() (info *ChangeInfo, err error) {
 	if !q.offSoftDeletes {
-		return q.collection.Upsert(q.where, bson.M{"$set": bson.M{
-			"deleted": true,
-		}})
+		return q.deleteAll(func(selector bson.M) (*ChangeInfo, error) {
+			return q.coll().UpdateAll(q.context(), selector, q.softDeleteUpdate())
+		})
 	}
 
 	return q.ForceDeleteAll()
 }
 
 func (q *query) ForceDelete() error {
-	return q.collection.Remove(q.where)
+	return q.deleteOne(func(selector bson.M) error {
+		return q.coll().Remove(q.context(), selector)
+	})
 }
 
-func (q *query) ForceDeleteAll() (*mgo.ChangeInfo, error) {
-	return q.collection.RemoveAll(q.where)
+func (q *query) ForceDeleteAll() (*ChangeInfo, error) {
+	return q.deleteAll(func(selector bson.M) (*ChangeInfo, error) {
+		return q.coll().RemoveAll(q.context(), selector)
+	})
 }
 
 func (q *query) Populate(fields ...string) Query {
@@ -218,64 +318,108 @@ func (q *query) Populate(fields ...string) Query {
 	return q
 }
 
-func toWhere(condition bson.M) bson.M {
+// toWhere converts the hex strings compared to the ObjectId fields of the
+// schema to ObjectIds, the strings compared to other fields are kept. The
+// values compared to deterministic encrypted fields are encrypted.
+func toWhere(schemaStruct *SchemaStruct, condition bson.M) (bson.M, error) {
 	result := bson.M{}
 	for key, val := range condition {
 		switch v := val.(type) {
-		case string:
-			if bson.IsObjectIdHex(v) {
-				result[key] = bson.ObjectIdHex(v)
-			} else {
-				result[key] = v
-			}
-		case bson.M:
-			result[key] = toWhere(v)
 		case []bson.M:
+			// $and, $or and $nor
 			items := make([]bson.M, 0)
 			for _, item := range v {
-				items = append(items, toWhere(item))
+				where, err := toWhere(schemaStruct, item)
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, where)
 			}
 			result[key] = items
 		default:
-			result[key] = v
+			result[key] = toColumnValue(schemaStruct.columnType(key), v)
+			if field := schemaStruct.columnField(key); field != nil {
+				filter, err := encryptedFilter(field, result[key])
+				if err != nil {
+					return nil, err
+				}
+				result[key] = filter
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// columnType is the type of the field of a column, nil for unknown columns
+func (s *SchemaStruct) columnType(column string) reflect.Type {
+	if s == nil {
+		return nil
+	}
+
+	for _, field := range s.Fields {
+		if field.columnName() == column {
+			return field.Struct.Type
 		}
 	}
 
-	return result
+	return nil
 }
 
-func executeWhere(in interface{}, condition bson.M) bson.M {
-	condition = toWhere(condition)
-	fmt.Println(condition, "Where")
-	if w, ok := in.(bson.M); ok {
-		for key, val := range condition {
-			w[key] = val
+// toColumnValue converts the values compared to a field of type t
+func toColumnValue(t reflect.Type, value interface{}) interface{} {
+	if t == nil {
+		return value
+	}
+
+	switch v := value.(type) {
+	case string:
+		if id, err := toFieldValue(t, v); err == nil {
+			return id
+		}
+	case []string:
+		items := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			items = append(items, toColumnValue(t, item))
+		}
+		return items
+	case []interface{}:
+		items := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			items = append(items, toColumnValue(t, item))
+		}
+		return items
+	case bson.M:
+		operators := bson.M{}
+		for op, arg := range v {
+			if strings.HasPrefix(op, "$") {
+				operators[op] = toColumnValue(t, arg)
+			} else {
+				operators[op] = arg
+			}
 		}
-		// for key, val := range condition {
-		// 	switch v := val.(type) {
-		// 	case string:
-		// 		if bson.IsObjectIdHex(v) {
-		// 			w[key] = bson.ObjectIdHex(v)
-		// 		} else {
-		// 			w[key] = v
-		// 		}
-		// 	case []bson.M:
-		// 		for i, val := range v {
-		// 			w[key][i] = executeWhere(val, v)
-		// 		}
-		// 	case bson.M:
-		// 		w[key] = executeWhere(val, v)
-		// 	default:
-		// 		w[key] = val
-		// 	}
-		// }
+		return operators
+	}
+
+	return value
+}
+
+// executeWhere adds the condition to in, nothing is added when it fails
+func executeWhere(schemaStruct *SchemaStruct, in bson.M, condition bson.M) error {
+	condition, err := toWhere(schemaStruct, condition)
+	if err != nil {
+		return err
+	}
 
-		return w
+	for key, val := range condition {
+		in[key] = val
 	}
 
-	return bson.M{}
+	return nil
 }
 
+// Where adds a condition to the query, a condition which can not be
+// converted fails the operations of the query.
 func (q *query) Where(condition bson.M) Query {
 
 	// panic("not implemented")
@@ -283,18 +427,14 @@ func (q *query) Where(condition bson.M) Query {
 		q.where = make(bson.M)
 	}
 
-	executeWhere(q.where, condition)
+	if err := executeWhere(q.schemaStruct, q.where, condition); err != nil && q.err == nil {
+		q.err = err
+	}
 
 	fmt.Println(q.where, "where")
 
 	if !q.offSoftDeletes {
-		if !q.withTrashed {
-			q.where["deleted"] = false
-		}
-
-		if q.onlyTrashed {
-			q.where["deleted"] = true
-		}
+		q.softDeleteWhere(q.where)
 	}
 
 	return q
@@ -324,27 +464,17 @@ func (q *query) Aggregate(pipe []bson.M) Query {
 	// return q.buildPipeQuery()
 }
 
-func (q *query) buildQuery() *mgo.Query {
-
-	query := q.collection.Find(q.where)
-
-	if q.selector != nil {
-		query.Select(q.selector)
-	}
-
-	if q.skip > 0 {
-		query.Skip(q.skip)
-	}
-
-	if q.limit > 0 {
-		query.Limit(q.limit)
-	}
-
-	if len(q.sort) > 0 {
-		query.Sort(q.sort...)
+func (q *query) buildQuery() Cursor {
+	if q.err != nil {
+		return &errCursor{q.err}
 	}
 
-	return query
+	return q.coll().Find(q.context(), q.where, &FindOptions{
+		Selector: q.selector,
+		Sort:     q.sort,
+		Skip:     q.skip,
+		Limit:    q.limit,
+	})
 }
 
 func getPopulateTree(populate []string) []*PopulateItem {
@@ -602,7 +732,11 @@ func (q *query) getPopulatePipeline() []bson.M {
 	// return pipeline
 }
 
-func (q *query) buildPipeQuery(appendPipes ...bson.M) *mgo.Pipe {
+func (q *query) buildPipeQuery(appendPipes ...bson.M) Cursor {
+	if q.err != nil {
+		return &errCursor{q.err}
+	}
+
 	pipeline := make([]bson.M, 0)
 
 	if len(q.populate) > 0 {
@@ -640,7 +774,7 @@ func (q *query) buildPipeQuery(appendPipes ...bson.M) *mgo.Pipe {
 		q.pipeline = append(q.pipeline, appendPipes...)
 	}
 
-	return q.collection.Pipe(q.pipeline)
+	return q.coll().Aggregate(q.context(), q.pipeline)
 }
 
 func (q *query) execPipeMuli(results interface{}) error {
@@ -660,6 +794,23 @@ func (q *query) execOne(result interface{}) error {
 }
 
 func (q *query) exec(result interface{}) error {
+	if q.err != nil {
+		return q.err
+	}
+
+	find := q.find
+	if q.kinds.isPolymorphic() && isInterfaceResult(result) {
+		find = q.findKinds
+	}
+
+	if err := find(result); err != nil {
+		return err
+	}
+
+	return afterFind(q.context(), result)
+}
+
+func (q *query) find(result interface{}) error {
 	if result == nil {
 		return &InvalidParamsError{NewError("The result is required")}
 	}
@@ -704,21 +855,114 @@ func (q *query) Create(doc interface{}) error {
 		doct = doct.Elem()
 	}
 	if doct.Kind() == reflect.Slice {
-
-		// TODO batch create
-		return nil
+		return q.createAll(doc)
 	}
 	if d, ok := doc.(Schemer); ok {
-		d.beforeCreate(doc)
-		q.collection.Insert(doc)
+		if err := q.prepareCreate(d); err != nil {
+			return err
+		}
+		if err := q.assignSequences(doc); err != nil {
+			return err
+		}
+		if err := q.coll().Insert(q.context(), doc); err != nil {
+			return err
+		}
 		d.afterCreate()
-		return nil
+		return afterCreateHooks(q.context(), doc)
 	}
 
 	return &InvalidParamsError{NewError("Document must be schemer")}
 }
 
-func (q *query) execUpdate(data interface{}, f func(d interface{})) {
+// createAll inserts a slice of documents with a single insert, the values
+// of their sequences are allocated together.
+func (q *query) createAll(docs interface{}) error {
+	docsv := reflect.ValueOf(docs)
+	for docsv.Kind() == reflect.Ptr {
+		docsv = docsv.Elem()
+	}
+
+	items := make([]interface{}, 0, docsv.Len())
+	for i := 0; i < docsv.Len(); i++ {
+		item := docsv.Index(i)
+		if item.Kind() != reflect.Ptr {
+			if !item.CanAddr() {
+				return &InvalidParamsError{NewError("the documents must be addressable, use a pointer to the slice")}
+			}
+			item = item.Addr()
+		}
+
+		d, ok := item.Interface().(Schemer)
+		if !ok || item.IsNil() {
+			return &InvalidParamsError{NewError("Document must be schemer")}
+		}
+		if err := q.prepareCreate(d); err != nil {
+			return err
+		}
+		items = append(items, d)
+	}
+
+	if len(items) == 0 {
+		return nil
+	}
+
+	if err := q.assignSequences(items...); err != nil {
+		return err
+	}
+	if err := q.coll().Insert(q.context(), items...); err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		item.(Schemer).afterCreate()
+		if err := afterCreateHooks(q.context(), item); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prepareCreate sets the generated fields of a document, runs its before
+// hooks and validates it.
+func (q *query) prepareCreate(doc Schemer) error {
+	q.assignKind(doc)
+	if err := doc.beforeCreate(doc); err != nil {
+		return err
+	}
+	if err := beforeCreateHooks(q.context(), doc); err != nil {
+		return err
+	}
+
+	return validateNewDocument(q.schemaStruct, doc)
+}
+
+// execUpdate prepares an update and sends it with f, the update hooks of a
+// schema document run around it.
+func (q *query) execUpdate(data interface{}, f func(d interface{}) error) error {
+	doc := updateDocument(data)
+	if doc != nil {
+		if err := beforeUpdateHooks(q.context(), doc); err != nil {
+			return err
+		}
+	}
+
+	if err := validateUpdate(q.schemaStruct, data); err != nil {
+		return err
+	}
+
+	if err := q.sendUpdate(data, f); err != nil {
+		return err
+	}
+
+	if doc != nil {
+		return afterUpdateHooks(q.context(), doc)
+	}
+
+	return nil
+}
+
+func (q *query) sendUpdate(data interface{}, f func(d interface{}) error) error {
 	// datat := reflect.TypeOf(data)
 	datav := reflect.ValueOf(data)
 	for datav.Kind() == reflect.Ptr {
@@ -732,9 +976,13 @@ func (q *query) execUpdate(data interface{}, f func(d interface{})) {
 		}
 
 		if doc, ok := data.(Schemer); ok {
-			doc.beforeUpdate(data)
-			defer doc.afterUpdate()
-			f(bson.M{"$set": doc})
+			if err := doc.beforeUpdate(data); err != nil {
+				return err
+			}
+			if err := f(bson.M{"$set": doc}); err != nil {
+				return err
+			}
+			return doc.afterUpdate()
 		}
 	}
 
@@ -742,38 +990,60 @@ func (q *query) execUpdate(data interface{}, f func(d interface{})) {
 	case reflect.Map:
 		vv := datav.Interface()
 		mapData := vv.(bson.M)
+		var doc Schemer
 		foundSet := false
+		hasOperators := false
 		now := time.Now()
+		column := q.schemaStruct.timestamps().UpdatedAt
 		for k, val := range mapData {
+			if strings.HasPrefix(k, "$") {
+				hasOperators = true
+			}
+
 			if k == "$set" {
 				foundSet = true
 
-				if d, ok := val.(bson.M); ok {
-					d["updated_at"] = now
+				if d, ok := val.(bson.M); ok && column != "" {
+					d[column] = now
 				}
 
-				if d, ok := val.(map[string]interface{}); ok {
-					d["updated_at"] = now
+				if d, ok := val.(map[string]interface{}); ok && column != "" {
+					d[column] = now
 				}
 
 				if d, ok := val.(Schemer); ok {
-					d.beforeUpdate(data)
+					if err := d.beforeUpdate(d); err != nil {
+						return err
+					}
 
-					defer d.afterUpdate()
+					doc = d
 				}
 			}
 		}
 
-		if !foundSet {
-			mapData["updated_at"] = now
+		if !foundSet && column != "" {
+			if hasOperators {
+				mapData["$set"] = bson.M{column: now}
+			} else {
+				mapData[column] = now
+			}
 		}
 
-		f(data)
+		if err := encryptUpdate(q.schemaStruct, mapData); err != nil {
+			return err
+		}
+		if err := f(data); err != nil {
+			return err
+		}
+		if doc != nil {
+			return doc.afterUpdate()
+		}
+		return nil
 
 	case reflect.Struct:
-		f(bson.M{"$set": data})
+		return f(bson.M{"$set": data})
 	default:
-		f(data)
+		return f(data)
 	}
 
 	// defer func() {
@@ -783,44 +1053,70 @@ func (q *query) execUpdate(data interface{}, f func(d interface{})) {
 
 func (q *query) Update(condition bson.M, doc interface{}) (err error) {
 	// panic("not implemented")
-	cond := bson.M{}
-	executeWhere(cond, condition)
-	q.execUpdate(doc, func(d interface{}) {
-		err = q.collection.Update(cond, d)
-	})
+	cond := q.kindFilter()
+	if cond == nil {
+		cond = bson.M{}
+	}
+	if err := executeWhere(q.schemaStruct, cond, condition); err != nil {
+		return err
+	}
 
-	return
+	return q.execUpdate(doc, func(d interface{}) error {
+		return q.updateVersioned(cond, d)
+	})
 }
 
-func (q *query) Upsert(condition bson.M, docs interface{}) (changeInfo *mgo.ChangeInfo, err error) {
-	cond := bson.M{}
-	executeWhere(cond, condition)
-	q.execUpdate(docs, func(d interface{}) {
-		changeInfo, err = q.collection.Upsert(condition, d)
+func (q *query) Upsert(condition bson.M, docs interface{}) (changeInfo *ChangeInfo, err error) {
+	cond := q.kindFilter()
+	if cond == nil {
+		cond = bson.M{}
+	}
+	if err = executeWhere(q.schemaStruct, cond, condition); err != nil {
+		return nil, err
+	}
+	err = q.execUpdate(docs, func(d interface{}) error {
+		if _, _, err = versionUpdate(q.schemaStruct, d); err != nil {
+			return err
+		}
+		if d, err = withInsertDefaults(q.schemaStruct, d); err != nil {
+			return err
+		}
+		changeInfo, err = q.coll().Upsert(q.context(), cond, d)
+		return err
 	})
 
 	return
 }
 
-func (q *query) UpsertID(id interface{}, docs interface{}) (changeInfo *mgo.ChangeInfo, err error) {
-	// cond := bson.M{}
-	if ids, ok := id.(string); ok {
-		if bson.IsObjectIdHex(ids) {
-			id = bson.ObjectIdHex(ids)
-		}
+func (q *query) UpsertID(id interface{}, docs interface{}) (changeInfo *ChangeInfo, err error) {
+	if id, err = toID(q.schemaStruct, id); err != nil {
+		return nil, err
 	}
-	// executeWhere(cond, condition)
-	q.execUpdate(docs, func(d interface{}) {
-		changeInfo, err = q.collection.UpsertId(id, d)
+
+	cond := bson.M{"_id": id}
+	for k, v := range q.kindFilter() {
+		cond[k] = v
+	}
+
+	err = q.execUpdate(docs, func(d interface{}) error {
+		if _, _, err = versionUpdate(q.schemaStruct, d); err != nil {
+			return err
+		}
+		if d, err = withInsertDefaults(q.schemaStruct, d); err != nil {
+			return err
+		}
+		changeInfo, err = q.coll().Upsert(q.context(), cond, d)
+		return err
 	})
 
 	return
 }
 
-func newQuery(coll *mgo.Collection, sinfo *SchemaStruct) Query {
+func newQuery(coll Collection, sinfo *SchemaStruct) Query {
 	return &query{
-		collection:   coll,
-		schemaStruct: sinfo,
+		collection:     coll,
+		schemaStruct:   sinfo,
+		offSoftDeletes: sinfo.timestamps().DeletedAt == "",
 	}
 }
 
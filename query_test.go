@@ -0,0 +1,63 @@
+package monger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestQueryWithCanceledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	members := make([]*Member, 0)
+	err := newQuery(new(mgoCollection), GetSchemaStruct(new(Member))).
+		WithContext(ctx).
+		Where(bson.M{"username": "alixezz"}).
+		Populate("Profile").
+		FindAll(&members)
+
+	assert.Equal(t, context.Canceled, err)
+}
+
+// optionsCollection records the options a query executes with
+type optionsCollection struct {
+	Collection
+	readPreference *ReadPreference
+	writeConcern   *WriteConcern
+}
+
+func (c *optionsCollection) With(readPreference *ReadPreference, writeConcern *WriteConcern) Collection {
+	with := *c
+	if readPreference != nil {
+		with.readPreference = readPreference
+	}
+	if writeConcern != nil {
+		with.writeConcern = writeConcern
+	}
+	return &with
+}
+
+func TestQueryReadPreferenceAndWriteConcern(t *testing.T) {
+	coll := new(optionsCollection)
+	m := &model{schema: new(Member), collection: coll}
+
+	q := m.query().(*query)
+	assert.Equal(t, coll, q.coll())
+
+	secondary := &ReadPreference{Mode: SecondaryPreferred}
+	majority := &WriteConcern{WMode: "majority"}
+	m.SetReadPreference(secondary)
+	m.SetWriteConcern(majority)
+
+	q = m.query().(*query)
+	assert.Equal(t, secondary, q.coll().(*optionsCollection).readPreference)
+	assert.Equal(t, majority, q.coll().(*optionsCollection).writeConcern)
+
+	nearest := &ReadPreference{Mode: Nearest, Tags: []bson.D{{{Name: "dc", Value: "ny"}}}}
+	q = m.query().ReadPreference(nearest).(*query)
+	assert.Equal(t, nearest, q.coll().(*optionsCollection).readPreference)
+	assert.Equal(t, majority, q.coll().(*optionsCollection).writeConcern)
+}
@@ -0,0 +1,127 @@
+package monger
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+)
+
+// registry holds the models of a connection, it is safe for goroutines.
+// Models are keyed by their struct type and by their schema name, the
+// snake case name of the struct.
+type registry struct {
+	mu           sync.RWMutex
+	byType       map[reflect.Type]Model
+	byName       map[string]reflect.Type
+	byCollection map[string]reflect.Type
+}
+
+func newRegistry() *registry {
+	return &registry{
+		byType:       make(map[reflect.Type]Model),
+		byName:       make(map[string]reflect.Type),
+		byCollection: make(map[string]reflect.Type),
+	}
+}
+
+// schemaType returns the struct type of a schema, a pointer is dereferenced
+func schemaType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return t
+}
+
+// register returns the model of the schema type, create builds it when the
+// type is registered for the first time.
+func (r *registry) register(schema Schemer, create func() Model) (Model, error) {
+	return r.add(schema, create, false)
+}
+
+// registerKind registers the model of a subtype, it shares the collection
+// of its base model.
+func (r *registry) registerKind(schema Schemer, create func() Model) (Model, error) {
+	return r.add(schema, create, true)
+}
+
+func (r *registry) add(schema Schemer, create func() Model, sharedCollection bool) (Model, error) {
+	t := schemaType(reflect.TypeOf(schema))
+	if t.Kind() != reflect.Struct {
+		return nil, &InvalidParamsError{NewError(fmt.Sprintf("[monger] schema must be a pointer to a struct, got %v", t))}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if mdl, ok := r.byType[t]; ok {
+		return mdl, nil
+	}
+
+	name := getSchemaTypeName(reflect.New(t).Interface())
+	if other, ok := r.byName[name]; ok {
+		return nil, &DuplicateModelError{NewError(fmt.Sprintf(
+			"[monger] schema name '%v' of %v is already used by %v", name, t, other,
+		))}
+	}
+
+	collectionName := getCollectionName(schema)
+	if db := getDatabaseName(schema); db != "" {
+		collectionName = db + "." + collectionName
+	}
+
+	if other, ok := r.byCollection[collectionName]; ok && !sharedCollection {
+		return nil, &DuplicateModelError{NewError(fmt.Sprintf(
+			"[monger] collection '%v' of %v is already used by %v", collectionName, t, other,
+		))}
+	}
+
+	mdl := create()
+	r.byType[t] = mdl
+	r.byName[name] = t
+	if !sharedCollection {
+		r.byCollection[collectionName] = t
+	}
+	log.Printf("[monger] Type '%v' has registered \r\n", name)
+
+	return mdl, nil
+}
+
+// models returns the registered models
+func (r *registry) models() []Model {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	models := make([]Model, 0, len(r.byType))
+	for _, mdl := range r.byType {
+		models = append(models, mdl)
+	}
+
+	return models
+}
+
+// lookup finds a registered model by schema name, struct type or schema value
+func (r *registry) lookup(key interface{}) (Model, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var t reflect.Type
+	switch k := key.(type) {
+	case string:
+		t = r.byName[snakeString(k)]
+	case reflect.Type:
+		t = schemaType(k)
+	case Schemer:
+		t = schemaType(reflect.TypeOf(k))
+	default:
+		return nil, &InvalidParamsError{NewError(fmt.Sprintf("[monger] can not look up a model by %T", key))}
+	}
+
+	mdl, found := r.byType[t]
+	if !found {
+		return nil, &NotRegisteredError{NewError(fmt.Sprintf("[monger] Schema '%v' is not registered", key))}
+	}
+
+	return mdl, nil
+}
@@ -0,0 +1,75 @@
+package monger
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type LegacyMember struct {
+	Schema   `json:",inline" bson:",inline"`
+	Username string `json:"username,omitempty" bson:"username,omitempty"`
+}
+
+func (m *LegacyMember) GetSchemaName() string {
+	return "member"
+}
+
+func TestRegistryLookup(t *testing.T) {
+	conn := newConnection(newConfigs(), nil)
+
+	mdl, err := conn.Register(new(Member))
+	assert.NoError(t, err)
+
+	again, err := conn.Register(new(Member))
+	assert.NoError(t, err)
+	assert.Equal(t, mdl, again)
+
+	for _, key := range []interface{}{"Member", "member", reflect.TypeOf(Member{}), new(Member)} {
+		found, err := conn.Lookup(key)
+		assert.NoError(t, err)
+		assert.Equal(t, mdl, found)
+	}
+
+	_, err = conn.Lookup("Profile")
+	assert.IsType(t, &NotRegisteredError{}, err)
+
+	_, err = conn.Lookup(42)
+	assert.IsType(t, &InvalidParamsError{}, err)
+
+	assert.Panics(t, func() { conn.M("Profile") })
+}
+
+func TestRegistryDuplicateCollection(t *testing.T) {
+	conn := newConnection(newConfigs(), nil)
+	assert.NoError(t, conn.BatchRegister(new(Member), new(Profile)))
+
+	_, err := conn.Register(new(LegacyMember))
+	assert.IsType(t, &DuplicateModelError{}, err)
+}
+
+func TestRegistryConcurrent(t *testing.T) {
+	conn := newConnection(newConfigs(), nil)
+
+	var wg sync.WaitGroup
+	models := make([]Model, 10)
+	for i := range models {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			mdl, err := conn.Register(new(Member))
+			assert.NoError(t, err)
+			models[i] = mdl
+
+			_, err = conn.Lookup("Member")
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, mdl := range models {
+		assert.Equal(t, models[0], mdl)
+	}
+}
@@ -0,0 +1,166 @@
+package monger
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// SaveOption configures a Save
+type SaveOption func(*saveOptions)
+
+type saveOptions struct {
+	insert bool
+}
+
+// InsertIfNew makes Save create a document which was neither found nor
+// saved before.
+func InsertIfNew() SaveOption {
+	return func(o *saveOptions) {
+		o.insert = true
+	}
+}
+
+// track takes the snapshot of the document that Save compares it to
+func (s *BaseSchema) track() error {
+	if s.value == nil {
+		return &NotInitDocumentError{NewError("You neet init this document before to track it")}
+	}
+
+	snapshot := make(map[string][]byte)
+	for column, value := range documentValues(s.value) {
+		data, err := encodeColumn(value)
+		if err != nil {
+			return err
+		}
+		snapshot[column] = data
+	}
+	s.snapshot = snapshot
+
+	return nil
+}
+
+func (s *BaseSchema) isTracked() bool {
+	return s.snapshot != nil
+}
+
+// changes returns the $set and $unset of the columns which changed since
+// the snapshot, the columns an omitempty field leaves out are unset.
+func (s *BaseSchema) changes() (bson.M, error) {
+	if s.value == nil || s.snapshot == nil {
+		return bson.M{}, nil
+	}
+
+	values := documentValues(s.value)
+	set, unset := bson.M{}, bson.M{}
+	for column, value := range values {
+		data, err := encodeColumn(value)
+		if err != nil {
+			return nil, err
+		}
+
+		if before, ok := s.snapshot[column]; !ok || !bytes.Equal(before, data) {
+			set[column] = value
+		}
+	}
+
+	for column := range s.snapshot {
+		if _, ok := values[column]; !ok {
+			unset[column] = ""
+		}
+	}
+
+	if err := encryptColumns(GetSchemaStruct(s.value), set); err != nil {
+		return nil, err
+	}
+
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	return update, nil
+}
+
+func encodeColumn(value interface{}) ([]byte, error) {
+	return bson.Marshal(bson.M{"v": value})
+}
+
+/*
+Save writes the changes of a document found or saved before with a $set of
+the changed columns and an $unset of the omitempty columns set to zero:
+
+	member := new(Member)
+	MemberModel.FindByID(id, member)
+	member.Age = 0
+	err := MemberModel.Save(member)
+
+A document without changes is not written. A document monger did not find
+is updated with all its columns, or created with InsertIfNew. A copy of a
+document found is compared to the snapshot of the original one.
+*/
+func (q *query) Save(doc interface{}, options ...SaveOption) error {
+	d, ok := doc.(Schemer)
+	if !ok {
+		return &InvalidParamsError{NewError("Document must be schemer")}
+	}
+	// a copy of a document found still refers to the original one
+	d.Init(doc)
+
+	opts := saveOptions{}
+	for _, option := range options {
+		option(&opts)
+	}
+
+	if !d.isTracked() {
+		if opts.insert {
+			return q.Create(doc)
+		}
+
+		id, ok := documentID(q.schemaStruct, doc)
+		if !ok || isZero(reflect.ValueOf(id)) {
+			return &InvalidIdError{NewError(fmt.Sprintf("[monger] the %T to save has no primary key, use InsertIfNew to create it", doc))}
+		}
+
+		return q.Update(bson.M{"_id": id}, doc)
+	}
+
+	if err := beforeUpdateHooks(q.context(), doc); err != nil {
+		return err
+	}
+	if err := validateUpdate(q.schemaStruct, doc); err != nil {
+		return err
+	}
+	if !d.IsUpdated() {
+		return nil
+	}
+
+	if err := d.beforeUpdate(doc); err != nil {
+		return err
+	}
+	filter, restore, err := versionUpdate(q.schemaStruct, bson.M{"$set": doc})
+	if err != nil {
+		return err
+	}
+
+	update, err := d.changes()
+	if err != nil {
+		return err
+	}
+
+	id, _ := documentID(q.schemaStruct, doc)
+	if err := q.updateFiltered(bson.M{"_id": id}, update, filter, restore); err != nil {
+		return err
+	}
+
+	if err := d.afterUpdate(); err != nil {
+		return err
+	}
+
+	return afterUpdateHooks(q.context(), doc)
+}
@@ -0,0 +1,38 @@
+package monger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type Note struct {
+	Schema `json:",inline" bson:",inline"`
+	Title  string   `json:"title" bson:"title"`
+	Body   string   `json:"body,omitempty" bson:"body,omitempty"`
+	Tags   []string `json:"tags" bson:"tags"`
+}
+
+func TestChanges(t *testing.T) {
+	note := &Note{Schema: Schema{ID: bson.NewObjectId()}, Title: "draft", Body: "text", Tags: []string{"a"}}
+	note.Init(note)
+
+	update, err := note.changes()
+	assert.NoError(t, err)
+	assert.Empty(t, update)
+	assert.False(t, note.isTracked())
+
+	assert.NoError(t, note.track())
+	assert.False(t, note.IsUpdated())
+
+	note.Title = "final"
+	note.Body = ""
+	note.Tags[0] = "b"
+	update, err = note.changes()
+	assert.NoError(t, err)
+	assert.Equal(t, bson.M{
+		"$set":   bson.M{"title": "final", "tags": []string{"b"}},
+		"$unset": bson.M{"body": ""},
+	}, update)
+}
@@ -1,7 +1,6 @@
 package monger
 
 import (
-	"fmt"
 	"reflect"
 	"time"
 
@@ -12,6 +11,16 @@ type SchemaNameGetter interface {
 	GetSchemaName() string
 }
 
+// SchemaConnectionGetter binds a schema to a named connection, see ConnectionName
+type SchemaConnectionGetter interface {
+	GetConnectionName() string
+}
+
+// SchemaDatabaseGetter binds a schema to a database, default is the dial database
+type SchemaDatabaseGetter interface {
+	GetDatabaseName() string
+}
+
 type Schemer interface {
 	Init(value interface{})
 	beforeCreate(interface{}) error
@@ -21,18 +30,46 @@ type Schemer interface {
 	afterUpdate() error
 	IsUpdated() bool
 	IsEmpty() bool
+
+	track() error
+	isTracked() bool
+	changes() (bson.M, error)
 }
 
+// Schema is the base of a schema whose primary key is an ObjectId
 type Schema struct {
-	ID        bson.ObjectId `json:"id" bson:"_id,omitempty"`
-	CreatedAt time.Time     `json:"created_at" bson:"created_at,omitempty"`
-	UpdatedAt time.Time     `json:"updated_at" bson:"updated_at,omitempty"`
-	Deleted   bool          `json:"-" bson:"deleted"`
-	isUpdated bool
-	value     interface{}
+	ID         bson.ObjectId `json:"id" bson:"_id,omitempty"`
+	BaseSchema `json:",inline" bson:",inline"`
 }
 
-func (s *Schema) Init(v interface{}) {
+/*
+BaseSchema is the base of a schema which declares its own primary key, the
+field of the _id column. Its generator is set with the id tag: objectid,
+uuidv4, uuidv7 or a name registered with RegisterIDGenerator, or by the
+NewID method of an IDGenerator:
+
+	type Device struct {
+		monger.BaseSchema `json:",inline" bson:",inline"`
+		ID                string `json:"id" bson:"_id" monger:"id=uuidv7"`
+	}
+
+A primary key without generator must be set before the document is created,
+an ObjectId primary key defaults to the objectid generator.
+*/
+type BaseSchema struct {
+	CreatedAt time.Time  `json:"created_at" bson:"created_at,omitempty"`
+	UpdatedAt time.Time  `json:"updated_at" bson:"updated_at,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	DeletedBy string     `json:"deleted_by,omitempty" bson:"deleted_by,omitempty"`
+	// Deleted is the soft delete flag of LegacySoftDeletes
+	Deleted bool `json:"-" bson:"deleted,omitempty"`
+	value   interface{}
+	// snapshot is the encoded columns of the document when it was last
+	// found or saved, see Model.Save
+	snapshot map[string][]byte
+}
+
+func (s *BaseSchema) Init(v interface{}) {
 	s.value = v
 }
 
@@ -52,49 +89,45 @@ func getSchemaTypeName(schema interface{}) string {
 	return snakeString(typeName)
 }
 
-func (s *Schema) beforeCreate(value interface{}) error {
-	s.isUpdated = false
+func (s *BaseSchema) beforeCreate(value interface{}) error {
 	now := time.Now()
 
-	fmt.Println("Before Create")
-	if len(s.ID) == 0 {
-		fmt.Println("After Create")
-		s.ID = bson.NewObjectId()
+	if err := assignID(value); err != nil {
+		return err
 	}
 
-	s.CreatedAt = now
-	s.UpdatedAt = now
+	columns := GetSchemaStruct(value).timestamps()
+	if err := setTimestamps(value, now, columns.CreatedAt, columns.UpdatedAt); err != nil {
+		return err
+	}
 	s.Deleted = false
 	if s.value == nil {
 		s.value = value
 	}
 
-	return nil
+	return applyDefaults(value)
 }
 
-func (s *Schema) afterCreate() error {
-	s.isUpdated = true
-	return nil
+func (s *BaseSchema) afterCreate() error {
+	return s.track()
 }
 
-func (s *Schema) beforeUpdate(value interface{}) error {
+func (s *BaseSchema) beforeUpdate(value interface{}) error {
 	if s.value == nil {
 		s.value = value
 	}
-	s.isUpdated = false
 
-	s.UpdatedAt = time.Now()
-	return nil
+	return setTimestamps(value, time.Now(), GetSchemaStruct(value).timestamps().UpdatedAt)
 }
 
-func (s *Schema) afterUpdate() error {
-	// panic("not implemented")
-	s.isUpdated = true
-	return nil
+func (s *BaseSchema) afterUpdate() error {
+	return s.track()
 }
 
-func (s *Schema) IsUpdated() bool {
-	return s.isUpdated
+// IsUpdated tells if the document changed since it was found or saved
+func (s *BaseSchema) IsUpdated() bool {
+	update, err := s.changes()
+	return err == nil && len(update) > 0
 }
 
 func (s *Schema) IsEmpty() bool {
@@ -105,15 +138,37 @@ func (s *Schema) IsEmpty() bool {
 	return false
 }
 
-func (s *Schema) GetBSON() (interface{}, error) {
-	mapData := make(map[string]interface{})
+// IsEmpty tells if the primary key of the document is not set, the
+// document must be initialized
+func (s *BaseSchema) IsEmpty() bool {
+	if s.value == nil {
+		return true
+	}
 
+	id, ok := documentID(GetSchemaStruct(s.value), s.value)
+	return !ok || isZero(reflect.ValueOf(id))
+}
+
+func (s *BaseSchema) GetBSON() (interface{}, error) {
 	if s.value == nil {
-		return mapData, &NotInitDocumentError{NewError("You neet init this document before to bson")}
+		return make(map[string]interface{}), &NotInitDocumentError{NewError("You neet init this document before to bson")}
+	}
+
+	values := documentValues(s.value)
+	if err := encryptColumns(GetSchemaStruct(s.value), values); err != nil {
+		return values, err
 	}
+
+	return values, nil
+}
+
+// documentValues returns the values of the columns of a document
+func documentValues(doc interface{}) map[string]interface{} {
+	mapData := make(map[string]interface{})
+
 	// fmt.Println(doc.value)
-	docStruct := GetSchemaStruct(s.value)
-	docv := reflect.ValueOf(s.value)
+	docStruct := GetSchemaStruct(doc)
+	docv := reflect.ValueOf(doc)
 	if docv.Type().Kind() == reflect.Ptr {
 		docv = docv.Elem()
 	}
@@ -148,5 +203,5 @@ func (s *Schema) GetBSON() (interface{}, error) {
 	}
 	// fmt.Println("monger GetBSON:", mapData)
 	// log.Println("monger GetBSON:", mapData)
-	return mapData, nil
+	return mapData
 }
@@ -32,6 +32,10 @@ type SchemaField struct {
 	Zero               reflect.Value
 	RelationshipStruct *SchemaStruct
 	IsSlice            bool
+	Rules              []*Rule
+	// defaultValue builds the value of the default tag
+	defaultValue func() reflect.Value
+	defaultErr   error
 }
 
 func GetSchemaStruct(schema interface{}, prefixAs ...string) *SchemaStruct {
@@ -97,9 +101,10 @@ func GetSchemaStruct(schema interface{}, prefixAs ...string) *SchemaStruct {
 				schemaField.IsIgnored = true
 			}
 
-			if _, ok := tagMap["DEFAULT"]; ok {
-				// schemaField.
-				// default value
+			schemaField.Rules = parseRules(tagMap)
+
+			if text, ok := tagMap["DEFAULT"]; ok {
+				schemaField.defaultValue, schemaField.defaultErr = parseDefault(field.Type, text)
 			}
 			if name, ok := tagMap["COLUMN"]; ok {
 				schemaField.ColumnName = name
@@ -111,9 +116,11 @@ func GetSchemaStruct(schema interface{}, prefixAs ...string) *SchemaStruct {
 				inlineSchemaStruct := GetSchemaStruct(field.Type)
 
 				for _, inlineField := range inlineSchemaStruct.Fields {
-					inlineField.IsInline = true
-					inlineField.InlineIndex = []int{i, inlineField.Index}
-					schemaStruct.Fields = append(schemaStruct.Fields, inlineField)
+					// the fields of the inline struct are cached with it
+					f := *inlineField
+					f.IsInline = true
+					f.InlineIndex = append([]int{i}, inlineField.InlineIndex...)
+					schemaStruct.Fields = append(schemaStruct.Fields, &f)
 				}
 
 				continue
@@ -0,0 +1,235 @@
+package monger
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// DefaultSequenceCollection is the collection of the sequence counters
+const DefaultSequenceCollection = "monger_counters"
+
+/*
+Sequence configures a sequence of the sequence tag, the values of a sequence
+are Start, Start+Step, Start+2*Step...
+
+	monger.RegisterSequence("invoice_no", monger.Sequence{
+		Start: 1000,
+		Scope: func(doc interface{}) string {
+			return doc.(*Invoice).Year
+		},
+	})
+
+	type Invoice struct {
+		monger.Schema `json:",inline" bson:",inline"`
+		Year          string `json:"year" bson:"year"`
+		Number        int64  `json:"number" bson:"number" monger:"sequence=invoice_no"`
+	}
+
+A sequence which is not registered starts at 1 with a step of 1.
+*/
+type Sequence struct {
+	// Start is the first value, default is 1
+	Start int64
+	// Step between two values, default is 1
+	Step int64
+	// Scope splits the sequence, every scope returned for a document has
+	// its own counter
+	Scope func(doc interface{}) string
+}
+
+var sequences = struct {
+	sync.RWMutex
+	byName map[string]Sequence
+}{byName: map[string]Sequence{}}
+
+// RegisterSequence registers the configuration of a sequence by name
+func RegisterSequence(name string, sequence Sequence) {
+	sequences.Lock()
+	defer sequences.Unlock()
+
+	sequences.byName[name] = sequence
+}
+
+func getSequence(name string) Sequence {
+	sequences.RLock()
+	defer sequences.RUnlock()
+
+	sequence := sequences.byName[name]
+	if sequence.Start == 0 {
+		sequence.Start = 1
+	}
+	if sequence.Step == 0 {
+		sequence.Step = 1
+	}
+
+	return sequence
+}
+
+// counter is a document of the sequence collection, Seq is the count of
+// the allocated values.
+type counter struct {
+	ID  string `bson:"_id"`
+	Seq int64  `bson:"seq"`
+}
+
+// sequenceFields returns the fields of the sequence tags
+func (s *SchemaStruct) sequenceFields() []*SchemaField {
+	fields := make([]*SchemaField, 0)
+	for _, field := range s.Fields {
+		if _, ok := field.TagMap["SEQUENCE"]; ok {
+			fields = append(fields, field)
+		}
+	}
+
+	return fields
+}
+
+// sequenceTarget is a field of a document waiting for a value
+type sequenceTarget struct {
+	value reflect.Value
+}
+
+// assignSequences sets the next values of their sequences on the zero
+// sequence fields of docs, the values of a sequence and scope are
+// allocated with a single update of the counter.
+func (q *query) assignSequences(docs ...interface{}) error {
+	fields := q.schemaStruct.sequenceFields()
+	if len(fields) == 0 {
+		return nil
+	}
+
+	targets := map[string][]sequenceTarget{}
+	names := map[string]string{}
+	for _, field := range fields {
+		name := field.TagMap["SEQUENCE"]
+		if name == "" {
+			return &InvalidParamsError{NewError(fmt.Sprintf("[monger] the sequence of %s has no name", field.Name))}
+		}
+
+		if !isSequenceKind(field.Struct.Type.Kind()) {
+			return &InvalidParamsError{NewError(fmt.Sprintf("[monger] the sequence field %s must be an integer", field.Name))}
+		}
+
+		sequence := getSequence(name)
+		for _, doc := range docs {
+			docv := reflect.ValueOf(doc)
+			for docv.Kind() == reflect.Ptr {
+				docv = docv.Elem()
+			}
+
+			value := docv.FieldByIndex(field.InlineIndex)
+			if !isZero(value) {
+				continue
+			}
+
+			key := name
+			if sequence.Scope != nil {
+				if scope := sequence.Scope(doc); scope != "" {
+					key = name + ":" + scope
+				}
+			}
+			targets[key] = append(targets[key], sequenceTarget{value: value})
+			names[key] = name
+		}
+	}
+
+	keys := make([]string, 0, len(targets))
+	for key := range targets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		sequence := getSequence(names[key])
+		first, err := q.allocateSequence(key, int64(len(targets[key])))
+		if err != nil {
+			return err
+		}
+
+		for i, target := range targets[key] {
+			next := sequence.Start + (first+int64(i)-1)*sequence.Step
+			if target.value.Kind() >= reflect.Uint && target.value.Kind() <= reflect.Uint64 {
+				target.value.SetUint(uint64(next))
+			} else {
+				target.value.SetInt(next)
+			}
+		}
+	}
+
+	return nil
+}
+
+// allocateSequence reserves n values of the counter key and returns the
+// count of the first one.
+func (q *query) allocateSequence(key string, n int64) (int64, error) {
+	counters, err := q.counters()
+	if err != nil {
+		return 0, err
+	}
+
+	return incrementCounter(q.context(), counters, key, n)
+}
+
+// incrementCounter adds n to the counter key and returns the count of the
+// first value added. The concurrent upserts of a new counter can both
+// insert it, the one failing with a duplicate key is retried once as an
+// update of the counter the other inserted.
+func incrementCounter(ctx context.Context, counters FindAndModifier, key string, n int64) (int64, error) {
+	change := Change{
+		Update:    bson.M{"$inc": bson.M{"seq": n}},
+		Upsert:    true,
+		ReturnNew: true,
+	}
+
+	var result counter
+	err := counters.FindAndModify(ctx, bson.M{"_id": key}, change, &result)
+	if _, ok := err.(*DuplicateDocumentError); ok {
+		err = counters.FindAndModify(ctx, bson.M{"_id": key}, change, &result)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return result.Seq - n + 1, nil
+}
+
+// counters returns the sequence collection next to the collection of the
+// query, it is routed to the tenant of the query like the schema.
+func (q *query) counters() (FindAndModifier, error) {
+	sc, ok := q.collection.(*sessionCollection)
+	if !ok {
+		return nil, &InvalidParamsError{NewError(fmt.Sprintf("[monger] the collection %T has no sequences", q.collection))}
+	}
+
+	counters := *sc
+	counters.name = sc.conn.Config.sequenceCollection()
+	counters.setup = nil
+	if q.tenant != "" {
+		counters.tenant = q.tenant
+	}
+
+	return &counters, nil
+}
+
+func (c *Config) sequenceCollection() string {
+	if c == nil || c.Sequences == "" {
+		return DefaultSequenceCollection
+	}
+
+	return c.Sequences
+}
+
+func isSequenceKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,67 @@
+package monger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type Receipt struct {
+	Schema `json:",inline" bson:",inline"`
+	Code   string `json:"code" bson:"code" monger:"sequence=receipt"`
+}
+
+func TestSequenceDefaults(t *testing.T) {
+	sequence := getSequence("unregistered")
+	assert.Equal(t, int64(1), sequence.Start)
+	assert.Equal(t, int64(1), sequence.Step)
+
+	RegisterSequence("order_no", Sequence{Start: 100})
+	sequence = getSequence("order_no")
+	assert.Equal(t, int64(100), sequence.Start)
+	assert.Equal(t, int64(1), sequence.Step)
+}
+
+func TestSequenceField(t *testing.T) {
+	schemaStruct := GetSchemaStruct(new(Receipt))
+	fields := schemaStruct.sequenceFields()
+	assert.Len(t, fields, 1)
+	assert.Equal(t, "Code", fields[0].Name)
+
+	q := &query{schemaStruct: schemaStruct}
+	err := q.assignSequences(new(Receipt))
+	assert.IsType(t, &InvalidParamsError{}, err)
+}
+
+// racingCounters fails the first upserts with a duplicate key, like the
+// upsert which loses the insert of a new counter
+type racingCounters struct {
+	duplicates int
+	calls      int
+}
+
+func (c *racingCounters) FindAndModify(ctx context.Context, selector interface{}, change Change, result interface{}) error {
+	c.calls++
+	if c.calls <= c.duplicates {
+		return &DuplicateDocumentError{NewError("E11000 duplicate key error")}
+	}
+
+	seq := change.Update.(bson.M)["$inc"].(bson.M)["seq"].(int64)
+	*result.(*counter) = counter{ID: selector.(bson.M)["_id"].(string), Seq: 1 + seq}
+	return nil
+}
+
+func TestIncrementCounterRetry(t *testing.T) {
+	counters := &racingCounters{duplicates: 1}
+	first, err := incrementCounter(context.Background(), counters, "receipt", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), first)
+	assert.Equal(t, 2, counters.calls)
+
+	counters = &racingCounters{duplicates: 2}
+	_, err = incrementCounter(context.Background(), counters, "receipt", 2)
+	assert.IsType(t, &DuplicateDocumentError{}, err)
+	assert.Equal(t, 2, counters.calls)
+}
@@ -0,0 +1,320 @@
+package monger
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// sessionCollection is the collection of a model, it is not bound to a
+// session: every operation runs on a copy of the connection session which
+// is closed once the operation is done, so goroutines sharing a model do
+// not share a socket and a reopened connection is picked up.
+type sessionCollection struct {
+	conn           *connection
+	db             string
+	name           string
+	readPreference *ReadPreference
+	writeConcern   *WriteConcern
+	// tenant routes the operations, the tenant of the context is used
+	// when it is empty, shared collections are never routed
+	tenant string
+	shared bool
+	// setup runs before the first operation of each tenant, namespace is
+	// the "database.collection" of the tenant
+	setup func(ctx context.Context, coll Collection, namespace string) error
+}
+
+// acquire returns the collection on a copied session and the function
+// releasing the session.
+func (c *sessionCollection) acquire(ctx context.Context) (Collection, func(), error) {
+	coll, _, release, err := c.open(ctx)
+	return coll, release, err
+}
+
+// run calls fn with the collection of ctx and its namespace
+func (c *sessionCollection) run(ctx context.Context, fn func(coll Collection, namespace string) error) error {
+	coll, namespace, release, err := c.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return fn(coll, namespace)
+}
+
+// open routes the collection and copies the session
+func (c *sessionCollection) open(ctx context.Context) (Collection, string, func(), error) {
+	db, name, tenant := c.db, c.name, ""
+	if policy := c.conn.Config.Tenancy; policy != nil && !c.shared {
+		tenant = c.tenant
+		if tenant == "" && ctx != nil {
+			tenant, _ = TenantFromContext(ctx)
+		}
+
+		if tenant == "" && policy.Strict {
+			return nil, "", nil, ErrNoTenant
+		}
+
+		if tenant != "" {
+			var err error
+			if db, name, err = policy.tenantNamespace(c.conn.Config, tenant, db, name); err != nil {
+				return nil, "", nil, err
+			}
+		}
+	}
+
+	session, err := c.conn.copySession()
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	namespace := db
+	if namespace == "" {
+		namespace = dialDatabase(c.conn.Config)
+	}
+	namespace += "." + name
+
+	var coll Collection = session.DB(db).C(name)
+	if tenant != "" && c.conn.Config.Tenancy.CollectionPrefix {
+		coll = &prefixedCollection{coll, tenant + "_", c.conn.sharedCollection}
+	}
+
+	if tenant != "" && c.setup != nil {
+		err := c.conn.tenants.run(namespace, func() error {
+			return c.setup(ctx, coll, namespace)
+		})
+		if err != nil {
+			session.Close()
+			return nil, "", nil, err
+		}
+	}
+
+	if c.readPreference != nil || c.writeConcern != nil {
+		coll = coll.With(c.readPreference, c.writeConcern)
+	}
+
+	return coll, namespace, session.Close, nil
+}
+
+// forTenant returns a copy of the collection routed to tenant
+func (c *sessionCollection) forTenant(tenant string) *sessionCollection {
+	with := *c
+	with.tenant = tenant
+
+	return &with
+}
+
+func (c *sessionCollection) Name() string {
+	return c.name
+}
+
+func (c *sessionCollection) With(readPreference *ReadPreference, writeConcern *WriteConcern) Collection {
+	with := *c
+	if readPreference != nil {
+		with.readPreference = readPreference
+	}
+
+	if writeConcern != nil {
+		with.writeConcern = writeConcern
+	}
+
+	return &with
+}
+
+func (c *sessionCollection) Find(ctx context.Context, filter interface{}, opts *FindOptions) Cursor {
+	return &sessionCursor{ctx: ctx, collection: c, open: func(coll Collection) Cursor {
+		return coll.Find(ctx, filter, opts)
+	}}
+}
+
+func (c *sessionCollection) Aggregate(ctx context.Context, pipeline []bson.M) Cursor {
+	return &sessionCursor{ctx: ctx, collection: c, open: func(coll Collection) Cursor {
+		return coll.Aggregate(ctx, pipeline)
+	}}
+}
+
+func (c *sessionCollection) Count(ctx context.Context, filter interface{}) (int, error) {
+	coll, release, err := c.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	return coll.Count(ctx, filter)
+}
+
+func (c *sessionCollection) Insert(ctx context.Context, docs ...interface{}) error {
+	coll, release, err := c.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return coll.Insert(ctx, docs...)
+}
+
+func (c *sessionCollection) Update(ctx context.Context, selector interface{}, update interface{}) error {
+	coll, release, err := c.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return coll.Update(ctx, selector, update)
+}
+
+func (c *sessionCollection) UpdateAll(ctx context.Context, selector interface{}, update interface{}) (*ChangeInfo, error) {
+	coll, release, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return coll.UpdateAll(ctx, selector, update)
+}
+
+func (c *sessionCollection) Upsert(ctx context.Context, selector interface{}, update interface{}) (*ChangeInfo, error) {
+	coll, release, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return coll.Upsert(ctx, selector, update)
+}
+
+func (c *sessionCollection) Remove(ctx context.Context, selector interface{}) error {
+	coll, release, err := c.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return coll.Remove(ctx, selector)
+}
+
+func (c *sessionCollection) RemoveAll(ctx context.Context, selector interface{}) (*ChangeInfo, error) {
+	coll, release, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return coll.RemoveAll(ctx, selector)
+}
+
+func (c *sessionCollection) FindAndModify(ctx context.Context, selector interface{}, change Change, result interface{}) error {
+	coll, release, err := c.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	modifier, ok := coll.(FindAndModifier)
+	if !ok {
+		return &InvalidParamsError{NewError(fmt.Sprintf("[monger] the collection %T can not find and modify", coll))}
+	}
+
+	return modifier.FindAndModify(ctx, selector, change, result)
+}
+
+// sessionCursor acquires a session when it is executed, not when it is built
+type sessionCursor struct {
+	ctx        context.Context
+	collection *sessionCollection
+	open       func(coll Collection) Cursor
+}
+
+func (c *sessionCursor) One(result interface{}) error {
+	coll, release, err := c.collection.acquire(c.ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return c.open(coll).One(result)
+}
+
+func (c *sessionCursor) All(result interface{}) error {
+	coll, release, err := c.collection.acquire(c.ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return c.open(coll).All(result)
+}
+
+// prefixedCollection is the collection of a tenant routed by prefix, its
+// aggregations look up the collections of the tenant.
+type prefixedCollection struct {
+	Collection
+	prefix string
+	// shared tells if a collection is shared by the tenants
+	shared func(name string) bool
+}
+
+func (c *prefixedCollection) With(readPreference *ReadPreference, writeConcern *WriteConcern) Collection {
+	return &prefixedCollection{c.Collection.With(readPreference, writeConcern), c.prefix, c.shared}
+}
+
+func (c *prefixedCollection) FindAndModify(ctx context.Context, selector interface{}, change Change, result interface{}) error {
+	modifier, ok := c.Collection.(FindAndModifier)
+	if !ok {
+		return &InvalidParamsError{NewError(fmt.Sprintf("[monger] the collection %T can not find and modify", c.Collection))}
+	}
+
+	return modifier.FindAndModify(ctx, selector, change, result)
+}
+
+func (c *prefixedCollection) Indexes(ctx context.Context) ([]Index, error) {
+	manager, err := collectionIndexes(c.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	return manager.Indexes(ctx)
+}
+
+func (c *prefixedCollection) EnsureIndex(ctx context.Context, index Index) error {
+	manager, err := collectionIndexes(c.Collection)
+	if err != nil {
+		return err
+	}
+
+	return manager.EnsureIndex(ctx, index)
+}
+
+func (c *prefixedCollection) DropIndex(ctx context.Context, name string) error {
+	manager, err := collectionIndexes(c.Collection)
+	if err != nil {
+		return err
+	}
+
+	return manager.DropIndex(ctx, name)
+}
+
+func (c *prefixedCollection) SetIndexExpiry(ctx context.Context, name string, expireAfter time.Duration) error {
+	expiry, ok := c.Collection.(IndexExpiryManager)
+	if !ok {
+		return &InvalidParamsError{NewError(fmt.Sprintf("[monger] the collection %T can not change the expiry of an index", c.Collection))}
+	}
+
+	return expiry.SetIndexExpiry(ctx, name, expireAfter)
+}
+
+func (c *prefixedCollection) SetValidator(ctx context.Context, validator Validator) error {
+	manager, err := collectionValidators(c.Collection)
+	if err != nil {
+		return err
+	}
+
+	return manager.SetValidator(ctx, validator)
+}
+
+func (c *prefixedCollection) Aggregate(ctx context.Context, pipeline []bson.M) Cursor {
+	return c.Collection.Aggregate(ctx, prefixLookups(pipeline, c.prefix, c.shared))
+}
@@ -0,0 +1,166 @@
+package monger
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// TenantShared is implemented by the schemas shared by every tenant, their
+// queries are never routed.
+type TenantShared interface {
+	IsTenantShared() bool
+}
+
+type tenantKey struct{}
+
+/*
+WithTenant returns a context carrying tenant, the queries bound to it with
+WithContext are routed to the tenant:
+
+	ctx := monger.WithTenant(r.Context(), "acme")
+	MemberModel.WithContext(ctx).FindAll(&members)
+*/
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant carried by ctx
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	return tenant, ok && tenant != ""
+}
+
+func isTenantShared(schema interface{}) bool {
+	shared, ok := schema.(TenantShared)
+	return ok && shared.IsTenantShared()
+}
+
+// tenantInvalidChars are the characters of a tenant id which MongoDB does
+// not allow in a database name
+const tenantInvalidChars = "/\\. \"$\x00"
+
+// maxDatabaseName is the length of the longest database name in bytes
+const maxDatabaseName = 64
+
+// tenantNamespace returns the database and the collection of a tenant, it
+// returns an InvalidParamsError for a tenant id which can not be part of a
+// database name.
+func (p *TenantPolicy) tenantNamespace(config *Config, tenant string, db string, name string) (string, string, error) {
+	if strings.ContainsAny(tenant, tenantInvalidChars) {
+		return "", "", &InvalidParamsError{NewError(fmt.Sprintf("[monger] the tenant id %q has a character a database name can not have", tenant))}
+	}
+
+	if p.CollectionPrefix {
+		return db, tenant + "_" + name, nil
+	}
+
+	if p.Database != nil {
+		db = p.Database(tenant)
+	} else {
+		db = fmt.Sprintf("%s_%s", dialDatabase(config), tenant)
+	}
+
+	if len(db) > maxDatabaseName {
+		return "", "", &InvalidParamsError{NewError(fmt.Sprintf("[monger] the database name %s of the tenant %q is longer than %d bytes", db, tenant, maxDatabaseName))}
+	}
+
+	return db, name, nil
+}
+
+// dialDatabase is the database of the sessions, the empty database name
+func dialDatabase(config *Config) string {
+	if config.DialInfo != nil {
+		return config.DialInfo.Database
+	}
+
+	return config.DBName
+}
+
+// tenantSetups remembers the tenant collections prepared by a connection.
+// MongoDB creates a tenant database on its first write, the setup of a
+// collection runs before the first operation of each tenant.
+type tenantSetups struct {
+	mu     sync.Mutex
+	setups map[string]*tenantSetup
+}
+
+// tenantSetup is the setup of a tenant collection, the operations of the
+// tenant wait for it while the other tenants go on. A failed setup runs
+// again.
+type tenantSetup struct {
+	mu   sync.Mutex
+	done bool
+}
+
+func (s *tenantSetups) run(key string, setup func() error) error {
+	s.mu.Lock()
+	if s.setups == nil {
+		s.setups = make(map[string]*tenantSetup)
+	}
+	ts, ok := s.setups[key]
+	if !ok {
+		ts = &tenantSetup{}
+		s.setups[key] = ts
+	}
+	s.mu.Unlock()
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.done {
+		return nil
+	}
+
+	if err := setup(); err != nil {
+		return err
+	}
+	ts.done = true
+
+	return nil
+}
+
+// sharedCollection tells if the collection name is the one of a schema
+// shared by the tenants
+func (conn *connection) sharedCollection(name string) bool {
+	for _, mdl := range conn.registry.models() {
+		if c, ok := mdl.(*model).collection.(*sessionCollection); ok && c.shared && c.name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// prefixLookups prefixes the collections of the $lookup stages of pipeline,
+// the shared collections are kept.
+func prefixLookups(pipeline []bson.M, prefix string, shared func(name string) bool) []bson.M {
+	prefixed := make([]bson.M, 0, len(pipeline))
+	for _, stage := range pipeline {
+		lookup, ok := stage["$lookup"].(bson.M)
+		if !ok {
+			prefixed = append(prefixed, stage)
+			continue
+		}
+
+		l := bson.M{}
+		for k, v := range lookup {
+			l[k] = v
+		}
+
+		if from, ok := l["from"].(string); ok && !shared(from) {
+			l["from"] = prefix + from
+		}
+
+		if sub, ok := l["pipeline"].([]bson.M); ok {
+			l["pipeline"] = prefixLookups(sub, prefix, shared)
+		}
+
+		prefixed = append(prefixed, bson.M{"$lookup": l})
+	}
+
+	return prefixed
+}
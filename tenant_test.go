@@ -0,0 +1,113 @@
+package monger_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/iron-kit/monger"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type Customer struct {
+	monger.Schema `json:",inline" bson:",inline"`
+	Name          string        `json:"name,omitempty" bson:"name,omitempty"`
+	Address       *Address      `json:"address,omitempty" bson:"address,omitempty" monger:"hasOne,foreignKey=customer_id"`
+	PlanID        bson.ObjectId `json:"plan_id,omitempty" bson:"plan_id,omitempty"`
+	Plan          *Plan         `json:"plan,omitempty" bson:"plan,omitempty" monger:"belongTo,foreignKey=plan_id"`
+}
+
+type Address struct {
+	monger.Schema `json:",inline" bson:",inline"`
+	City          string        `json:"city,omitempty" bson:"city,omitempty"`
+	CustomerID    bson.ObjectId `json:"customer_id,omitempty" bson:"customer_id,omitempty"`
+}
+
+type Plan struct {
+	monger.Schema `json:",inline" bson:",inline"`
+	Name          string `json:"name,omitempty" bson:"name,omitempty"`
+}
+
+func (p *Plan) IsTenantShared() bool {
+	return true
+}
+
+func tenantConn(t *testing.T, policy monger.TenantPolicy) monger.Connection {
+	c := memConn(t, monger.ConnectionName("tenants"), monger.WithTenancy(policy))
+	assert.NoError(t, c.BatchRegister(new(Customer), new(Address), new(Plan)))
+
+	return c
+}
+
+func TestTenantDatabases(t *testing.T) {
+	c := tenantConn(t, monger.TenantPolicy{Strict: true})
+	CustomerModel := c.M("Customer")
+
+	assert.Equal(t, monger.ErrNoTenant, CustomerModel.Create(&Customer{Name: "alice"}))
+	assert.NoError(t, CustomerModel.ForTenant("acme").Create(&Customer{Name: "alice"}))
+	assert.NoError(t, c.M("Plan").Create(&Plan{Name: "free"}))
+
+	ctx := monger.WithTenant(context.Background(), "acme")
+	assert.Equal(t, 1, CustomerModel.WithContext(ctx).Count())
+	assert.Equal(t, 0, CustomerModel.ForTenant("globex").Count())
+	assert.Equal(t, 1, c.M("Plan").Count())
+
+	n, err := c.CloneSession().DB("monger_test_acme").C("customer").Count(context.Background(), bson.M{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestTenantIDs(t *testing.T) {
+	c := tenantConn(t, monger.TenantPolicy{Strict: true})
+	CustomerModel := c.M("Customer")
+
+	for _, tenant := range []string{"acme/eu", "acme\\eu", "acme.eu", "acme eu", "acme\"", "$acme", "acme\x00", strings.Repeat("a", 60)} {
+		err := CustomerModel.ForTenant(tenant).Create(&Customer{Name: "alice"})
+		assert.IsType(t, &monger.InvalidParamsError{}, err, "%q", tenant)
+
+		ctx := monger.WithTenant(context.Background(), tenant)
+		assert.IsType(t, &monger.InvalidParamsError{}, CustomerModel.WithContext(ctx).FindOne(new(Customer)), "%q", tenant)
+	}
+
+	assert.NoError(t, CustomerModel.ForTenant("acme-eu_1").Create(&Customer{Name: "alice"}))
+}
+
+func TestTenantCollectionPrefix(t *testing.T) {
+	c := tenantConn(t, monger.TenantPolicy{CollectionPrefix: true})
+	CustomerModel := c.M("Customer")
+
+	customer := &Customer{Name: "alice"}
+	assert.NoError(t, CustomerModel.ForTenant("acme").Create(customer))
+	assert.NoError(t, c.M("Address").ForTenant("acme").Create(&Address{City: "Oslo", CustomerID: customer.ID}))
+
+	found := new(Customer)
+	err := CustomerModel.ForTenant("acme").Where(bson.M{"_id": customer.ID}).Populate("Address").FindOne(found)
+	assert.NoError(t, err)
+	assert.Equal(t, "Oslo", found.Address.City)
+
+	n, err := c.CloneSession().DB("").C("acme_customer").Count(context.Background(), bson.M{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// without strict mode a query without tenant uses the collection of the schema
+	assert.Equal(t, 0, CustomerModel.Count())
+}
+
+func TestTenantSharedRelation(t *testing.T) {
+	c := tenantConn(t, monger.TenantPolicy{CollectionPrefix: true})
+	CustomerModel := c.M("Customer").ForTenant("acme")
+
+	plan := &Plan{Name: "free"}
+	assert.NoError(t, c.M("Plan").Create(plan))
+	customer := &Customer{Name: "alice", PlanID: plan.ID}
+	assert.NoError(t, CustomerModel.Create(customer))
+
+	// the plans are not prefixed, the lookup reads the shared collection
+	found := new(Customer)
+	err := CustomerModel.Where(bson.M{"_id": customer.ID}).Populate("Plan").FindOne(found)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found.Plan) {
+		assert.Equal(t, "free", found.Plan.Name)
+	}
+}
@@ -0,0 +1,235 @@
+package monger
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+/*
+Timestamps are the columns of the timestamps of a schema, an empty column
+keeps the default one and "-" disables it:
+
+	type Event struct {
+		monger.Schema `json:",inline" bson:",inline"`
+		Created       time.Time `json:"created" bson:"created"`
+	}
+
+	func (e *Event) GetTimestamps() monger.Timestamps {
+		return monger.Timestamps{CreatedAt: "created", UpdatedAt: "-"}
+	}
+
+A renamed column needs a field of its own, the field of BaseSchema is left
+empty. Disabling DeletedAt disables the soft deletes of the schema.
+*/
+type Timestamps struct {
+	// CreatedAt is set when a document is created, default is created_at
+	CreatedAt string
+	// UpdatedAt is set when a document is created or updated, default is
+	// updated_at
+	UpdatedAt string
+	// DeletedAt is set by a soft delete, default is deleted_at
+	DeletedAt string
+	// DeletedBy is the actor of the context of a soft delete, default is
+	// deleted_by, see WithActor
+	DeletedBy string
+}
+
+// SchemaTimestampsGetter configures the timestamps of a schema
+type SchemaTimestampsGetter interface {
+	GetTimestamps() Timestamps
+}
+
+// legacyDeletedColumn is the flag of the soft deletes before deleted_at
+const legacyDeletedColumn = "deleted"
+
+var defaultTimestamps = Timestamps{
+	CreatedAt: "created_at",
+	UpdatedAt: "updated_at",
+	DeletedAt: "deleted_at",
+	DeletedBy: "deleted_by",
+}
+
+type actorKey struct{}
+
+// WithActor returns a context carrying who runs the queries bound to it, a
+// soft delete records the actor in the DeletedBy column.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor carried by ctx
+func ActorFromContext(ctx context.Context) (string, bool) {
+	actor, ok := ctx.Value(actorKey{}).(string)
+	return actor, ok && actor != ""
+}
+
+// timestamps returns the columns of the timestamps of the schema, the
+// disabled ones are empty.
+func (s *SchemaStruct) timestamps() Timestamps {
+	columns := defaultTimestamps
+	if s == nil || s.Type == nil {
+		return columns
+	}
+
+	getter, ok := reflect.New(s.Type).Interface().(SchemaTimestampsGetter)
+	if !ok {
+		return columns
+	}
+
+	custom := getter.GetTimestamps()
+	columns.CreatedAt = timestampColumn(custom.CreatedAt, columns.CreatedAt)
+	columns.UpdatedAt = timestampColumn(custom.UpdatedAt, columns.UpdatedAt)
+	columns.DeletedAt = timestampColumn(custom.DeletedAt, columns.DeletedAt)
+	columns.DeletedBy = timestampColumn(custom.DeletedBy, columns.DeletedBy)
+
+	return columns
+}
+
+func timestampColumn(column string, fallback string) string {
+	switch column {
+	case "":
+		return fallback
+	case "-":
+		return ""
+	}
+
+	return column
+}
+
+// columnField returns the field of a column, nil without one
+func (s *SchemaStruct) columnField(column string) *SchemaField {
+	if s == nil {
+		return nil
+	}
+
+	for _, field := range s.Fields {
+		if field.columnName() == column {
+			return field
+		}
+	}
+
+	return nil
+}
+
+// setTimestamps sets the fields of the columns of doc to now, the disabled
+// columns are skipped.
+func setTimestamps(doc interface{}, now time.Time, columns ...string) error {
+	schemaStruct := GetSchemaStruct(doc)
+	docv := reflect.ValueOf(doc)
+	for docv.Kind() == reflect.Ptr {
+		docv = docv.Elem()
+	}
+
+	for _, column := range columns {
+		if column == "" {
+			continue
+		}
+
+		field := schemaStruct.columnField(column)
+		if field == nil {
+			return &InvalidParamsError{NewError(fmt.Sprintf("[monger] %T has no field of the timestamp %q", doc, column))}
+		}
+
+		value := docv.FieldByIndex(field.InlineIndex)
+		switch value.Type() {
+		case typeTime:
+			value.Set(reflect.ValueOf(now))
+		case reflect.PtrTo(typeTime):
+			value.Set(reflect.ValueOf(&now))
+		default:
+			return &InvalidParamsError{NewError(fmt.Sprintf("[monger] the timestamp field %s of %T must be a time", field.Name, doc))}
+		}
+	}
+
+	return nil
+}
+
+// legacyDeleted tells if the soft deletes also write and read the deleted
+// flag, see LegacySoftDeletes.
+func (q *query) legacyDeleted() bool {
+	sc, ok := q.collection.(*sessionCollection)
+	return ok && sc.conn.Config != nil && sc.conn.Config.LegacySoftDeletes
+}
+
+// softDeleteUpdate is the update of a soft delete
+func (q *query) softDeleteUpdate() bson.M {
+	columns := q.schemaStruct.timestamps()
+	set := bson.M{columns.DeletedAt: time.Now()}
+	if actor, ok := ActorFromContext(q.context()); ok && columns.DeletedBy != "" {
+		set[columns.DeletedBy] = actor
+	}
+	if q.legacyDeleted() {
+		set[legacyDeletedColumn] = true
+	}
+
+	return bson.M{"$set": set}
+}
+
+// restoreUpdate is the update of a restore
+func (q *query) restoreUpdate() bson.M {
+	columns := q.schemaStruct.timestamps()
+	unset := bson.M{columns.DeletedAt: ""}
+	if columns.DeletedBy != "" {
+		unset[columns.DeletedBy] = ""
+	}
+
+	update := bson.M{"$unset": unset}
+	if q.legacyDeleted() {
+		update["$set"] = bson.M{legacyDeletedColumn: false}
+	}
+
+	return update
+}
+
+// softDeleteWhere adds the condition of the trashed documents to where, or
+// of the others without WithTrashed. The condition is added with $and when
+// where already has one of its keys.
+func (q *query) softDeleteWhere(where bson.M) {
+	column := q.schemaStruct.timestamps().DeletedAt
+	legacy := q.legacyDeleted()
+
+	cond := bson.M{}
+	switch {
+	case q.onlyTrashed && legacy:
+		cond["$nor"] = []bson.M{{column: nil, legacyDeletedColumn: bson.M{"$ne": true}}}
+	case q.onlyTrashed:
+		cond[column] = bson.M{"$ne": nil}
+	case !q.withTrashed:
+		cond[column] = nil
+		if legacy {
+			cond[legacyDeletedColumn] = bson.M{"$ne": true}
+		}
+	}
+
+	for key := range cond {
+		if _, ok := where[key]; ok {
+			where["$and"] = appendCondition(where["$and"], cond)
+			return
+		}
+	}
+	for key, value := range cond {
+		where[key] = value
+	}
+}
+
+// appendCondition appends a condition to the list of an $and
+func appendCondition(and interface{}, cond bson.M) []interface{} {
+	list := make([]interface{}, 0)
+	switch v := and.(type) {
+	case []interface{}:
+		list = append(list, v...)
+	case []bson.M:
+		for _, item := range v {
+			list = append(list, item)
+		}
+	case nil:
+	default:
+		list = append(list, v)
+	}
+
+	return append(list, cond)
+}
@@ -0,0 +1,38 @@
+package monger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type Audit struct {
+	Schema  `json:",inline" bson:",inline"`
+	Created *time.Time `json:"created" bson:"created"`
+}
+
+func (a *Audit) GetTimestamps() Timestamps {
+	return Timestamps{CreatedAt: "created", DeletedBy: "-"}
+}
+
+func TestTimestamps(t *testing.T) {
+	assert.Equal(t, defaultTimestamps, GetSchemaStruct(new(Receipt)).timestamps())
+
+	columns := GetSchemaStruct(new(Audit)).timestamps()
+	assert.Equal(t, "created", columns.CreatedAt)
+	assert.Equal(t, "updated_at", columns.UpdatedAt)
+	assert.Equal(t, "deleted_at", columns.DeletedAt)
+	assert.Empty(t, columns.DeletedBy)
+
+	audit := new(Audit)
+	now := time.Now()
+	assert.NoError(t, setTimestamps(audit, now, columns.CreatedAt, columns.UpdatedAt))
+	if assert.NotNil(t, audit.Created) {
+		assert.Equal(t, now, *audit.Created)
+	}
+	assert.Equal(t, now, audit.UpdatedAt)
+	assert.True(t, audit.CreatedAt.IsZero())
+
+	assert.IsType(t, &InvalidParamsError{}, setTimestamps(audit, now, "missing"))
+}
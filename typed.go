@@ -0,0 +1,226 @@
+package monger
+
+import (
+	"context"
+	"reflect"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+/*
+TypedModel is the model of the schema T, its finds return *T and []T so a
+result of the wrong type does not compile:
+
+	members := monger.For[Member](conn)
+
+	member, err := members.FindOne(ctx, bson.M{"username": "ada"})
+	adults, err := members.Where(ctx, bson.M{"age": bson.M{"$gte": 18}}).
+		Sort("-age").
+		Limit(10).
+		All()
+
+Model returns the untyped model for the operations TypedModel lacks.
+*/
+type TypedModel[T any] struct {
+	model Model
+}
+
+/*
+For returns the typed model of the schema T on conn, it registers the
+schema when it is not registered yet. *T must be a Schemer:
+
+	type Member struct {
+		monger.Schema `json:",inline" bson:",inline"`
+		Username      string `json:"username" bson:"username"`
+	}
+
+	members := monger.For[Member](conn)
+
+For panics with the error of Register, like Connection.M.
+*/
+func For[T any, PT interface {
+	*T
+	Schemer
+}](conn Connection) TypedModel[T] {
+	mdl, err := conn.Lookup(reflect.TypeOf((*T)(nil)).Elem())
+	if _, ok := err.(*NotRegisteredError); ok {
+		mdl, err = conn.Register(PT(new(T)))
+	}
+
+	if err != nil {
+		panic(err)
+	}
+
+	return TypedModel[T]{model: mdl}
+}
+
+// Model returns the untyped model
+func (m TypedModel[T]) Model() Model {
+	return m.model
+}
+
+// Query returns a typed query bound to ctx
+func (m TypedModel[T]) Query(ctx context.Context) TypedQuery[T] {
+	return TypedQuery[T]{query: m.model.WithContext(ctx).(*query)}
+}
+
+// Where returns a typed query bound to ctx with the condition
+func (m TypedModel[T]) Where(ctx context.Context, condition bson.M) TypedQuery[T] {
+	return m.Query(ctx).Where(condition)
+}
+
+// FindOne returns the first document matching the condition
+func (m TypedModel[T]) FindOne(ctx context.Context, condition ...bson.M) (*T, error) {
+	return m.Where(ctx, firstCondition(condition)).One()
+}
+
+// FindAll returns the documents matching the condition
+func (m TypedModel[T]) FindAll(ctx context.Context, condition ...bson.M) ([]T, error) {
+	return m.Where(ctx, firstCondition(condition)).All()
+}
+
+// FindByID returns the document of the primary key id
+func (m TypedModel[T]) FindByID(ctx context.Context, id interface{}) (*T, error) {
+	id, err := toID(m.model.getSchemaStruct(), id)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Where(ctx, bson.M{"_id": id}).One()
+}
+
+// Count returns the number of documents matching the condition
+func (m TypedModel[T]) Count(ctx context.Context, condition ...bson.M) int {
+	return m.Where(ctx, firstCondition(condition)).Count()
+}
+
+// Create creates a document
+func (m TypedModel[T]) Create(ctx context.Context, doc *T) error {
+	return m.model.WithContext(ctx).Create(doc)
+}
+
+// CreateAll creates the documents with a single insert
+func (m TypedModel[T]) CreateAll(ctx context.Context, docs []*T) error {
+	return m.model.WithContext(ctx).Create(docs)
+}
+
+// Update updates the first document matching the condition with the
+// columns of doc
+func (m TypedModel[T]) Update(ctx context.Context, condition bson.M, doc *T) error {
+	return m.model.WithContext(ctx).Update(condition, doc)
+}
+
+// Save writes the changes of a document, see Model.Save
+func (m TypedModel[T]) Save(ctx context.Context, doc *T, options ...SaveOption) error {
+	return m.model.WithContext(ctx).Save(doc, options...)
+}
+
+// Delete deletes the first document matching the condition
+func (m TypedModel[T]) Delete(ctx context.Context, condition bson.M) error {
+	return m.Where(ctx, condition).filtered().Delete()
+}
+
+// DeleteAll deletes the documents matching the condition
+func (m TypedModel[T]) DeleteAll(ctx context.Context, condition bson.M) (*ChangeInfo, error) {
+	return m.Where(ctx, condition).filtered().DeleteAll()
+}
+
+func firstCondition(condition []bson.M) bson.M {
+	if len(condition) > 0 {
+		return condition[0]
+	}
+
+	return nil
+}
+
+// TypedQuery is a query of the documents of the schema T, every step
+// returns a new query so a query can be reused. Its conditions are applied
+// when it runs so OnlyTrashed can follow Where.
+type TypedQuery[T any] struct {
+	query      *query
+	conditions []bson.M
+}
+
+// Query returns the untyped query with the conditions
+func (q TypedQuery[T]) Query() Query {
+	return q.filtered()
+}
+
+func (q TypedQuery[T]) filtered() Query {
+	filtered := q.query.clone()
+	if len(q.conditions) == 0 {
+		return filtered.Where(nil)
+	}
+
+	for _, condition := range q.conditions {
+		filtered.Where(condition)
+	}
+
+	return filtered
+}
+
+// step returns a query with a copy of the query of q changed by change
+func (q TypedQuery[T]) step(change func(Query) Query) TypedQuery[T] {
+	next := q.query.clone()
+	change(next)
+
+	return TypedQuery[T]{query: next, conditions: q.conditions}
+}
+
+func (q TypedQuery[T]) Where(condition bson.M) TypedQuery[T] {
+	conditions := append(make([]bson.M, 0, len(q.conditions)+1), q.conditions...)
+	return TypedQuery[T]{query: q.query, conditions: append(conditions, condition)}
+}
+
+func (q TypedQuery[T]) Select(selector bson.M) TypedQuery[T] {
+	return q.step(func(query Query) Query { return query.Select(selector) })
+}
+
+func (q TypedQuery[T]) Populate(fields ...string) TypedQuery[T] {
+	return q.step(func(query Query) Query { return query.Populate(fields...) })
+}
+
+func (q TypedQuery[T]) Sort(fields ...string) TypedQuery[T] {
+	return q.step(func(query Query) Query { return query.Sort(fields...) })
+}
+
+func (q TypedQuery[T]) Skip(skip int) TypedQuery[T] {
+	return q.step(func(query Query) Query { return query.Skip(skip) })
+}
+
+func (q TypedQuery[T]) Limit(limit int) TypedQuery[T] {
+	return q.step(func(query Query) Query { return query.Limit(limit) })
+}
+
+func (q TypedQuery[T]) OnlyTrashed() TypedQuery[T] {
+	return q.step(func(query Query) Query { return query.OnlyTrashed() })
+}
+
+func (q TypedQuery[T]) ForTenant(tenant string) TypedQuery[T] {
+	return q.step(func(query Query) Query { return query.ForTenant(tenant) })
+}
+
+// One returns the first document of the query
+func (q TypedQuery[T]) One() (*T, error) {
+	doc := new(T)
+	if err := q.filtered().FindOne(doc); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+// All returns the documents of the query
+func (q TypedQuery[T]) All() ([]T, error) {
+	docs := make([]T, 0)
+	if err := q.filtered().FindAll(&docs); err != nil {
+		return nil, err
+	}
+
+	return docs, nil
+}
+
+// Count returns the number of documents of the query
+func (q TypedQuery[T]) Count() int {
+	return q.filtered().Count()
+}
@@ -0,0 +1,77 @@
+package monger_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/iron-kit/monger"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type Player struct {
+	monger.Schema `json:",inline" bson:",inline"`
+	Username      string `json:"username,omitempty" bson:"username,omitempty"`
+	Age           int    `json:"age,omitempty" bson:"age,omitempty"`
+}
+
+func TestTypedModel(t *testing.T) {
+	ctx := context.Background()
+	c := memConn(t)
+	players := monger.For[Player](c)
+	assert.Equal(t, c.M("Player"), players.Model())
+
+	ada := &Player{Username: "ada", Age: 36}
+	assert.NoError(t, players.Create(ctx, ada))
+	assert.NoError(t, players.CreateAll(ctx, []*Player{{Username: "bob", Age: 20}, {Username: "carol", Age: 28}}))
+
+	player, err := players.FindOne(ctx, bson.M{"username": "ada"})
+	assert.NoError(t, err)
+	assert.Equal(t, 36, player.Age)
+
+	player, err = players.FindByID(ctx, ada.ID.Hex())
+	assert.NoError(t, err)
+	assert.Equal(t, "ada", player.Username)
+
+	_, err = players.FindOne(ctx, bson.M{"username": "dave"})
+	assert.Equal(t, monger.ErrNotFound, err)
+
+	adults, err := players.Where(ctx, bson.M{"age": bson.M{"$gte": 21}}).Sort("-age").All()
+	assert.NoError(t, err)
+	if assert.Len(t, adults, 2) {
+		assert.Equal(t, "ada", adults[0].Username)
+		assert.Equal(t, "carol", adults[1].Username)
+	}
+	assert.Equal(t, 3, players.Count(ctx))
+
+	// the documents found are tracked for Save
+	adults[1].Age = 29
+	assert.NoError(t, players.Save(ctx, &adults[1]))
+	player, err = players.FindOne(ctx, bson.M{"username": "carol"})
+	assert.NoError(t, err)
+	assert.Equal(t, 29, player.Age)
+
+	assert.NoError(t, players.Delete(ctx, bson.M{"username": "bob"}))
+	assert.Equal(t, 2, players.Count(ctx))
+
+	trashed, err := players.Where(ctx, bson.M{"username": "bob"}).OnlyTrashed().All()
+	assert.NoError(t, err)
+	assert.Len(t, trashed, 1)
+
+	// a query is reused by the queries built from it
+	base := players.Query(ctx).Sort("username")
+	first := base.Limit(1)
+	_ = base.Skip(1).Where(bson.M{"username": "ada"})
+	all, err := base.All()
+	assert.NoError(t, err)
+	if assert.Len(t, all, 2) {
+		assert.Equal(t, "ada", all[0].Username)
+	}
+	one, err := first.All()
+	assert.NoError(t, err)
+	assert.Len(t, one, 1)
+	assert.Equal(t, 2, base.Count())
+
+	// the same model is returned once the schema is registered
+	assert.Equal(t, players.Model(), monger.For[Player](c).Model())
+}
@@ -0,0 +1,594 @@
+package monger
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+/*
+URI configures the connection from a standard connection string:
+
+	mongodb://[user[:password]@]host1[:port1][,...hostN[:portN]][/[database][?options]]
+	mongodb+srv://[user[:password]@]host[/[database][?options]]
+
+Unknown options, malformed values and options which conflict with each other
+make Connect return an error.
+*/
+func URI(uri string) ConfigOption {
+	return func(c *Config) {
+		if err := parseURI(uri, c); err != nil {
+			c.setError(err)
+		}
+	}
+}
+
+func newURIError(format string, args ...interface{}) error {
+	return &InvalidParamsError{NewError("[monger] invalid connection string: " + fmt.Sprintf(format, args...))}
+}
+
+const (
+	schemeStandard = "mongodb://"
+	schemeSRV      = "mongodb+srv://"
+)
+
+func parseURI(uri string, c *Config) error {
+	var rest string
+	switch {
+	case strings.HasPrefix(uri, schemeStandard):
+		rest = uri[len(schemeStandard):]
+	case strings.HasPrefix(uri, schemeSRV):
+		rest = uri[len(schemeSRV):]
+		c.SRV = true
+	default:
+		return newURIError("scheme must be mongodb:// or mongodb+srv://")
+	}
+
+	hostInfo, path := rest, ""
+	if i := strings.Index(rest, "/"); i >= 0 {
+		hostInfo, path = rest[:i], rest[i+1:]
+	} else if strings.Contains(rest, "?") {
+		return newURIError("options must follow a / after the hosts")
+	}
+
+	if i := strings.LastIndex(hostInfo, "@"); i >= 0 {
+		if err := parseUserInfo(hostInfo[:i], c); err != nil {
+			return err
+		}
+		hostInfo = hostInfo[i+1:]
+	}
+
+	hosts, err := parseHosts(hostInfo, c.SRV)
+	if err != nil {
+		return err
+	}
+	c.Hosts = hosts
+
+	database, query := path, ""
+	if i := strings.Index(path, "?"); i >= 0 {
+		database, query = path[:i], path[i+1:]
+	}
+
+	if database != "" {
+		if database, err = url.PathUnescape(database); err != nil {
+			return newURIError("database: %v", err)
+		}
+		if strings.ContainsAny(database, "/\\. \"$") {
+			return newURIError("database name %q contains an invalid character", database)
+		}
+		c.DBName = database
+	}
+
+	options, err := parseOptions(query)
+	if err != nil {
+		return err
+	}
+
+	if c.SRV && c.TLS == nil {
+		// TLS is on by default for seed lists
+		c.TLS = &TLSConfig{}
+	}
+
+	if err := applyOptions(options, c); err != nil {
+		return err
+	}
+
+	return validateConfig(c, options)
+}
+
+func parseUserInfo(userInfo string, c *Config) error {
+	user, password, hasPassword := userInfo, "", false
+	if i := strings.Index(userInfo, ":"); i >= 0 {
+		user, password, hasPassword = userInfo[:i], userInfo[i+1:], true
+	}
+
+	if strings.ContainsAny(user, "@:/") || strings.ContainsAny(password, "@:/") {
+		return newURIError("user name and password must be percent encoded")
+	}
+
+	var err error
+	if c.User, err = url.PathUnescape(user); err != nil {
+		return newURIError("user name: %v", err)
+	}
+
+	if c.User == "" {
+		return newURIError("user name can not be empty")
+	}
+
+	if hasPassword {
+		if c.Password, err = url.PathUnescape(password); err != nil {
+			return newURIError("password: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func parseHosts(hostInfo string, srv bool) ([]string, error) {
+	if hostInfo == "" {
+		return nil, newURIError("at least one host is required")
+	}
+
+	hosts := make([]string, 0)
+	for _, host := range strings.Split(hostInfo, ",") {
+		host, err := url.PathUnescape(host)
+		if err != nil {
+			return nil, newURIError("host: %v", err)
+		}
+
+		if host == "" {
+			return nil, newURIError("empty host in %q", hostInfo)
+		}
+
+		// unix domain socket
+		if strings.HasSuffix(host, ".sock") {
+			if srv {
+				return nil, newURIError("mongodb+srv:// does not accept a unix domain socket")
+			}
+			hosts = append(hosts, host)
+			continue
+		}
+
+		name, port := host, ""
+		if strings.HasPrefix(host, "[") {
+			end := strings.Index(host, "]")
+			if end < 0 {
+				return nil, newURIError("unterminated IPv6 address %q", host)
+			}
+			name = host[:end+1]
+			if remain := host[end+1:]; remain != "" {
+				if !strings.HasPrefix(remain, ":") {
+					return nil, newURIError("invalid host %q", host)
+				}
+				port = remain[1:]
+			}
+		} else if i := strings.LastIndex(host, ":"); i >= 0 {
+			name, port = host[:i], host[i+1:]
+			if strings.Contains(name, ":") {
+				return nil, newURIError("IPv6 address %q must be enclosed in brackets", host)
+			}
+		}
+
+		if name == "" || name == "[]" {
+			return nil, newURIError("invalid host %q", host)
+		}
+
+		if port != "" || strings.HasSuffix(host, ":") {
+			if srv {
+				return nil, newURIError("mongodb+srv:// host must not have a port")
+			}
+			n, err := strconv.Atoi(port)
+			if err != nil || n < 1 || n > 65535 {
+				return nil, newURIError("invalid port in %q", host)
+			}
+		}
+
+		hosts = append(hosts, host)
+	}
+
+	if srv && len(hosts) != 1 {
+		return nil, newURIError("mongodb+srv:// needs exactly one host")
+	}
+
+	return hosts, nil
+}
+
+// uriOptions are the options of a connection string, keyed by lower case name
+type uriOptions map[string][]string
+
+func (o uriOptions) has(name string) bool {
+	_, ok := o[name]
+	return ok
+}
+
+func parseOptions(query string) (uriOptions, error) {
+	options := make(uriOptions)
+	if query == "" {
+		return options, nil
+	}
+
+	for _, pair := range strings.Split(query, "&") {
+		if pair == "" {
+			continue
+		}
+
+		i := strings.Index(pair, "=")
+		if i < 0 {
+			return nil, newURIError("option %q has no value", pair)
+		}
+
+		key, err := url.QueryUnescape(pair[:i])
+		if err != nil {
+			return nil, newURIError("option %q: %v", pair, err)
+		}
+
+		value, err := url.PathUnescape(pair[i+1:])
+		if err != nil {
+			return nil, newURIError("option %q: %v", pair, err)
+		}
+
+		name := strings.ToLower(key)
+		if _, known := uriOptionSetters[name]; !known {
+			return nil, newURIError("unknown option %q", key)
+		}
+
+		if values, ok := options[name]; ok && name != "readpreferencetags" && values[0] != value {
+			return nil, newURIError("option %q is set twice with different values", key)
+		}
+
+		options[name] = append(options[name], value)
+	}
+
+	return options, nil
+}
+
+func applyOptions(options uriOptions, c *Config) error {
+	for name, values := range options {
+		for _, value := range values {
+			if err := uriOptionSetters[name](c, value); err != nil {
+				return newURIError("option %s=%s: %v", name, value, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateConfig reports the options which conflict with each other
+func validateConfig(c *Config, options uriOptions) error {
+	if options.has("tls") && options.has("ssl") && options["tls"][0] != options["ssl"][0] {
+		return newURIError("tls and ssl must have the same value")
+	}
+
+	if options.has("tlsinsecure") && (options.has("tlsallowinvalidcertificates") || options.has("tlsallowinvalidhostnames")) {
+		return newURIError("tlsInsecure can not be combined with tlsAllowInvalidCertificates or tlsAllowInvalidHostnames")
+	}
+
+	if (options.has("tls") && options["tls"][0] == "false") || (options.has("ssl") && options["ssl"][0] == "false") {
+		for name := range options {
+			if strings.HasPrefix(name, "tls") && name != "tls" {
+				return newURIError("%s can not be used with tls=false", name)
+			}
+		}
+	}
+
+	if c.Direct && (len(c.Hosts) > 1 || c.SRV) {
+		return newURIError("directConnection=true needs a single host and mongodb://")
+	}
+
+	if c.LoadBalanced && (len(c.Hosts) > 1 || c.ReplicaSet != "" || c.Direct) {
+		return newURIError("loadBalanced=true can not be used with several hosts, replicaSet or directConnection")
+	}
+
+	if !c.SRV && (options.has("srvmaxhosts") || options.has("srvservicename")) {
+		return newURIError("srvMaxHosts and srvServiceName need mongodb+srv://")
+	}
+
+	if c.SRVMaxHosts > 0 && c.ReplicaSet != "" {
+		return newURIError("srvMaxHosts can not be used with replicaSet")
+	}
+
+	if c.PoolLimit > 0 && c.MinPoolSize > c.PoolLimit {
+		return newURIError("minPoolSize is greater than maxPoolSize")
+	}
+
+	if rp := c.ReadPreference; rp != nil {
+		if rp.Mode == "" {
+			return newURIError("readPreferenceTags and maxStalenessSeconds need a readPreference")
+		}
+		if rp.Mode == Primary && (len(rp.Tags) > 0 || rp.MaxStaleness > 0) {
+			return newURIError("readPreference=primary can not have tags or maxStalenessSeconds")
+		}
+		if rp.MaxStaleness > 0 && rp.MaxStaleness < 90*time.Second {
+			return newURIError("maxStalenessSeconds must be at least 90")
+		}
+	}
+
+	if wc := c.WriteConcern; wc != nil && wc.Unacknowledged && wc.J {
+		return newURIError("w=0 can not be combined with journal=true")
+	}
+
+	switch c.AuthMechanism {
+	case "":
+	case "MONGODB-X509":
+		if c.Password != "" {
+			return newURIError("MONGODB-X509 does not use a password")
+		}
+	case "MONGODB-AWS":
+	default:
+		if c.User == "" {
+			return newURIError("authMechanism %s needs a user name", c.AuthMechanism)
+		}
+	}
+
+	return nil
+}
+
+func parseBool(value string) (bool, error) {
+	switch value {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+
+	return false, fmt.Errorf("must be true or false")
+}
+
+func parseInt(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("must be a non negative integer")
+	}
+
+	return n, nil
+}
+
+func parseMS(value string) (time.Duration, error) {
+	n, err := parseInt(value)
+	return time.Duration(n) * time.Millisecond, err
+}
+
+func boolSetter(set func(c *Config, v bool)) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		v, err := parseBool(value)
+		if err == nil {
+			set(c, v)
+		}
+		return err
+	}
+}
+
+func intSetter(set func(c *Config, n int)) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		n, err := parseInt(value)
+		if err == nil {
+			set(c, n)
+		}
+		return err
+	}
+}
+
+func msSetter(set func(c *Config, d time.Duration)) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		d, err := parseMS(value)
+		if err == nil {
+			set(c, d)
+		}
+		return err
+	}
+}
+
+func tlsSetter(set func(t *TLSConfig, v bool)) func(*Config, string) error {
+	return boolSetter(func(c *Config, v bool) {
+		if c.TLS == nil {
+			c.TLS = &TLSConfig{}
+		}
+		set(c.TLS, v)
+	})
+}
+
+func tlsFileSetter(set func(t *TLSConfig, v string)) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		if c.TLS == nil {
+			c.TLS = &TLSConfig{}
+		}
+		set(c.TLS, value)
+		return nil
+	}
+}
+
+func readPreference(c *Config) *ReadPreference {
+	if c.ReadPreference == nil {
+		c.ReadPreference = &ReadPreference{}
+	}
+
+	return c.ReadPreference
+}
+
+func writeConcern(c *Config) *WriteConcern {
+	if c.WriteConcern == nil {
+		c.WriteConcern = &WriteConcern{}
+	}
+
+	return c.WriteConcern
+}
+
+var authMechanisms = []string{
+	"SCRAM-SHA-1", "SCRAM-SHA-256", "MONGODB-CR", "MONGODB-X509", "GSSAPI", "PLAIN", "MONGODB-AWS",
+}
+
+var readPreferenceModes = []string{
+	Primary, PrimaryPreferred, Secondary, SecondaryPreferred, Nearest,
+}
+
+var compressors = []string{"snappy", "zlib", "zstd"}
+
+// uriOptionSetters are the supported options of a connection string
+var uriOptionSetters = map[string]func(c *Config, value string) error{
+	"replicaset": func(c *Config, value string) error {
+		if value == "" {
+			return fmt.Errorf("can not be empty")
+		}
+		c.ReplicaSet = value
+		return nil
+	},
+	"directconnection": boolSetter(func(c *Config, v bool) { c.Direct = v }),
+	"loadbalanced":     boolSetter(func(c *Config, v bool) { c.LoadBalanced = v }),
+	"srvmaxhosts":      intSetter(func(c *Config, n int) { c.SRVMaxHosts = n }),
+	"srvservicename": func(c *Config, value string) error {
+		c.SRVServiceName = value
+		return nil
+	},
+	"authsource": func(c *Config, value string) error {
+		if value == "" {
+			return fmt.Errorf("can not be empty")
+		}
+		c.AuthSource = value
+		return nil
+	},
+	"authmechanism": func(c *Config, value string) error {
+		for _, mechanism := range authMechanisms {
+			if strings.EqualFold(mechanism, value) {
+				c.AuthMechanism = mechanism
+				return nil
+			}
+		}
+		return fmt.Errorf("unknown mechanism")
+	},
+	"authmechanismproperties": func(c *Config, value string) error {
+		c.AuthMechanismProperties = make(map[string]string)
+		for _, pair := range strings.Split(value, ",") {
+			kv := strings.SplitN(pair, ":", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return fmt.Errorf("must be a list of key:value")
+			}
+			c.AuthMechanismProperties[kv[0]] = kv[1]
+		}
+		return nil
+	},
+	"tls": boolSetter(func(c *Config, v bool) {
+		if !v {
+			c.TLS = nil
+		} else if c.TLS == nil {
+			c.TLS = &TLSConfig{}
+		}
+	}),
+	"ssl": boolSetter(func(c *Config, v bool) {
+		if !v {
+			c.TLS = nil
+		} else if c.TLS == nil {
+			c.TLS = &TLSConfig{}
+		}
+	}),
+	"tlscafile":                     tlsFileSetter(func(t *TLSConfig, v string) { t.CAFile = v }),
+	"tlscertificatekeyfile":         tlsFileSetter(func(t *TLSConfig, v string) { t.CertificateKeyFile = v }),
+	"tlscertificatekeyfilepassword": tlsFileSetter(func(t *TLSConfig, v string) { t.CertificateKeyFilePassword = v }),
+	"tlsinsecure":                   tlsSetter(func(t *TLSConfig, v bool) { t.Insecure = v }),
+	"tlsallowinvalidcertificates":   tlsSetter(func(t *TLSConfig, v bool) { t.AllowInvalidCertificates = v }),
+	"tlsallowinvalidhostnames":      tlsSetter(func(t *TLSConfig, v bool) { t.AllowInvalidHostnames = v }),
+	"connecttimeoutms":              msSetter(func(c *Config, d time.Duration) { c.ConnectTimeout = d }),
+	"sockettimeoutms":               msSetter(func(c *Config, d time.Duration) { c.SocketTimeout = d }),
+	"serverselectiontimeoutms":      msSetter(func(c *Config, d time.Duration) { c.ServerSelectionTimeout = d }),
+	"heartbeatfrequencyms":          msSetter(func(c *Config, d time.Duration) { c.HeartbeatInterval = d }),
+	"localthresholdms":              msSetter(func(c *Config, d time.Duration) { c.LocalThreshold = d }),
+	"maxpoolsize":                   intSetter(func(c *Config, n int) { c.PoolLimit = n }),
+	"minpoolsize":                   intSetter(func(c *Config, n int) { c.MinPoolSize = n }),
+	"maxidletimems":                 msSetter(func(c *Config, d time.Duration) { c.MaxIdleTime = d }),
+	"maxconnecting":                 intSetter(func(c *Config, n int) { c.MaxConnecting = n }),
+	"appname": func(c *Config, value string) error {
+		c.AppName = value
+		return nil
+	},
+	"compressors": func(c *Config, value string) error {
+		c.Compressors = nil
+		for _, name := range strings.Split(value, ",") {
+			found := false
+			for _, compressor := range compressors {
+				if name == compressor {
+					found = true
+				}
+			}
+			if !found {
+				return fmt.Errorf("unknown compressor %q", name)
+			}
+			c.Compressors = append(c.Compressors, name)
+		}
+		return nil
+	},
+	"zlibcompressionlevel": func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < -1 || n > 9 {
+			return fmt.Errorf("must be between -1 and 9")
+		}
+		c.ZlibLevel = &n
+		return nil
+	},
+	"retrywrites": boolSetter(func(c *Config, v bool) { c.RetryWrites = &v }),
+	"retryreads":  boolSetter(func(c *Config, v bool) { c.RetryReads = &v }),
+	"readconcernlevel": func(c *Config, value string) error {
+		switch value {
+		case "local", "majority", "linearizable", "available", "snapshot":
+			c.ReadConcern = value
+			return nil
+		}
+		return fmt.Errorf("unknown read concern level")
+	},
+	"readpreference": func(c *Config, value string) error {
+		for _, mode := range readPreferenceModes {
+			if strings.EqualFold(mode, value) {
+				readPreference(c).Mode = mode
+				return nil
+			}
+		}
+		return fmt.Errorf("unknown read preference")
+	},
+	"readpreferencetags": func(c *Config, value string) error {
+		tags := bson.D{}
+		if value != "" {
+			for _, pair := range strings.Split(value, ",") {
+				kv := strings.SplitN(pair, ":", 2)
+				if len(kv) != 2 || kv[0] == "" {
+					return fmt.Errorf("must be a list of key:value")
+				}
+				tags = append(tags, bson.DocElem{Name: kv[0], Value: kv[1]})
+			}
+		}
+		rp := readPreference(c)
+		rp.Tags = append(rp.Tags, tags)
+		return nil
+	},
+	"maxstalenessseconds": func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < -1 {
+			return fmt.Errorf("must be -1 or a number of seconds")
+		}
+		if n > 0 {
+			readPreference(c).MaxStaleness = time.Duration(n) * time.Second
+		}
+		return nil
+	},
+	"w": func(c *Config, value string) error {
+		wc := writeConcern(c)
+		if n, err := strconv.Atoi(value); err == nil {
+			if n < 0 {
+				return fmt.Errorf("must not be negative")
+			}
+			wc.W, wc.WMode, wc.Unacknowledged = n, "", n == 0
+			return nil
+		}
+		wc.W, wc.WMode, wc.Unacknowledged = 0, value, false
+		return nil
+	},
+	"journal":    boolSetter(func(c *Config, v bool) { writeConcern(c).J = v }),
+	"wtimeoutms": msSetter(func(c *Config, d time.Duration) { writeConcern(c).WTimeout = d }),
+	// accepted for compatibility, they have no effect
+	"waitqueuetimeoutms":                   func(c *Config, value string) error { _, err := parseMS(value); return err },
+	"serverselectiontryonce":               func(c *Config, value string) error { _, err := parseBool(value); return err },
+	"tlsdisableocspendpointcheck":          func(c *Config, value string) error { _, err := parseBool(value); return err },
+	"tlsdisablecertificaterevocationcheck": func(c *Config, value string) error { _, err := parseBool(value); return err },
+}
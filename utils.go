@@ -44,6 +44,38 @@ func isZero(v reflect.Value) bool {
 	return false
 }
 
+// ParseTag returns the options of the bson and monger tags of a field the
+// way monger reads them: the upper case monger keys, and COLUMN, INLINE and
+// OMITEMPTY from the bson tag. Tools such as cmd/monger-gen use it.
+func ParseTag(tags reflect.StructTag) map[string]string {
+	return parseTagConfig(tags)
+}
+
+// mongerTagOptions splits the options of a monger tag on the commas, an
+// escaped comma \, belongs to the option so a pattern can contain commas.
+func mongerTagOptions(tag string) []string {
+	options := make([]string, 0)
+	if tag == "" {
+		return options
+	}
+
+	var option strings.Builder
+	for i := 0; i < len(tag); i++ {
+		switch {
+		case tag[i] == '\\' && i+1 < len(tag) && tag[i+1] == ',':
+			option.WriteByte(',')
+			i++
+		case tag[i] == ',':
+			options = append(options, option.String())
+			option.Reset()
+		default:
+			option.WriteByte(tag[i])
+		}
+	}
+
+	return append(options, option.String())
+}
+
 func parseTagConfig(tags reflect.StructTag) map[string]string {
 	conf := map[string]string{}
 	for index, str := range []string{tags.Get("bson"), tags.Get("monger")} {
@@ -67,8 +99,7 @@ func parseTagConfig(tags reflect.StructTag) map[string]string {
 
 			continue
 		}
-		tags := strings.Split(str, ",")
-		for _, value := range tags {
+		for _, value := range mongerTagOptions(str) {
 			v := strings.Split(value, "=")
 			k := strings.TrimSpace(strings.ToUpper(v[0]))
 			if k == "COLUMN" {
@@ -19,3 +19,16 @@ func TestSnakeStringCamel(t *testing.T) {
 
 	assert.Equal(t, ss, "user_club_profile")
 }
+
+func TestParseTagPattern(t *testing.T) {
+	assert.Equal(t, []string{"required", "min=2", "pattern=^[a-z]{2,4}$"}, mongerTagOptions(`required,min=2,pattern=^[a-z]{2\,4}$`))
+	assert.Equal(t, []string{"pattern=^a+$", "required"}, mongerTagOptions("pattern=^a+$,required"))
+	assert.Equal(t, []string{`pattern=^\d+$`}, mongerTagOptions(`pattern=^\d+$`))
+	assert.Equal(t, []string{"unique"}, mongerTagOptions("unique"))
+	assert.Empty(t, mongerTagOptions(""))
+
+	tags := ParseTag(`bson:"code" monger:"pattern=^(a|b)\\,c=d$,required"`)
+	assert.Equal(t, "^(a|b),c=d$", tags["PATTERN"])
+	assert.Equal(t, "REQUIRED", tags["REQUIRED"])
+	assert.Equal(t, "code", tags["COLUMN"])
+}
@@ -0,0 +1,375 @@
+package monger
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+/*
+ValidatorFunc is a custom validation rule, value is the value of the field
+and param the text after "=" in the tag, it is empty without one:
+
+	monger.RegisterValidator("slug", func(value interface{}, param string) error {
+		if s, _ := value.(string); !slugPattern.MatchString(s) {
+			return errors.New("must be a slug")
+		}
+		return nil
+	})
+
+	type Post struct {
+		monger.Schema `json:",inline" bson:",inline"`
+		Slug          string `bson:"slug" monger:"required,slug"`
+	}
+*/
+type ValidatorFunc func(value interface{}, param string) error
+
+var validators = struct {
+	sync.RWMutex
+	byName map[string]ValidatorFunc
+}{byName: make(map[string]ValidatorFunc)}
+
+// RegisterValidator registers a custom rule by name, the name is case insensitive
+func RegisterValidator(name string, fn ValidatorFunc) {
+	validators.Lock()
+	defer validators.Unlock()
+
+	validators.byName[strings.ToUpper(name)] = fn
+}
+
+func getValidator(name string) (ValidatorFunc, bool) {
+	validators.RLock()
+	defer validators.RUnlock()
+
+	fn, ok := validators.byName[name]
+	return fn, ok
+}
+
+// Rule is a validation rule of a field declared in the monger tag
+type Rule struct {
+	Name  string
+	Param string
+	// pattern is the compiled param of a pattern rule
+	pattern *regexp.Regexp
+	err     error
+}
+
+// FieldError is a failed rule of a field, Field is the column name
+type FieldError struct {
+	Field   string
+	Rule    string
+	Message string
+}
+
+func (err *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", err.Field, err.Message)
+}
+
+// the built in rules, in the order they are checked
+var builtinRules = []string{"REQUIRED", "MIN", "MAX", "PATTERN", "ENUM"}
+
+// parseRules returns the built in rules of a tag map, custom rules are
+// looked up when a document is validated because they can be registered
+// after the schema is parsed.
+func parseRules(tagMap map[string]string) []*Rule {
+	rules := make([]*Rule, 0)
+	for _, name := range builtinRules {
+		param, ok := tagMap[name]
+		if !ok {
+			continue
+		}
+
+		rule := &Rule{Name: strings.ToLower(name), Param: param}
+		switch name {
+		case "REQUIRED":
+			rule.Param = ""
+		case "MIN", "MAX":
+			if _, err := strconv.ParseFloat(param, 64); err != nil {
+				rule.err = fmt.Errorf("invalid %s %q", rule.Name, param)
+			}
+		case "PATTERN":
+			rule.pattern, rule.err = regexp.Compile(param)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+// columnName is the bson key of the field
+func (field *SchemaField) columnName() string {
+	if field.ColumnName != "" {
+		return field.ColumnName
+	}
+
+	return strings.ToLower(field.Name)
+}
+
+// customRules returns the registered validators used by the field tag
+func (field *SchemaField) customRules() []*Rule {
+	names := make([]string, 0)
+	for name := range field.TagMap {
+		if _, ok := getValidator(name); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	rules := make([]*Rule, 0, len(names))
+	for _, name := range names {
+		param := field.TagMap[name]
+		if param == name {
+			// a tag without "=" maps to its own name
+			param = ""
+		}
+		rules = append(rules, &Rule{Name: strings.ToLower(name), Param: param})
+	}
+
+	return rules
+}
+
+// validate checks value against the rules of the field, rules other than
+// required are skipped for zero values.
+func (field *SchemaField) validate(value reflect.Value) []error {
+	errs := make([]error, 0)
+	fail := func(rule string, format string, args ...interface{}) {
+		errs = append(errs, &FieldError{
+			Field:   field.columnName(),
+			Rule:    rule,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	for value.IsValid() && (value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface) && !value.IsNil() {
+		value = value.Elem()
+	}
+
+	zero := !value.IsValid() || isZero(value)
+	for _, rule := range append(field.Rules, field.customRules()...) {
+		if rule.err != nil {
+			fail(rule.Name, "%v", rule.err)
+			continue
+		}
+
+		if rule.Name == "required" {
+			if zero {
+				fail(rule.Name, "is required")
+			}
+			continue
+		}
+
+		if zero {
+			continue
+		}
+
+		switch rule.Name {
+		case "min", "max":
+			limit, _ := strconv.ParseFloat(rule.Param, 64)
+			n, isLength, ok := measure(value)
+			if !ok {
+				fail(rule.Name, "can not be measured")
+				continue
+			}
+
+			if rule.Name == "min" && n < limit {
+				if isLength {
+					fail(rule.Name, "length must be at least %v", rule.Param)
+				} else {
+					fail(rule.Name, "must be at least %v", rule.Param)
+				}
+			}
+
+			if rule.Name == "max" && n > limit {
+				if isLength {
+					fail(rule.Name, "length must be at most %v", rule.Param)
+				} else {
+					fail(rule.Name, "must be at most %v", rule.Param)
+				}
+			}
+		case "pattern":
+			if value.Kind() != reflect.String || !rule.pattern.MatchString(value.String()) {
+				fail(rule.Name, "must match %v", rule.Param)
+			}
+		case "enum":
+			s := fmt.Sprint(value.Interface())
+			found := false
+			for _, option := range strings.Split(rule.Param, "|") {
+				if option == s {
+					found = true
+					break
+				}
+			}
+			if !found {
+				fail(rule.Name, "must be one of %v", strings.Replace(rule.Param, "|", ", ", -1))
+			}
+		default:
+			if fn, ok := getValidator(strings.ToUpper(rule.Name)); ok {
+				if err := fn(value.Interface(), rule.Param); err != nil {
+					fail(rule.Name, "%v", err)
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+// measure returns the number checked by min and max, the length of strings,
+// slices and maps or the value of numbers.
+func measure(v reflect.Value) (n float64, isLength bool, ok bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(len([]rune(v.String()))), true, true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), true, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), false, true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false, true
+	}
+
+	return 0, false, false
+}
+
+func hasRules(field *SchemaField) bool {
+	return len(field.Rules) > 0 || len(field.customRules()) > 0
+}
+
+func newValidationError(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+
+	return &ValidationError{
+		MongerQueryError: NewError("[monger] validation failed: " + strings.Join(messages, "; ")),
+		Errors:           errs,
+	}
+}
+
+// validateDocument checks every field of a schema document
+func validateDocument(schemaStruct *SchemaStruct, doc interface{}) error {
+	return validateFields(schemaStruct, doc, false)
+}
+
+// validateNewDocument checks a document to create, the unset sequence
+// fields are skipped since their value is allocated once it is valid.
+func validateNewDocument(schemaStruct *SchemaStruct, doc interface{}) error {
+	return validateFields(schemaStruct, doc, true)
+}
+
+func validateFields(schemaStruct *SchemaStruct, doc interface{}, created bool) error {
+	docv := reflect.ValueOf(doc)
+	for docv.Kind() == reflect.Ptr {
+		if docv.IsNil() {
+			return nil
+		}
+		docv = docv.Elem()
+	}
+
+	if docv.Kind() != reflect.Struct {
+		return nil
+	}
+
+	errs := make([]error, 0)
+	for _, field := range schemaStruct.Fields {
+		if field.IsIgnored || !hasRules(field) {
+			continue
+		}
+
+		value := docv.FieldByIndex(field.InlineIndex)
+		if _, sequence := field.TagMap["SEQUENCE"]; created && sequence && isZero(value) {
+			continue
+		}
+
+		errs = append(errs, field.validate(value)...)
+	}
+
+	return newValidationError(errs)
+}
+
+// validateUpdate checks an update, a schema document is checked as a whole
+// and an update document field by field: the values of $set and
+// $setOnInsert, and $unset of required fields.
+func validateUpdate(schemaStruct *SchemaStruct, update interface{}) error {
+	if _, ok := update.(Schemer); ok {
+		return validateDocument(schemaStruct, update)
+	}
+
+	m, ok := update.(bson.M)
+	if !ok {
+		return validateDocument(schemaStruct, update)
+	}
+
+	columns := make(map[string]*SchemaField)
+	for _, field := range schemaStruct.Fields {
+		columns[field.columnName()] = field
+	}
+
+	errs := make([]error, 0)
+	check := func(values interface{}, unset bool) {
+		var fields map[string]interface{}
+		switch v := values.(type) {
+		case bson.M:
+			fields = v
+		case map[string]interface{}:
+			fields = v
+		case Schemer:
+			if err := validateDocument(schemaStruct, v); err != nil {
+				errs = append(errs, err.(*ValidationError).Errors...)
+			}
+			return
+		default:
+			return
+		}
+
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			field, ok := columns[key]
+			if !ok || !hasRules(field) {
+				continue
+			}
+
+			if unset {
+				errs = append(errs, field.validate(reflect.Value{})...)
+				continue
+			}
+			errs = append(errs, field.validate(reflect.ValueOf(fields[key]))...)
+		}
+	}
+
+	hasOperator := false
+	for key, values := range m {
+		switch key {
+		case "$set", "$setOnInsert":
+			check(values, false)
+		case "$unset":
+			check(values, true)
+		}
+		hasOperator = hasOperator || strings.HasPrefix(key, "$")
+	}
+
+	if !hasOperator {
+		check(m, false)
+	}
+
+	return newValidationError(errs)
+}
@@ -0,0 +1,105 @@
+package monger
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type Account struct {
+	Schema   `json:",inline" bson:",inline"`
+	Username string   `json:"username" bson:"username" monger:"required,min=3,max=16,pattern=^[a-z0-9_]+$"`
+	Status   string   `json:"status" bson:"status,omitempty" monger:"enum=active|disabled"`
+	Age      int      `json:"age" bson:"age,omitempty" monger:"min=18"`
+	Tags     []string `json:"tags" bson:"tags,omitempty" monger:"max=2"`
+	Code     string   `json:"code" bson:"code,omitempty" monger:"uppercase"`
+}
+
+func init() {
+	RegisterValidator("uppercase", func(value interface{}, param string) error {
+		if s, _ := value.(string); s != strings.ToUpper(s) {
+			return errors.New("must be upper case")
+		}
+		return nil
+	})
+}
+
+func fieldErrors(err error) []string {
+	fields := make([]string, 0)
+	if verr, ok := err.(*ValidationError); ok {
+		for _, e := range verr.Errors {
+			fields = append(fields, e.(*FieldError).Field+":"+e.(*FieldError).Rule)
+		}
+	}
+
+	return fields
+}
+
+func TestValidateDocument(t *testing.T) {
+	schemaStruct := GetSchemaStruct(new(Account))
+
+	assert.NoError(t, validateDocument(schemaStruct, &Account{Username: "alice", Status: "active", Age: 20, Code: "AB"}))
+
+	err := validateDocument(schemaStruct, &Account{
+		Status: "deleted",
+		Age:    12,
+		Tags:   []string{"a", "b", "c"},
+		Code:   "ab",
+	})
+	assert.IsType(t, &ValidationError{}, err)
+	assert.Equal(t, []string{
+		"username:required",
+		"status:enum",
+		"age:min",
+		"tags:max",
+		"code:uppercase",
+	}, fieldErrors(err))
+
+	err = validateDocument(schemaStruct, &Account{Username: "Al"})
+	assert.Equal(t, []string{"username:min", "username:pattern"}, fieldErrors(err))
+}
+
+func TestValidateUpdate(t *testing.T) {
+	schemaStruct := GetSchemaStruct(new(Account))
+
+	assert.NoError(t, validateUpdate(schemaStruct, bson.M{"$set": bson.M{"status": "disabled"}, "$inc": bson.M{"age": 1}}))
+
+	err := validateUpdate(schemaStruct, bson.M{"$set": bson.M{"status": "unknown", "age": 30}})
+	assert.Equal(t, []string{"status:enum"}, fieldErrors(err))
+
+	err = validateUpdate(schemaStruct, bson.M{"$unset": bson.M{"username": ""}})
+	assert.Equal(t, []string{"username:required"}, fieldErrors(err))
+
+	err = validateUpdate(schemaStruct, &Account{Username: "alice", Age: 3})
+	assert.Equal(t, []string{"age:min"}, fieldErrors(err))
+}
+
+func TestPatternWithComma(t *testing.T) {
+	type Country struct {
+		Schema `json:",inline" bson:",inline"`
+		Code   string `json:"code" bson:"code" monger:"pattern=^[a-z]{2\\,4}$,required"`
+	}
+
+	schemaStruct := GetSchemaStruct(new(Country))
+	assert.NoError(t, validateDocument(schemaStruct, &Country{Code: "ab"}))
+	assert.Equal(t, []string{"code:pattern"}, fieldErrors(validateDocument(schemaStruct, &Country{Code: "abcde"})))
+	// the rule following a pattern applies
+	assert.Equal(t, []string{"code:required"}, fieldErrors(validateDocument(schemaStruct, &Country{})))
+	assert.Empty(t, LintSchema(new(Country)))
+}
+
+func TestValidateNewDocument(t *testing.T) {
+	type Order struct {
+		Schema `json:",inline" bson:",inline"`
+		Number int64 `json:"number" bson:"number" monger:"sequence=order_no,required,min=100"`
+	}
+
+	// the sequence assigns the number once the document is valid
+	schemaStruct := GetSchemaStruct(new(Order))
+	assert.NoError(t, validateNewDocument(schemaStruct, &Order{}))
+	assert.Equal(t, []string{"number:required"}, fieldErrors(validateDocument(schemaStruct, &Order{})))
+	assert.Equal(t, []string{"number:min"}, fieldErrors(validateNewDocument(schemaStruct, &Order{Number: 7})))
+}
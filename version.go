@@ -0,0 +1,135 @@
+package monger
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// versionField returns the field tagged with version, nil without one
+func (s *SchemaStruct) versionField() *SchemaField {
+	for _, field := range s.Fields {
+		if _, ok := field.TagMap["VERSION"]; ok && !field.IsIgnored {
+			return field
+		}
+	}
+
+	return nil
+}
+
+/*
+versionUpdate increments the version of an update, the schema document of
+a $set is bumped and any other update document gets an $inc:
+
+	type Conversation struct {
+		monger.Schema `json:",inline" bson:",inline"`
+		Version       int `json:"version" bson:"version" monger:"version"`
+	}
+
+For a schema document it returns the filter matching its current version
+and the function restoring the version when the update fails, they are
+nil for the other updates. A document without version matches version 0.
+*/
+func versionUpdate(schemaStruct *SchemaStruct, update interface{}) (bson.M, func(), error) {
+	field := schemaStruct.versionField()
+	m, ok := update.(bson.M)
+	if field == nil || !ok {
+		return nil, nil, nil
+	}
+
+	column := field.columnName()
+	doc, ok := m["$set"].(Schemer)
+	if !ok {
+		hasOperator := false
+		for key, values := range m {
+			hasOperator = hasOperator || strings.HasPrefix(key, "$")
+			if isTouched(updateKeys(values), column) {
+				// the caller manages the version
+				return nil, nil, nil
+			}
+		}
+
+		if hasOperator {
+			inc, _ := m["$inc"].(bson.M)
+			if inc == nil {
+				inc = bson.M{}
+				m["$inc"] = inc
+			}
+			inc[column] = 1
+		}
+
+		return nil, nil, nil
+	}
+
+	docv := reflect.ValueOf(doc)
+	for docv.Kind() == reflect.Ptr {
+		docv = docv.Elem()
+	}
+
+	value := docv.FieldByIndex(field.InlineIndex)
+	current := reflect.New(value.Type()).Elem()
+	current.Set(value)
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value.SetInt(value.Int() + 1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value.SetUint(value.Uint() + 1)
+	default:
+		return nil, nil, &InvalidParamsError{NewError(fmt.Sprintf("[monger] the version %s must be an integer, got %v", field.Name, value.Type()))}
+	}
+
+	filter := bson.M{column: current.Interface()}
+	if isZero(current) {
+		filter = bson.M{column: bson.M{"$in": []interface{}{current.Interface(), nil}}}
+	}
+
+	return filter, func() { value.Set(current) }, nil
+}
+
+// updateVersioned updates the first document matched by selector, the
+// version of a schema document is checked and incremented.
+func (q *query) updateVersioned(selector bson.M, update interface{}) error {
+	filter, restore, err := versionUpdate(q.schemaStruct, update)
+	if err != nil {
+		return err
+	}
+
+	return q.updateFiltered(selector, update, filter, restore)
+}
+
+// updateFiltered updates the first document matched by selector and the
+// version filter of versionUpdate, restore runs when it matches nothing.
+func (q *query) updateFiltered(selector bson.M, update interface{}, filter bson.M, restore func()) error {
+	if filter == nil {
+		return q.coll().Update(q.context(), selector, update)
+	}
+
+	versioned := bson.M{}
+	for k, v := range selector {
+		versioned[k] = v
+	}
+	for k, v := range filter {
+		versioned[k] = v
+	}
+
+	err := q.coll().Update(q.context(), versioned, update)
+	if err == nil {
+		return nil
+	}
+
+	restore()
+	if _, ok := err.(*NotFoundError); !ok {
+		return err
+	}
+
+	// nothing matched: the document is gone or its version moved
+	if n, e := q.coll().Count(q.context(), selector); e != nil || n == 0 {
+		return err
+	}
+
+	return &ConcurrentModificationError{NewError(fmt.Sprintf(
+		"[monger] the document was modified since version %v was read", filter[q.schemaStruct.versionField().columnName()],
+	))}
+}